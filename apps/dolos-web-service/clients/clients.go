@@ -2,49 +2,169 @@ package clients
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
 	"github.com/hyperbolic/dolos-web-service/supabase"
 )
 
 var (
-	S3       *s3.Client
-	Supabase *supabase.Client
+	Storage    storage.ObjectStore
+	Supabase   *supabase.Client
+	Policy     *policy.Policy
+	Repository repository.Store
+	Media      repository.MediaRepository
+	Parents    repository.ParentRepository
+	Logs       repository.LogRepository
+	Sessions   repository.SessionRepository
 )
 
-// Init initializes S3 and Supabase clients (call after loading env vars)
-func Init() {
-	// Initialize S3 client for Cloudflare R2
-	accountId := os.Getenv("CLOUDFLARE_ACCOUNT_ID")
-	accessKeyId := os.Getenv("CLOUDFLARE_R2_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("CLOUDFLARE_R2_SECRET_ACCESS_KEY")
+// Init initializes the object store, Supabase client, repository and media policy (call after loading env vars)
+func Init() error {
+	store, err := newObjectStore(os.Getenv("STORAGE_BACKEND"))
+	if err != nil {
+		return err
+	}
 
-	if accountId == "" || accessKeyId == "" || secretAccessKey == "" {
-		log.Fatal("R2 credentials not set. Check CLOUDFLARE_ACCOUNT_ID, CLOUDFLARE_R2_ACCESS_KEY_ID, CLOUDFLARE_R2_SECRET_ACCESS_KEY")
+	Storage = store
+	Supabase = supabase.NewClient()
+	records := repository.NewSupabaseStore(Supabase)
+	Media, Parents, Logs, Sessions = records, records, records, records
+	Policy = policy.New(repository.NewPolicyLoader(Media, Parents))
+	if Repository, err = newRepository(os.Getenv("DATA_BACKEND"), records); err != nil {
+		return err
 	}
+	log.Println("Storage and Supabase clients initialized successfully")
+	return nil
+}
 
-	r2Resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		return aws.Endpoint{
-			URL: fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountId),
-		}, nil
-	})
+// newObjectStore builds the storage backend selected by STORAGE_BACKEND (r2/s3, local or memory)
+func newObjectStore(backend string) (storage.ObjectStore, error) {
+	switch backend {
+	case "", "r2", "s3":
+		return newS3Store()
+	case "local":
+		return newLocalStore()
+	case "memory":
+		log.Println("Using in-memory storage, objects are lost on restart")
+		return storage.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (expected r2, s3, local or memory)", backend)
+	}
+}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithEndpointResolverWithOptions(r2Resolver),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, secretAccessKey, "")),
-		config.WithRegion("auto"),
-	)
-	if err != nil {
-		log.Fatal("Failed to load R2 config:", err)
+// newS3Store builds an S3-compatible store. The S3_* variables take precedence,
+// the CLOUDFLARE_* variables keep existing R2 deployments working.
+func newS3Store() (storage.ObjectStore, error) {
+	cfg := storage.S3Config{
+		Endpoint:           os.Getenv("S3_ENDPOINT"),
+		Region:             envOr("S3_REGION", "auto"),
+		Bucket:             envOr("S3_BUCKET", os.Getenv("CLOUDFLARE_R2_BUCKET_NAME")),
+		AccessKeyID:        envOr("S3_ACCESS_KEY_ID", os.Getenv("CLOUDFLARE_R2_ACCESS_KEY_ID")),
+		SecretAccessKey:    envOr("S3_SECRET_ACCESS_KEY", os.Getenv("CLOUDFLARE_R2_SECRET_ACCESS_KEY")),
+		UsePathStyle:       envBool("S3_FORCE_PATH_STYLE"),
+		InsecureSkipVerify: envBool("S3_INSECURE_SKIP_VERIFY"),
+		PublicBaseURL:      os.Getenv("STORAGE_PUBLIC_BASE_URL"),
 	}
 
-	S3 = s3.NewFromConfig(cfg)
-	Supabase = supabase.NewClient()
-	log.Println("R2 and Supabase clients initialized successfully")
+	if cfg.Endpoint == "" {
+		accountId := os.Getenv("CLOUDFLARE_ACCOUNT_ID")
+		if accountId == "" {
+			return nil, fmt.Errorf("storage endpoint not set. Set S3_ENDPOINT (or CLOUDFLARE_ACCOUNT_ID for R2) or STORAGE_BACKEND=local")
+		}
+		cfg.Endpoint = fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountId)
+	}
+
+	if cfg.PublicBaseURL == "" {
+		if legacy := os.Getenv("CLOUDFLARE_R2_PUBLIC_URL"); legacy != "" {
+			log.Println("CLOUDFLARE_R2_PUBLIC_URL is deprecated, use STORAGE_PUBLIC_BASE_URL")
+			cfg.PublicBaseURL = legacy
+		}
+	}
+
+	if cfg.PublicBaseURL == "" && !cfg.UsePathStyle {
+		return nil, fmt.Errorf("public media URL not set. Set STORAGE_PUBLIC_BASE_URL to the bucket's public domain (the R2 API endpoint isn't publicly readable)")
+	}
+
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("storage credentials not set. Check S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY (or CLOUDFLARE_R2_ACCESS_KEY_ID and CLOUDFLARE_R2_SECRET_ACCESS_KEY)")
+	}
+	if cfg.InsecureSkipVerify {
+		log.Println("S3_INSECURE_SKIP_VERIFY is set, TLS certificates are not verified")
+	}
+
+	log.Printf("Using S3-compatible storage at %s (bucket %s, region %s, path-style %v)", cfg.Endpoint, cfg.Bucket, cfg.Region, cfg.UsePathStyle)
+	return storage.NewS3StoreFromConfig(context.TODO(), cfg)
+}
+
+// newRepository builds the data backend selected by DATA_BACKEND (supabase or postgres).
+// The Supabase backend is the store the reads already go through.
+func newRepository(backend string, records *repository.SupabaseStore) (repository.Store, error) {
+	switch backend {
+	case "", "supabase":
+		return records, nil
+	case "postgres":
+		databaseURL := os.Getenv("DATABASE_URL")
+		if databaseURL == "" {
+			return nil, fmt.Errorf("DATA_BACKEND=postgres needs DATABASE_URL")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		store, err := repository.OpenPostgres(ctx, databaseURL)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Using Postgres for multi-row writes")
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown DATA_BACKEND %q (expected supabase or postgres)", backend)
+	}
+}
+
+func newLocalStore() (storage.ObjectStore, error) {
+	dir := os.Getenv("LOCAL_STORAGE_DIR")
+	if dir == "" {
+		dir = "tmp/storage"
+	}
+
+	baseURL := os.Getenv("LOCAL_STORAGE_BASE_URL")
+	if baseURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		baseURL = "http://localhost:" + port
+	}
+
+	secret := []byte(os.Getenv("LOCAL_STORAGE_SECRET"))
+	if len(secret) == 0 {
+		// Signed URLs won't survive a restart, which is fine for local development
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate local storage secret: %w", err)
+		}
+		log.Println("LOCAL_STORAGE_SECRET not set, using a random signing secret")
+	}
+
+	log.Printf("Using local storage in %s served from %s%s", dir, baseURL, storage.LocalRoutePrefix)
+	return storage.NewLocalStore(dir, baseURL, secret)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envBool(key string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(key))
+	return value
 }
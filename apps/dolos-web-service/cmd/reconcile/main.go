@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/reconcile"
+	"github.com/joho/godotenv"
+)
+
+// Reports drift between the storage bucket and the TrickMedia/ComboMedia tables, and
+// fixes it with --apply. Without --apply nothing is changed.
+func main() {
+	dryRun := flag.Bool("dry-run", true, "report drift without changing anything")
+	apply := flag.Bool("apply", false, "fix the drift that is found")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	only := flag.String("only", "", "comma-separated categories to check: orphans, missing, thumbnails (default all)")
+	minAge := flag.Duration("min-age", 24*time.Hour, "skip objects and rows newer than this, they may be mid-upload")
+	flag.Parse()
+
+	dryRunSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "dry-run" {
+			dryRunSet = true
+		}
+	})
+	if *apply && dryRunSet && *dryRun {
+		log.Fatal("--apply and --dry-run can't be used together")
+	}
+
+	kinds := map[reconcile.Kind]bool{}
+	for _, category := range strings.Split(*only, ",") {
+		if category = strings.TrimSpace(category); category == "" {
+			continue
+		}
+		categoryKinds, ok := reconcile.Categories[category]
+		if !ok {
+			log.Fatalf("unknown category %q (expected orphans, missing or thumbnails)", category)
+		}
+		for _, kind := range categoryKinds {
+			kinds[kind] = true
+		}
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+	if err := clients.Init(); err != nil {
+		log.Fatal("Failed to initialize clients: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := reconcile.Run(ctx, reconcile.Options{Apply: *apply, Kinds: kinds, MinAge: *minAge})
+	if err != nil {
+		log.Fatal("Reconciliation failed: ", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printReport(report)
+	}
+
+	if report.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(report *reconcile.Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tTABLE\tMEDIA\tACTION\tRESULT\tDETAIL")
+	for _, drift := range report.Drifts {
+		result := "-"
+		switch {
+		case drift.Error != "":
+			result = "error: " + drift.Error
+		case drift.Applied:
+			result = "fixed"
+		}
+		detail := drift.Detail
+		if drift.Key != "" {
+			detail += " (" + drift.Key + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", drift.Kind, drift.Table, drift.MediaID, drift.Action, result, detail)
+	}
+	w.Flush()
+
+	counts := make([]string, 0, len(report.Counts))
+	for kind, count := range report.Counts {
+		counts = append(counts, fmt.Sprintf("%s=%d", kind, count))
+	}
+	sort.Strings(counts)
+	mode := "dry run, nothing changed"
+	if !report.DryRun {
+		mode = fmt.Sprintf("applied, %d errors", report.Errors)
+	}
+	fmt.Printf("\n%d objects, %d rows, %d drifts [%s] (%s)\n", report.Objects, report.Rows, len(report.Drifts), strings.Join(counts, " "), mode)
+}
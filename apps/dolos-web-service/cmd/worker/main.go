@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/worker"
+	"github.com/joho/godotenv"
+)
+
+// Standalone processing worker. It picks up completed uploads by polling, so it can run
+// next to (or instead of) the in-process worker enabled with WORKER_ENABLED=true.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	if err := clients.Init(); err != nil {
+		log.Fatal("Failed to initialize clients: ", err)
+	}
+
+	w, err := worker.FromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize worker: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	w.Run(ctx)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// feedTypes are the media types merged into a user's feed
+var feedTypes = []types.VideoType{types.VideoTypeTrick, types.VideoTypeCombo}
+
+// GetUserMedia returns a page of a user's completed media across all their tricks and
+// combos, newest first by default. ?type= narrows it to one type; paging, sorting and
+// filters are the same as GetVideos.
+func GetUserMedia(c *gin.Context) {
+	userId := c.Param("userId")
+	caller := policy.CallerFromContext(c)
+
+	videoTypes := feedTypes
+	if videoType := types.VideoType(c.Query("type")); videoType != "" {
+		if _, ok := types.GetMediaConfig(videoType); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type query parameter"})
+			return
+		}
+		videoTypes = []types.VideoType{videoType}
+	}
+
+	filter, page, ok := listParams(c)
+	if !ok {
+		return
+	}
+	filter.UserID = userId
+	filter.Verified = true
+
+	// Every table is listed with the same page request, the merge keeps the first page.Limit
+	pages := make([]repository.MediaPage, len(videoTypes))
+	scopes := make([]policy.ListScope, len(videoTypes))
+	for i, videoType := range videoTypes {
+		cfg := types.MediaConfigs[videoType]
+		scope, err := clients.Policy.AuthorizeUserList(caller, cfg, userId)
+		if err != nil {
+			policy.Respond(c, err)
+			return
+		}
+		typeFilter := filter
+		typeFilter.PublicOnly = scope.PublicOnly
+		pages[i], err = clients.Media.ListMedia(c.Request.Context(), cfg, typeFilter, page)
+		if err != nil {
+			log.Printf("Failed to fetch %s for user %s: %v", cfg.Table, userId, err)
+			c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to fetch media", "details": err.Error()})
+			return
+		}
+		scopes[i] = scope
+	}
+
+	merged, from := repository.MergePages(page, pages...)
+	feed := types.MediaFeed{Items: make([]types.MediaItem, 0, len(merged.Media)), Total: merged.Total}
+	for i := range merged.Media {
+		feed.Items = append(feed.Items, mediaItem(videoTypes[from[i]], &merged.Media[i], scopes[from[i]]))
+	}
+	if merged.Next != nil {
+		feed.NextCursor = merged.Next.Encode()
+	}
+	c.JSON(http.StatusOK, feed)
+}
+
+// mediaItem annotates a media row with its type, parent and log. Logs that aren't public
+// are left out when the scope asks for it.
+func mediaItem(videoType types.VideoType, media *repository.Media, scope policy.ListScope) types.MediaItem {
+	item := types.MediaItem{VideoMetadata: media.Video(), Type: videoType}
+	if media.Parent != nil {
+		item.Name = media.Parent.Name
+		if videoType == types.VideoTypeTrick {
+			item.TrickID = media.Parent.ParentID
+		} else {
+			item.ComboID = media.Parent.ParentID
+		}
+	}
+
+	linked := media.Log
+	if scope.PublicLogsOnly && (linked == nil || linked.IsPublic == nil || !*linked.IsPublic) {
+		item.LogID = ""
+		return item
+	}
+	if linked != nil {
+		item.Log = &types.LogSummary{ID: linked.ID, Landed: linked.Landed, LoggedAt: linked.LoggedAt}
+		if linked.SessionID != nil {
+			item.Log.SessionID = *linked.SessionID
+		}
+	}
+	return item
+}
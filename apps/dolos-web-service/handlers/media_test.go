@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestGetUserMediaMergesTricksAndCombos(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1", Name: "Kickflip"})
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-2", UserID: "bob", ParentID: "trick-1", Name: "Kickflip"})
+	records.AddParent(comboCfg, repository.Parent{ID: "combo-1", UserID: "alice", Name: "Morning flow"})
+
+	public, private := true, false
+	session := "session-1"
+	records.AddLog(trickCfg, repository.Log{ID: "log-public", SessionID: &session, IsPublic: &public, LoggedAt: "2026-01-01T00:00:00Z"})
+	records.AddLog(trickCfg, repository.Log{ID: "log-private", IsPublic: &private, LoggedAt: "2026-01-02T00:00:00Z"})
+	logPublic, logPrivate := "log-public", "log-private"
+
+	add := func(cfg types.MediaConfig, id, parent, createdAt string, isPublic bool, logID *string) {
+		records.AddMedia(cfg, repository.Media{ID: id, ParentRecordID: parent, MediaType: "video", UploadStatus: "completed",
+			CreatedAt: createdAt, Public: isPublic, LogID: logID})
+	}
+	add(trickCfg, "t1", "ut-1", "2026-01-01T00:00:00Z", false, &logPublic)
+	add(comboCfg, "c1", "combo-1", "2026-01-02T00:00:00Z", true, nil)
+	add(trickCfg, "t2", "ut-1", "2026-01-03T00:00:00Z", false, &logPrivate)
+	add(comboCfg, "c2", "combo-1", "2026-01-04T00:00:00Z", false, nil)
+	add(trickCfg, "bob", "ut-2", "2026-01-05T00:00:00Z", false, nil)
+
+	feed := func(userId, query string) types.MediaFeed {
+		t.Helper()
+		w := serve("GET", "/users/alice/media?"+query, userId, nil)
+		var feed types.MediaFeed
+		if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+		}
+		return feed
+	}
+	ids := func(feed types.MediaFeed) string {
+		ids := make([]string, len(feed.Items))
+		for i, item := range feed.Items {
+			ids[i] = item.ID
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// The owner pages through both tables with one cursor
+	first := feed("alice", "limit=3&count=exact")
+	if got := ids(first); got != "c2,t2,c1" || first.NextCursor == "" || *first.Total != 4 {
+		t.Fatalf("unexpected first page %s (next %q, total %v)", got, first.NextCursor, first.Total)
+	}
+	second := feed("alice", "limit=3&cursor="+first.NextCursor)
+	if got := ids(second); got != "t1" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page %s (next %q)", got, second.NextCursor)
+	}
+
+	item := second.Items[0]
+	if item.Type != types.VideoTypeTrick || item.Name != "Kickflip" || item.TrickID != "trick-1" || item.UserID != "alice" {
+		t.Fatalf("unexpected annotations %+v", item)
+	}
+	if item.Log == nil || item.Log.ID != "log-public" || item.Log.SessionID != "session-1" {
+		t.Fatalf("expected the linked log, got %+v", item.Log)
+	}
+	if combo := first.Items[0]; combo.Type != types.VideoTypeCombo || combo.Name != "Morning flow" || combo.ComboID != "combo-1" {
+		t.Fatalf("unexpected combo annotations %+v", combo)
+	}
+
+	// Others don't see private combo media or private logs
+	others := feed("bob", "")
+	if got := ids(others); got != "t2,c1,t1" {
+		t.Fatalf("expected t2,c1,t1 for another user, got %s", got)
+	}
+	if others.Items[0].Log != nil || others.Items[0].LogID != "" {
+		t.Fatalf("a private log must not be shown to others, got %+v", others.Items[0])
+	}
+	if others.Items[2].Log == nil {
+		t.Fatal("a public log should be shown to others")
+	}
+
+	if got := ids(feed("alice", "type=combo")); got != "c2,c1" {
+		t.Fatalf("expected combos only, got %s", got)
+	}
+	if w := serve("GET", "/users/alice/media?type=film", "alice", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown type, got %d", w.Code)
+	}
+}
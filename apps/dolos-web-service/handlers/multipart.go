@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/types"
+	"github.com/hyperbolic/dolos-web-service/video"
+)
+
+// RequestMultipartUpload starts a multipart upload for a large video
+func RequestMultipartUpload(c *gin.Context) {
+	var req types.VideoUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(req.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
+		return
+	}
+
+	logID, ok := req.LogID()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Log ID doesn't match the video type"})
+		return
+	}
+
+	caller := policy.CallerFromContext(c)
+	userId := req.UserID
+	if userId == "" {
+		userId = caller.UserID
+	}
+
+	video.RequestMultipartUploadCore(c, cfg, caller, req.ParentID, userId, req.FileSize, req.MimeType, req.Duration, req.ThumbnailTimeMs, logID)
+}
+
+// PresignMultipartParts returns presigned URLs for parts of a multipart upload
+func PresignMultipartParts(c *gin.Context) {
+	var req types.MultipartPartsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(req.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
+		return
+	}
+
+	video.PresignMultipartPartsCore(c, cfg, policy.CallerFromContext(c), req.VideoID, req.PartNumbers)
+}
+
+// CompleteMultipartUpload assembles the uploaded parts and completes the upload
+func CompleteMultipartUpload(c *gin.Context) {
+	var req types.MultipartCompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(req.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
+		return
+	}
+
+	video.CompleteMultipartUploadCore(c, cfg, policy.CallerFromContext(c), req.VideoID, req.Parts)
+}
+
+// AbortMultipartUpload cancels a multipart upload
+func AbortMultipartUpload(c *gin.Context) {
+	var req types.MultipartAbortRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(req.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
+		return
+	}
+
+	video.AbortMultipartUploadCore(c, cfg, policy.CallerFromContext(c), req.VideoID)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// startMultipart creates a multipart upload of size bytes as alice
+func startMultipart(t *testing.T, size int64) types.MultipartUploadResponse {
+	t.Helper()
+	w := serve("POST", "/videos/upload/multipart", "alice", types.VideoUploadRequest{
+		Type: types.VideoTypeTrick, ParentID: "trick-1", FileName: "kickflip.mp4", FileSize: size, MimeType: "video/mp4",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp types.MultipartUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp
+}
+
+func TestMultipartUploadCompletes(t *testing.T) {
+	records, objects := useFakes(t)
+	ctx := context.Background()
+	parts := []string{"first-part-", "second"}
+	upload := startMultipart(t, int64(len(parts[0])+len(parts[1])))
+
+	partsReq := types.MultipartPartsRequest{Type: types.VideoTypeTrick, VideoID: upload.VideoID, PartNumbers: []int32{1, 2}}
+	if w := serve("POST", "/videos/upload/multipart/parts", "bob", partsReq); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 presigning someone else's upload, got %d: %s", w.Code, w.Body)
+	}
+	w := serve("POST", "/videos/upload/multipart/parts", "alice", partsReq)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var presigned types.MultipartPartsResponse
+	json.Unmarshal(w.Body.Bytes(), &presigned)
+	if len(presigned.Parts) != 2 || presigned.Parts[1].PartNumber != 2 || !strings.Contains(presigned.Parts[1].UploadURL, "partNumber=2") {
+		t.Fatalf("unexpected part URLs %+v", presigned.Parts)
+	}
+
+	// The client PUTs each part and sends back the ETags, in any order
+	var completed []types.MultipartCompletedPart
+	for i := len(parts) - 1; i >= 0; i-- {
+		etag, err := objects.UploadPart(ctx, upload.UploadID, int32(i+1), strings.NewReader(parts[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		completed = append(completed, types.MultipartCompletedPart{PartNumber: int32(i + 1), ETag: etag})
+	}
+	complete := func(userId string, parts []types.MultipartCompletedPart) int {
+		return serve("POST", "/videos/upload/multipart/complete", userId, types.MultipartCompleteRequest{
+			Type: types.VideoTypeTrick, VideoID: upload.VideoID, Parts: parts,
+		}).Code
+	}
+
+	if code := complete("bob", completed); code != http.StatusForbidden {
+		t.Fatalf("expected 403 completing someone else's upload, got %d", code)
+	}
+	wrong := []types.MultipartCompletedPart{completed[0], {PartNumber: 1, ETag: `"not-the-etag"`}}
+	if code := complete("alice", wrong); code != http.StatusConflict {
+		t.Fatalf("expected 409 for a wrong ETag, got %d", code)
+	}
+	if code := complete("alice", completed); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	media, err := records.GetMedia(ctx, trickCfg, upload.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.UploadStatus != "completed" {
+		t.Fatalf("expected the row to be completed, got %s", media.UploadStatus)
+	}
+	body, _, err := objects.Get(ctx, trickCfg.VideoKey("trick-1", "alice", upload.VideoID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if assembled, err := io.ReadAll(body); err != nil || string(assembled) != "first-part-second" {
+		t.Fatalf("expected the parts in part number order, got %q (%v)", assembled, err)
+	}
+}
+
+func TestMultipartUploadAbort(t *testing.T) {
+	records, objects := useFakes(t)
+	ctx := context.Background()
+	upload := startMultipart(t, 1024)
+
+	abort := func(userId string) int {
+		return serve("POST", "/videos/upload/multipart/abort", userId, types.MultipartAbortRequest{
+			Type: types.VideoTypeTrick, VideoID: upload.VideoID,
+		}).Code
+	}
+	if code := abort("bob"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 aborting someone else's upload, got %d", code)
+	}
+	if code := abort("alice"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	media, err := records.GetMedia(ctx, trickCfg, upload.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.UploadStatus != "failed" || media.Metadata["failure_reason"] == nil {
+		t.Fatalf("expected the row to be failed with a reason, got %+v", media)
+	}
+	if uploads, _ := objects.ListMultipartUploads(ctx, ""); len(uploads) != 0 {
+		t.Fatalf("expected the multipart upload to be aborted, got %+v", uploads)
+	}
+	if code := abort("alice"); code != http.StatusConflict {
+		t.Fatalf("expected 409 aborting twice, got %d", code)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// listParams reads the query parameters shared by media listings:
+//
+//	limit=1..100  cursor=<nextCursor>  sort=newest|oldest|duration  count=exact
+//	from=<time>  to=<time>  logged=true|false  logId=<id>  mediaType=video|image|all
+//
+// mediaType defaults to video, so clients that only play videos don't get photos.
+// from and to take RFC 3339 times or dates, a date in to covers the whole day. It
+// responds with 400 and returns false if a parameter is invalid.
+func listParams(c *gin.Context) (repository.MediaFilter, repository.Page, bool) {
+	var filter repository.MediaFilter
+	page := repository.Page{Limit: defaultPageSize}
+	invalid := func(message string) (repository.MediaFilter, repository.Page, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return filter, page, false
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return invalid("limit must be between 1 and 100")
+		}
+		page.Limit = limit
+	}
+
+	sort, ok := repository.ParseSort(c.Query("sort"))
+	if !ok {
+		return invalid("sort must be newest, oldest or duration")
+	}
+	page.Sort = sort
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := repository.DecodeCursor(value, sort)
+		if err != nil {
+			return invalid("Invalid cursor, it must come from a response with the same sort")
+		}
+		page.After = cursor
+	}
+
+	switch c.Query("count") {
+	case "":
+	case "exact":
+		page.Count = true
+	default:
+		return invalid("count must be exact")
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseTime(c.Query("from"), false); err != nil {
+		return invalid("from must be an RFC 3339 time or a date")
+	}
+	if filter.CreatedTo, err = parseTime(c.Query("to"), true); err != nil {
+		return invalid("to must be an RFC 3339 time or a date")
+	}
+
+	if value := c.Query("logged"); value != "" {
+		logged, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid("logged must be true or false")
+		}
+		filter.Logged = &logged
+	}
+	filter.LogID = c.Query("logId")
+
+	if filter.MediaType, ok = mediaTypeFilter(c.Query("mediaType")); !ok {
+		return invalid("mediaType must be video, image or all")
+	}
+
+	return filter, page, true
+}
+
+// mediaTypeFilter turns ?mediaType= into a media_type filter, "" matching both
+func mediaTypeFilter(value string) (string, bool) {
+	switch value {
+	case "", "video":
+		return "video", true
+	case "image":
+		return value, true
+	case "all":
+		return "", true
+	default:
+		return "", false
+	}
+}
+
+// parseTime parses an RFC 3339 time or a date. A date is the start of the day, or its
+// last instant with endOfDay.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+// videoPage converts a page of media rows to the API shape
+func videoPage(page repository.MediaPage) types.VideoPage {
+	videos := make([]types.VideoMetadata, 0, len(page.Media))
+	for i := range page.Media {
+		videos = append(videos, page.Media[i].Video())
+	}
+	result := types.VideoPage{Videos: videos, Total: page.Total}
+	if page.Next != nil {
+		result.NextCursor = page.Next.Encode()
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// GetSessionMedia returns the completed media linked to the trick and combo logs of a
+// session, grouped by trick or combo in session order. ?mediaType= works as in
+// listings. Other users only see public sessions, and in them only public logs and
+// public combo media.
+func GetSessionMedia(c *gin.Context) {
+	sessionId := c.Param("id")
+	caller := policy.CallerFromContext(c)
+
+	mediaType, ok := mediaTypeFilter(c.Query("mediaType"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mediaType must be video, image or all"})
+		return
+	}
+
+	session, err := clients.Sessions.GetSession(c.Request.Context(), sessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		log.Printf("Failed to fetch session %s: %v", sessionId, err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to fetch session", "details": err.Error()})
+		return
+	}
+	public := session.IsPublic != nil && *session.IsPublic
+
+	type clip struct {
+		media repository.Media
+		item  types.MediaItem
+	}
+	var clips []clip
+	for _, videoType := range feedTypes {
+		cfg := types.MediaConfigs[videoType]
+		scope, err := clients.Policy.AuthorizeSession(caller, cfg, session.UserID, public)
+		if errors.Is(err, policy.ErrForbidden) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Session is private"})
+			return
+		}
+		if err != nil {
+			policy.Respond(c, err)
+			return
+		}
+
+		filter := repository.MediaFilter{SessionID: sessionId, Verified: true, MediaType: mediaType, PublicOnly: scope.PublicOnly}
+		page, err := clients.Media.ListMedia(c.Request.Context(), cfg, filter, repository.Page{Sort: repository.SortOldest})
+		if err != nil {
+			log.Printf("Failed to fetch %s for session %s: %v", cfg.Table, sessionId, err)
+			c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to fetch media", "details": err.Error()})
+			return
+		}
+		for i := range page.Media {
+			item := mediaItem(videoType, &page.Media[i], scope)
+			// Attempts the owner didn't make public stay hidden in a public session
+			if item.Log == nil {
+				continue
+			}
+			clips = append(clips, clip{page.Media[i], item})
+		}
+	}
+
+	// Session order is the order the attempts were logged, then uploaded
+	sort.SliceStable(clips, func(i, j int) bool {
+		a, b := clips[i].item.Log, clips[j].item.Log
+		if a.LoggedAt != b.LoggedAt {
+			return a.LoggedAt < b.LoggedAt
+		}
+		return clips[i].media.CreatedAt < clips[j].media.CreatedAt
+	})
+
+	result := types.SessionMedia{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		StartedAt: session.StartedAt,
+		EndedAt:   session.EndedAt,
+		Groups:    []types.MediaGroup{},
+	}
+	groups := map[string]int{} // type and parent ID -> index in result.Groups
+	for _, clip := range clips {
+		parentID := clip.item.TrickID
+		if clip.item.Type == types.VideoTypeCombo {
+			parentID = clip.item.ComboID
+		}
+		key := string(clip.item.Type) + "/" + parentID
+		i, ok := groups[key]
+		if !ok {
+			i = len(result.Groups)
+			groups[key] = i
+			result.Groups = append(result.Groups, types.MediaGroup{Type: clip.item.Type, ParentID: parentID, Name: clip.item.Name})
+		}
+		result.Groups[i].Items = append(result.Groups[i].Items, clip.item)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestGetSessionMediaGroupsClipsInSessionOrder(t *testing.T) {
+	records, _ := useFakes(t)
+	public, private := true, false
+	records.AddSession(repository.Session{ID: "s1", UserID: "alice", StartedAt: "2026-01-01T09:00:00Z", IsPublic: &public})
+	records.AddSession(repository.Session{ID: "s2", UserID: "alice", StartedAt: "2026-01-02T09:00:00Z", IsPublic: &private})
+
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1", Name: "Kickflip"})
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-2", UserID: "alice", ParentID: "trick-2", Name: "Heelflip"})
+	records.AddParent(comboCfg, repository.Parent{ID: "combo-1", UserID: "alice", Name: "Morning flow"})
+
+	s1, s2 := "s1", "s2"
+	addLog := func(cfg types.MediaConfig, id, parent, session, loggedAt string, isPublic bool) {
+		records.AddLog(cfg, repository.Log{ID: id, ParentRecordID: parent, SessionID: &session, IsPublic: &isPublic, LoggedAt: loggedAt})
+	}
+	addLog(trickCfg, "kick-1", "ut-1", s1, "2026-01-01T09:10:00Z", true)
+	addLog(comboCfg, "flow-1", "combo-1", s1, "2026-01-01T09:20:00Z", true)
+	addLog(trickCfg, "heel-1", "ut-2", s1, "2026-01-01T09:30:00Z", false)
+	addLog(trickCfg, "kick-2", "ut-1", s1, "2026-01-01T09:40:00Z", true)
+	addLog(trickCfg, "kick-3", "ut-1", s2, "2026-01-02T09:10:00Z", true)
+
+	add := func(cfg types.MediaConfig, id, parent, logID string, isPublic bool) {
+		records.AddMedia(cfg, repository.Media{ID: id, ParentRecordID: parent, MediaType: "video", UploadStatus: "completed",
+			Public: isPublic, LogID: &logID})
+	}
+	add(trickCfg, "v-kick-2", "ut-1", "kick-2", false)
+	add(trickCfg, "v-kick-1", "ut-1", "kick-1", false)
+	add(comboCfg, "v-flow-1", "combo-1", "flow-1", false)
+	add(trickCfg, "v-heel-1", "ut-2", "heel-1", false)
+	add(trickCfg, "v-kick-3", "ut-1", "kick-3", false)
+	records.AddMedia(trickCfg, repository.Media{ID: "v-unlinked", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "completed"})
+
+	get := func(userId, sessionId string) (int, types.SessionMedia) {
+		w := serve("GET", "/sessions/"+sessionId+"/media", userId, nil)
+		var result types.SessionMedia
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+	groups := func(result types.SessionMedia) string {
+		var groups []string
+		for _, group := range result.Groups {
+			var ids []string
+			for _, item := range group.Items {
+				ids = append(ids, item.ID)
+			}
+			groups = append(groups, group.ParentID+":"+strings.Join(ids, ","))
+		}
+		return strings.Join(groups, " ")
+	}
+
+	status, owner := get("alice", "s1")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if got := groups(owner); got != "trick-1:v-kick-1,v-kick-2 combo-1:v-flow-1 trick-2:v-heel-1" {
+		t.Fatalf("unexpected groups %s", got)
+	}
+	if group := owner.Groups[0]; group.Type != types.VideoTypeTrick || group.Name != "Kickflip" {
+		t.Fatalf("unexpected group %+v", group)
+	}
+
+	// Others see the public session without private logs or private combo videos
+	status, other := get("bob", "s1")
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if got := groups(other); got != "trick-1:v-kick-1,v-kick-2" {
+		t.Fatalf("unexpected groups for another user %s", got)
+	}
+
+	if status, _ := get("bob", "s2"); status != http.StatusForbidden {
+		t.Fatalf("expected 403 for a private session, got %d", status)
+	}
+	if status, _ := get("alice", "missing"); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown session, got %d", status)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/types"
+	"github.com/hyperbolic/dolos-web-service/video"
+)
+
+// TusOptions advertises the tus protocol version and extensions
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", video.TusVersion)
+	c.Header("Tus-Version", video.TusVersion)
+	c.Header("Tus-Extension", video.TusExtensions)
+	c.Header("Tus-Max-Size", strconv.Itoa(video.TusMaxSize))
+	c.Header("Tus-Checksum-Algorithm", video.TusChecksumAlgorithms)
+	c.Status(http.StatusNoContent)
+}
+
+// TusCreate creates a tus upload. Upload-Metadata carries type, parentId, filetype
+// (or mimeType) and optionally duration, thumbnailTimeMs and userId.
+func TusCreate(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length header is required"})
+		return
+	}
+
+	metadata, err := video.ParseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	videoType := types.VideoType(metadata["type"])
+	cfg, ok := types.GetMediaConfig(videoType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
+		return
+	}
+
+	parentId := metadata["parentId"]
+	if parentId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "parentId metadata is required"})
+		return
+	}
+
+	mimeType := metadata["filetype"]
+	if mimeType == "" {
+		mimeType = metadata["mimeType"]
+	}
+
+	var duration *float64
+	if value, ok := metadata["duration"]; ok && value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration metadata"})
+			return
+		}
+		duration = &parsed
+	}
+
+	var thumbnailTimeMs *int64
+	if value, ok := metadata["thumbnailTimeMs"]; ok && value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid thumbnailTimeMs metadata"})
+			return
+		}
+		thumbnailTimeMs = &parsed
+	}
+
+	logs := types.VideoUploadRequest{Type: videoType, TrickLogID: metadata["trickLogId"], ComboLogID: metadata["comboLogId"]}
+	logID, ok := logs.LogID()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Log ID metadata doesn't match the video type"})
+		return
+	}
+
+	caller := policy.CallerFromContext(c)
+	userId := metadata["userId"]
+	if userId == "" {
+		userId = caller.UserID
+	}
+
+	location := func(videoId string) string {
+		return video.TusLocation(c.Request.URL.Path, videoType, videoId)
+	}
+	video.TusCreateCore(c, cfg, caller, location, parentId, userId, length, mimeType, duration, thumbnailTimeMs, logID)
+}
+
+// TusHead returns the current offset of a tus upload
+func TusHead(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(types.VideoType(c.Param("type")))
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	video.TusOffsetCore(c, cfg, policy.CallerFromContext(c), c.Param("videoId"))
+}
+
+// TusPatch appends a chunk to a tus upload
+func TusPatch(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header is required"})
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(types.VideoType(c.Param("type")))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	video.TusPatchCore(c, cfg, policy.CallerFromContext(c), c.Param("videoId"), offset, c.GetHeader("Upload-Checksum"))
+}
+
+// TusTerminate discards a tus upload
+func TusTerminate(c *gin.Context) {
+	if !tusResumable(c) {
+		return
+	}
+
+	cfg, ok := types.GetMediaConfig(types.VideoType(c.Param("type")))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	video.TusTerminateCore(c, cfg, policy.CallerFromContext(c), c.Param("videoId"))
+}
+
+// tusResumable sets the Tus-Resumable response header and rejects unsupported protocol versions
+func tusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", video.TusVersion)
+	if c.GetHeader("Tus-Resumable") != video.TusVersion {
+		c.Header("Tus-Version", video.TusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version"})
+		return false
+	}
+	return true
+}
@@ -1,13 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
 	"github.com/hyperbolic/dolos-web-service/types"
 	"github.com/hyperbolic/dolos-web-service/video"
 )
@@ -26,13 +26,25 @@ func RequestVideoUpload(c *gin.Context) {
 		return
 	}
 
-	video.RequestUploadCore(c, cfg, req.ParentID, req.UserID, req.FileSize, req.MimeType, req.Duration)
+	logID, ok := req.LogID()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Log ID doesn't match the video type"})
+		return
+	}
+
+	// Uploads belong to the authenticated user unless a privileged caller uploads on someone's behalf
+	caller := policy.CallerFromContext(c)
+	userId := req.UserID
+	if userId == "" {
+		userId = caller.UserID
+	}
+
+	video.RequestUploadCore(c, cfg, caller, req.ParentID, userId, req.FileSize, req.MimeType, req.Duration, req.ThumbnailTimeMs, logID)
 }
 
 // UploadThumbnail handles thumbnail upload for videos
 func UploadThumbnail(c *gin.Context) {
 	videoId := c.Param("videoId")
-	userId := c.GetString("userId")
 	videoType := types.VideoType(c.Query("type"))
 
 	cfg, ok := types.GetMediaConfig(videoType)
@@ -41,30 +53,32 @@ func UploadThumbnail(c *gin.Context) {
 		return
 	}
 
-	video.UploadThumbnailCore(c, cfg, videoId, userId)
+	video.UploadThumbnailCore(c, cfg, policy.CallerFromContext(c), videoId)
 }
 
-// CompleteVideoUpload confirms video upload completion
-func CompleteVideoUpload(c *gin.Context) {
-	var req types.VideoUploadCompleteRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// TrimVideo sets the trim points of a video
+func TrimVideo(c *gin.Context) {
+	videoId := c.Param("videoId")
+	videoType := types.VideoType(c.Query("type"))
+
+	cfg, ok := types.GetMediaConfig(videoType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing video type query parameter"})
 		return
 	}
 
-	cfg, ok := types.GetMediaConfig(req.Type)
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
+	var req types.TrimRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	video.CompleteUploadCore(c, cfg, req.VideoID)
+	video.TrimCore(c, cfg, policy.CallerFromContext(c), videoId, req.TrimStartMs, req.TrimEndMs)
 }
 
-// GetVideos returns all videos for a parent (trick or combo), optionally filtered by user
-func GetVideos(c *gin.Context) {
-	parentId := c.Param("parentId")
-	userId := c.Query("userId")
+// LinkVideoLog links a video to a log of its trick or combo, or unlinks it
+func LinkVideoLog(c *gin.Context) {
+	videoId := c.Param("videoId")
 	videoType := types.VideoType(c.Query("type"))
 
 	cfg, ok := types.GetMediaConfig(videoType)
@@ -73,111 +87,85 @@ func GetVideos(c *gin.Context) {
 		return
 	}
 
-	// Different query logic based on video type
-	if videoType == types.VideoTypeTrick {
-		getTrickVideos(c, cfg, parentId, userId)
-	} else {
-		getComboVideos(c, cfg, parentId, userId)
-	}
-}
-
-// getTrickVideos handles the two-step query for trick videos
-func getTrickVideos(c *gin.Context, cfg types.MediaConfig, trickId string, userId string) {
-	// Step 1: Get UserToTricks IDs for this trick (and optionally user)
-	var userTrickQuery string
-	if userId != "" {
-		userTrickQuery = fmt.Sprintf("?trickID=eq.%s&userID=eq.%s&select=id", trickId, userId)
-	} else {
-		userTrickQuery = fmt.Sprintf("?trickID=eq.%s&select=id", trickId)
+	var req types.LinkLogRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-
-	userTrickResp, err := clients.Supabase.Select("UserToTricks", userTrickQuery)
-	if err != nil {
-		log.Printf("Failed to query UserToTricks: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user tricks", "details": err.Error()})
+	if req.LogID != nil && *req.LogID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "logId must be a log ID or null"})
 		return
 	}
 
-	var userTricks []map[string]interface{}
-	if err := json.Unmarshal(userTrickResp, &userTricks); err != nil {
-		log.Printf("Failed to parse user tricks: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user tricks"})
+	video.LinkLogCore(c, cfg, policy.CallerFromContext(c), videoId, req.LogID)
+}
+
+// CompleteVideoUpload confirms video upload completion
+func CompleteVideoUpload(c *gin.Context) {
+	var req types.VideoUploadCompleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// If no user tricks found, return empty array
-	if len(userTricks) == 0 {
-		c.JSON(http.StatusOK, []types.VideoMetadata{})
+	cfg, ok := types.GetMediaConfig(req.Type)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video type"})
 		return
 	}
 
-	// Step 2: Build list of user_trick_ids
-	userTrickIds := make([]string, 0, len(userTricks))
-	for _, ut := range userTricks {
-		if id, ok := ut["id"].(string); ok {
-			userTrickIds = append(userTrickIds, id)
-		}
-	}
+	video.CompleteUploadCore(c, cfg, policy.CallerFromContext(c), req.VideoID)
+}
 
-	// Step 3: Query TrickMedia for these user_trick_ids
-	var query string
-	if len(userTrickIds) == 1 {
-		query = fmt.Sprintf("?user_trick_id=eq.%s&media_type=eq.video&upload_status=eq.completed&order=created_at.desc&select=*", userTrickIds[0])
-	} else {
-		// Build an "or" query for multiple IDs
-		orConditions := ""
-		for i, id := range userTrickIds {
-			if i > 0 {
-				orConditions += ","
-			}
-			orConditions += fmt.Sprintf("user_trick_id.eq.%s", id)
-		}
-		query = fmt.Sprintf("?or=(%s)&media_type=eq.video&upload_status=eq.completed&order=created_at.desc&select=*", orConditions)
-	}
+// GetVideos returns a page of completed videos for a parent (trick or combo), optionally
+// filtered by user. See listParams for paging, sorting and the other filters.
+func GetVideos(c *gin.Context) {
+	parentId := c.Param("parentId")
+	userId := c.Query("userId")
+	videoType := types.VideoType(c.Query("type"))
 
-	respData, err := clients.Supabase.Select(cfg.Table, query)
-	if err != nil {
-		log.Printf("Failed to fetch videos: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch videos", "details": err.Error()})
+	cfg, ok := types.GetMediaConfig(videoType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing video type query parameter"})
 		return
 	}
 
-	var videos []types.VideoMetadata
-	if err := json.Unmarshal(respData, &videos); err != nil {
-		log.Printf("Failed to parse videos: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse videos"})
+	filter, page, ok := listParams(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, videos)
-}
-
-// getComboVideos handles the direct query for combo videos
-func getComboVideos(c *gin.Context, cfg types.MediaConfig, comboId string, userId string) {
-	// For combos, the comboId IS the UserCombos.id, so query is simpler
-	query := fmt.Sprintf("?%s=eq.%s&media_type=eq.video&upload_status=eq.completed&order=created_at.desc&select=*", cfg.ForeignKey, comboId)
-
-	respData, err := clients.Supabase.Select(cfg.Table, query)
+	scope, err := clients.Policy.AuthorizeList(c.Request.Context(), policy.CallerFromContext(c), cfg, parentId)
 	if err != nil {
-		log.Printf("Failed to fetch combo videos: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch videos", "details": err.Error()})
+		policy.Respond(c, err)
 		return
 	}
+	filter.PublicOnly = scope.PublicOnly
 
-	var videos []types.VideoMetadata
-	if err := json.Unmarshal(respData, &videos); err != nil {
-		log.Printf("Failed to parse videos: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse videos"})
+	// One query for both types: the parent is joined in, so for tricks this covers every
+	// user's UserToTricks link (or userId's), and for combos the UserCombos row itself
+	filter.ParentID = parentId
+	filter.UserID = userId
+	listVideos(c, cfg, filter, page)
+}
+
+// listVideos responds with a page of the verified media matching filter. Rows the worker
+// is processing stay listed, so a video doesn't vanish while it's transcoded.
+func listVideos(c *gin.Context, cfg types.MediaConfig, filter repository.MediaFilter, page repository.Page) {
+	filter.Verified = true
+	media, err := clients.Media.ListMedia(c.Request.Context(), cfg, filter, page)
+	if err != nil {
+		log.Printf("Failed to fetch %s: %v", cfg.Table, err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to fetch videos", "details": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, videos)
+	c.JSON(http.StatusOK, videoPage(media))
 }
 
 // DeleteVideo removes a video
 func DeleteVideo(c *gin.Context) {
 	videoId := c.Param("videoId")
-	userId := c.GetString("userId")
 	videoType := types.VideoType(c.Query("type"))
 
 	cfg, ok := types.GetMediaConfig(videoType)
@@ -186,5 +174,5 @@ func DeleteVideo(c *gin.Context) {
 		return
 	}
 
-	video.DeleteCore(c, cfg, videoId, userId)
+	video.DeleteCore(c, cfg, policy.CallerFromContext(c), videoId)
 }
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+var (
+	trickCfg = types.MediaConfigs[types.VideoTypeTrick]
+	comboCfg = types.MediaConfigs[types.VideoTypeCombo]
+)
+
+// useFakes points the clients globals at in-memory fakes for the test
+func useFakes(t *testing.T) (*repository.Memory, *storage.MemoryStore) {
+	gin.SetMode(gin.TestMode)
+	records := repository.NewMemory()
+	objects := storage.NewMemoryStore()
+
+	savedStorage, savedPolicy, savedRepository := clients.Storage, clients.Policy, clients.Repository
+	savedMedia, savedParents, savedLogs, savedSessions := clients.Media, clients.Parents, clients.Logs, clients.Sessions
+	t.Cleanup(func() {
+		clients.Storage, clients.Policy, clients.Repository = savedStorage, savedPolicy, savedRepository
+		clients.Media, clients.Parents, clients.Logs, clients.Sessions = savedMedia, savedParents, savedLogs, savedSessions
+	})
+
+	clients.Storage = objects
+	clients.Repository, clients.Media, clients.Parents, clients.Logs, clients.Sessions = records, records, records, records, records
+	clients.Policy = policy.New(repository.NewPolicyLoader(records, records))
+	return records, objects
+}
+
+// serve runs a request through the video routes as userId, the way middleware.Auth sets it
+func serve(method, path, userId string, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("userId", userId)
+		c.Set("userRole", "user")
+	})
+	router.POST("/videos/upload/request", RequestVideoUpload)
+	router.POST("/videos/upload/complete", CompleteVideoUpload)
+	router.POST("/videos/upload/multipart", RequestMultipartUpload)
+	router.POST("/videos/upload/multipart/parts", PresignMultipartParts)
+	router.POST("/videos/upload/multipart/complete", CompleteMultipartUpload)
+	router.POST("/videos/upload/multipart/abort", AbortMultipartUpload)
+	router.GET("/videos/:parentId", GetVideos)
+	router.DELETE("/videos/:videoId", DeleteVideo)
+	router.PUT("/videos/:videoId/log", LinkVideoLog)
+	router.GET("/users/:userId/media", GetUserMedia)
+	router.GET("/sessions/:id/media", GetSessionMedia)
+
+	var encoded []byte
+	if body != nil {
+		encoded, _ = json.Marshal(body)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(encoded)))
+	return w
+}
+
+func TestRequestUploadCreatesLinkAndPendingRow(t *testing.T) {
+	records, _ := useFakes(t)
+
+	w := serve("POST", "/videos/upload/request", "alice", types.VideoUploadRequest{
+		Type: types.VideoTypeTrick, ParentID: "trick-1", FileName: "kickflip.mp4", FileSize: 1024, MimeType: "video/mp4",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp types.VideoUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	parents, _ := records.FindParents(context.Background(), trickCfg, "trick-1", "alice")
+	if len(parents) != 1 {
+		t.Fatalf("expected one UserToTricks link, got %v", parents)
+	}
+	media, err := records.GetMedia(context.Background(), trickCfg, resp.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.UploadStatus != "pending" || media.ParentRecordID != parents[0].ID {
+		t.Fatalf("unexpected media row %+v", media)
+	}
+}
+
+func TestRequestImageUpload(t *testing.T) {
+	records, _ := useFakes(t)
+	duration := 3000.0
+
+	upload := func(mimeType string, size int64) *httptest.ResponseRecorder {
+		return serve("POST", "/videos/upload/request", "alice", types.VideoUploadRequest{
+			Type: types.VideoTypeTrick, ParentID: "trick-1", FileName: "kickflip", FileSize: size, MimeType: mimeType, Duration: &duration,
+		})
+	}
+
+	if w := upload("image/heic", 30*1024*1024); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 over the image limit, got %d: %s", w.Code, w.Body)
+	}
+	if w := upload("image/gif", 1024); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unsupported type, got %d: %s", w.Code, w.Body)
+	}
+
+	w := upload("image/heic", 2*1024*1024)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp types.VideoUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	media, err := records.GetMedia(context.Background(), trickCfg, resp.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.MediaType != "image" || media.DurationSeconds != nil {
+		t.Fatalf("expected an image row without a duration, got %+v", media)
+	}
+}
+
+func TestRequestUploadLinksLog(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-alice", UserID: "alice", ParentID: "trick-1"})
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-bob", UserID: "bob", ParentID: "trick-1"})
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-alice-2", UserID: "alice", ParentID: "trick-2"})
+	records.AddLog(trickCfg, repository.Log{ID: "log-alice", ParentRecordID: "ut-alice"})
+	records.AddLog(trickCfg, repository.Log{ID: "log-bob", ParentRecordID: "ut-bob"})
+	records.AddLog(trickCfg, repository.Log{ID: "log-trick-2", ParentRecordID: "ut-alice-2"})
+
+	upload := func(req types.VideoUploadRequest) *httptest.ResponseRecorder {
+		req.Type, req.ParentID, req.FileName, req.FileSize, req.MimeType = types.VideoTypeTrick, "trick-1", "kickflip.mp4", 1024, "video/mp4"
+		return serve("POST", "/videos/upload/request", "alice", req)
+	}
+
+	for _, tt := range []struct {
+		name string
+		req  types.VideoUploadRequest
+		want int
+	}{
+		{"another user's log", types.VideoUploadRequest{TrickLogID: "log-bob"}, http.StatusUnprocessableEntity},
+		{"another trick's log", types.VideoUploadRequest{TrickLogID: "log-trick-2"}, http.StatusUnprocessableEntity},
+		{"missing log", types.VideoUploadRequest{TrickLogID: "log-missing"}, http.StatusNotFound},
+		{"combo log on a trick", types.VideoUploadRequest{ComboLogID: "log-alice"}, http.StatusBadRequest},
+	} {
+		if w := upload(tt.req); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body)
+		}
+	}
+	if page, _ := records.ListMedia(context.Background(), trickCfg, repository.MediaFilter{}, repository.Page{}); len(page.Media) != 0 {
+		t.Fatalf("expected rejected uploads to write nothing, got %d rows", len(page.Media))
+	}
+
+	w := upload(types.VideoUploadRequest{TrickLogID: "log-alice"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp types.VideoUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if media, _ := records.GetMedia(context.Background(), trickCfg, resp.VideoID); media.LogID == nil || *media.LogID != "log-alice" {
+		t.Fatalf("expected the row to link log-alice, got %+v", media)
+	}
+}
+
+func TestLinkVideoLog(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(comboCfg, repository.Parent{ID: "combo-alice", UserID: "alice"})
+	records.AddParent(comboCfg, repository.Parent{ID: "combo-alice-2", UserID: "alice"})
+	records.AddMedia(comboCfg, repository.Media{ID: "v1", ParentRecordID: "combo-alice", MediaType: "video", UploadStatus: "completed"})
+	records.AddLog(comboCfg, repository.Log{ID: "log-1", ParentRecordID: "combo-alice"})
+	records.AddLog(comboCfg, repository.Log{ID: "log-other", ParentRecordID: "combo-alice-2"})
+
+	linked := func() *string {
+		media, _ := records.GetMedia(context.Background(), comboCfg, "v1")
+		return media.LogID
+	}
+
+	if w := serve("PUT", "/videos/v1/log?type=combo", "bob", map[string]string{"logId": "log-1"}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user, got %d: %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/videos/v1/log?type=combo", "alice", map[string]string{"logId": "log-other"}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for another combo's log, got %d: %s", w.Code, w.Body)
+	}
+	if w := serve("PUT", "/videos/v1/log?type=combo", "alice", map[string]string{"logId": "log-1"}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if logID := linked(); logID == nil || *logID != "log-1" {
+		t.Fatalf("expected the video to link log-1, got %v", logID)
+	}
+
+	if w := serve("PUT", "/videos/v1/log?type=combo", "alice", map[string]interface{}{"logId": nil}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 unlinking, got %d: %s", w.Code, w.Body)
+	}
+	if logID := linked(); logID != nil {
+		t.Fatalf("expected the video to be unlinked, got %s", *logID)
+	}
+}
+
+func TestCompleteUploadPromotesRow(t *testing.T) {
+	records, objects := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	size, mimeType := int64(4), "video/mp4"
+	records.AddMedia(trickCfg, repository.Media{ID: "v1", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "pending", FileSizeBytes: &size, MimeType: &mimeType})
+
+	complete := map[string]string{"videoId": "v1", "type": "trick"}
+	if w := serve("POST", "/videos/upload/complete", "alice", complete); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 before the object exists, got %d: %s", w.Code, w.Body)
+	}
+
+	objects.Put(context.Background(), trickCfg.VideoKey("trick-1", "alice", "v1"), strings.NewReader("mp4!"), size, mimeType)
+	if w := serve("POST", "/videos/upload/complete", "alice", complete); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if media, _ := records.GetMedia(context.Background(), trickCfg, "v1"); media.UploadStatus != "completed" {
+		t.Fatalf("expected the row to be completed, got %s", media.UploadStatus)
+	}
+}
+
+func TestGetVideosListsCompletedNewestFirst(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-2", UserID: "bob", ParentID: "trick-1"})
+	records.AddMedia(trickCfg, repository.Media{ID: "old", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "completed", CreatedAt: "2026-01-01T00:00:00Z"})
+	records.AddMedia(trickCfg, repository.Media{ID: "new", ParentRecordID: "ut-2", MediaType: "video", UploadStatus: "completed", CreatedAt: "2026-02-01T00:00:00Z"})
+	records.AddMedia(trickCfg, repository.Media{ID: "pending", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "pending", CreatedAt: "2026-03-01T00:00:00Z"})
+	records.AddMedia(trickCfg, repository.Media{ID: "failed", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "failed", CreatedAt: "2026-03-01T00:00:00Z"})
+	// Being processed by the worker, it stays listed meanwhile
+	records.AddMedia(trickCfg, repository.Media{ID: "processing", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "processing", CreatedAt: "2026-02-15T00:00:00Z"})
+
+	w := serve("GET", "/videos/trick-1?type=trick", "alice", nil)
+	if got := videoIDs(t, w); got != "processing,new,old" {
+		t.Fatalf("expected processing,new,old, got %s", got)
+	}
+	w = serve("GET", "/videos/trick-1?type=trick&userId=alice", "alice", nil)
+	if got := videoIDs(t, w); got != "processing,old" {
+		t.Fatalf("expected alice's video only, got %s", got)
+	}
+}
+
+func TestGetVideosMediaType(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	records.AddMedia(trickCfg, repository.Media{ID: "clip", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "completed", CreatedAt: "2026-01-01T00:00:00Z"})
+	records.AddMedia(trickCfg, repository.Media{ID: "photo", ParentRecordID: "ut-1", MediaType: "image", UploadStatus: "completed", CreatedAt: "2026-01-02T00:00:00Z"})
+
+	for query, want := range map[string]string{"": "clip", "&mediaType=image": "photo", "&mediaType=all": "photo,clip"} {
+		if got := videoIDs(t, serve("GET", "/videos/trick-1?type=trick"+query, "alice", nil)); got != want {
+			t.Errorf("%q: expected %s, got %s", query, want, got)
+		}
+	}
+	if w := serve("GET", "/videos/trick-1?type=trick&mediaType=audio", "alice", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown media type, got %d", w.Code)
+	}
+}
+
+func TestGetVideosForTrickWithManyUsers(t *testing.T) {
+	records, _ := useFakes(t)
+	const users = 500
+	for i := 0; i < users; i++ {
+		parent := fmt.Sprintf("ut-%03d", i)
+		records.AddParent(trickCfg, repository.Parent{ID: parent, UserID: fmt.Sprintf("user-%03d", i), ParentID: "trick-1"})
+		records.AddMedia(trickCfg, repository.Media{ID: fmt.Sprintf("v%03d", i), ParentRecordID: parent, MediaType: "video", UploadStatus: "completed",
+			CreatedAt: time.Date(2026, 1, 1, 0, i, 0, 0, time.UTC).Format(time.RFC3339)})
+	}
+	// Another trick's links must not leak in
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-other", UserID: "user-000", ParentID: "trick-2"})
+	records.AddMedia(trickCfg, repository.Media{ID: "other", ParentRecordID: "ut-other", MediaType: "video", UploadStatus: "completed"})
+
+	w := serve("GET", "/videos/trick-1?type=trick&limit=100&count=exact", "alice", nil)
+	var page types.VideoPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+	}
+	if len(page.Videos) != 100 || page.Videos[0].ID != "v499" || *page.Total != users || page.NextCursor == "" {
+		t.Fatalf("unexpected page: %d videos from %s, total %v", len(page.Videos), page.Videos[0].ID, page.Total)
+	}
+	if got := videoIDs(t, serve("GET", "/videos/trick-1?type=trick&userId=user-042", "alice", nil)); got != "v042" {
+		t.Fatalf("expected user-042's video, got %s", got)
+	}
+}
+
+func TestGetVideosPages(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	logID := "log-1"
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		duration := float64(i % 3)
+		media := repository.Media{ID: id, ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "completed", DurationSeconds: &duration,
+			CreatedAt: fmt.Sprintf("2026-01-0%dT00:00:00Z", i+1)}
+		if id == "c" {
+			media.LogID = &logID
+		}
+		records.AddMedia(trickCfg, media)
+	}
+
+	// Follow nextCursor until the last page
+	var ids []string
+	path := "/videos/trick-1?type=trick&limit=2&count=exact"
+	for pages := 0; ; pages++ {
+		w := serve("GET", path, "alice", nil)
+		var page types.VideoPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unexpected response %d: %s", w.Code, w.Body)
+		}
+		if page.Total == nil || *page.Total != 5 {
+			t.Fatalf("expected a total of 5, got %v", page.Total)
+		}
+		for _, video := range page.Videos {
+			ids = append(ids, video.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if pages > 3 {
+			t.Fatal("paging didn't end")
+		}
+		path = "/videos/trick-1?type=trick&limit=2&count=exact&cursor=" + page.NextCursor
+	}
+	if got := strings.Join(ids, ","); got != "e,d,c,b,a" {
+		t.Fatalf("expected e,d,c,b,a across pages, got %s", got)
+	}
+
+	cases := map[string]string{
+		"sort=oldest&limit=3":                 "a,b,c",
+		"sort=duration":                       "c,e,b,d,a", // 2, 1, 1, 0, 0 seconds, ties by id desc
+		"from=2026-01-02&to=2026-01-03":       "c,b",
+		"logged=true":                         "c",
+		"logged=false&sort=oldest&limit=1":    "a",
+		"logId=log-1":                         "c",
+		"from=2026-01-04T00:00:00Z&limit=100": "e,d",
+	}
+	for query, want := range cases {
+		if got := videoIDs(t, serve("GET", "/videos/trick-1?type=trick&"+query, "alice", nil)); got != want {
+			t.Errorf("%s: expected %s, got %s", query, want, got)
+		}
+	}
+
+	for _, query := range []string{"limit=0", "limit=101", "sort=random", "cursor=bogus", "count=planned", "from=yesterday", "logged=maybe"} {
+		if w := serve("GET", "/videos/trick-1?type=trick&"+query, "alice", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestGetComboVideosPublicOnlyForOthers(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(comboCfg, repository.Parent{ID: "combo-1", UserID: "alice"})
+	records.AddMedia(comboCfg, repository.Media{ID: "private", ParentRecordID: "combo-1", MediaType: "video", UploadStatus: "completed"})
+	records.AddMedia(comboCfg, repository.Media{ID: "public", ParentRecordID: "combo-1", MediaType: "video", UploadStatus: "completed", Public: true})
+
+	if got := videoIDs(t, serve("GET", "/videos/combo-1?type=combo", "bob", nil)); got != "public" {
+		t.Fatalf("expected only the public video for another user, got %s", got)
+	}
+	if got := videoIDs(t, serve("GET", "/videos/combo-1?type=combo", "alice", nil)); len(strings.Split(got, ",")) != 2 {
+		t.Fatalf("expected both videos for the owner, got %s", got)
+	}
+}
+
+func TestDeleteVideoRemovesRow(t *testing.T) {
+	records, _ := useFakes(t)
+	records.AddParent(trickCfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	records.AddMedia(trickCfg, repository.Media{ID: "v1", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "completed"})
+
+	if w := serve("DELETE", "/videos/v1?type=trick", "bob", nil); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user, got %d: %s", w.Code, w.Body)
+	}
+	if w := serve("DELETE", "/videos/v1?type=trick", "alice", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := records.GetMedia(context.Background(), trickCfg, "v1"); err == nil {
+		t.Fatal("expected the row to be deleted")
+	}
+}
+
+func videoIDs(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var page types.VideoPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(page.Videos))
+	for i, video := range page.Videos {
+		ids[i] = video.ID
+	}
+	return strings.Join(ids, ",")
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hyperbolic/dolos-web-service/clients"
 	"github.com/hyperbolic/dolos-web-service/handlers"
 	"github.com/hyperbolic/dolos-web-service/middleware"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/video"
+	"github.com/hyperbolic/dolos-web-service/worker"
 	"github.com/joho/godotenv"
 )
 
@@ -18,7 +23,32 @@ func main() {
 	}
 
 	// Initialize clients (must be after loading env vars)
-	clients.Init()
+	if err := clients.Init(); err != nil {
+		log.Fatal("Failed to initialize clients: ", err)
+	}
+
+	// Clean up multipart and tus uploads that clients abandoned
+	go video.RunUploadCleanup(context.Background(), time.Hour)
+
+	// Resolve pending uploads whose client never called complete, unless another
+	// instance already runs the janitor
+	if os.Getenv("JANITOR_ENABLED") != "false" {
+		janitorOpts, err := video.JanitorOptionsFromEnv()
+		if err != nil {
+			log.Fatal("Failed to configure janitor: ", err)
+		}
+		go video.NewJanitor(janitorOpts).Run(context.Background())
+	}
+
+	// Run the processing worker in-process, or separately with cmd/worker
+	if os.Getenv("WORKER_ENABLED") == "true" {
+		w, err := worker.FromEnv()
+		if err != nil {
+			log.Fatal("Failed to initialize worker: ", err)
+		}
+		video.Processor = w
+		go w.Run(context.Background())
+	}
 
 	// Initialize Gin router
 	r := gin.Default()
@@ -26,6 +56,11 @@ func main() {
 	// CORS middleware
 	r.Use(middleware.CORS())
 
+	// Local storage serves its own signed upload/download URLs
+	if local, ok := clients.Storage.(*storage.LocalStore); ok {
+		local.RegisterRoutes(r)
+	}
+
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -44,7 +79,41 @@ func main() {
 			videos.POST("/:videoId/thumbnail", handlers.UploadThumbnail)  // ?type=trick
 			videos.GET("/:parentId", handlers.GetVideos)                  // ?type=trick
 			videos.DELETE("/:videoId", handlers.DeleteVideo)              // ?type=trick
+
+			// Multipart uploads for large videos, type in body
+			videos.POST("/upload/multipart", handlers.RequestMultipartUpload)
+			videos.POST("/upload/multipart/parts", handlers.PresignMultipartParts)
+			videos.POST("/upload/multipart/complete", handlers.CompleteMultipartUpload)
+			videos.POST("/upload/multipart/abort", handlers.AbortMultipartUpload)
+
+			// tus 1.0 resumable uploads, metadata in Upload-Metadata
+			videos.POST("/tus", handlers.TusCreate)
+			videos.HEAD("/tus/:type/:videoId", handlers.TusHead)
+			videos.PATCH("/tus/:type/:videoId", handlers.TusPatch)
+			videos.DELETE("/tus/:type/:videoId", handlers.TusTerminate)
+
+			// Server-side trimming, ?type=trick
+			videos.PUT("/:videoId/trim", handlers.TrimVideo)
+
+			// Link to a TrickLogs/ComboLogs row, ?type=trick
+			videos.PUT("/:videoId/log", handlers.LinkVideoLog)
+		}
+
+		users := v1.Group("/users")
+		users.Use(middleware.Auth())
+		{
+			users.GET("/:userId/media", handlers.GetUserMedia) // tricks and combos, newest first
 		}
+
+		sessions := v1.Group("/sessions")
+		sessions.Use(middleware.Auth())
+		{
+			sessions.GET("/:id/media", handlers.GetSessionMedia) // grouped by trick or combo
+		}
+
+		// tus discovery, outside auth like other preflight requests
+		v1.OPTIONS("/videos/tus", handlers.TusOptions)
+		v1.OPTIONS("/videos/tus/:type/:videoId", handlers.TusOptions)
 	}
 
 	// Start server
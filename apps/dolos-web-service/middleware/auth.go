@@ -22,7 +22,11 @@ import (
 type SupabaseClaims struct {
 	Sub   string `json:"sub"`   // User ID
 	Email string `json:"email"` // User email
-	Role  string `json:"role"`  // User role (authenticated, anon, etc.)
+	Role  string `json:"role"`  // User role (authenticated, anon, service_role, etc.)
+	// AppMetadata is server-controlled and cannot be edited by the user
+	AppMetadata struct {
+		Role string `json:"role"` // "admin" grants the admin override
+	} `json:"app_metadata"`
 	jwt.RegisteredClaims
 }
 
@@ -196,15 +200,25 @@ func Auth() gin.HandlerFunc {
 			return
 		}
 
-		// Verify user is authenticated (not anonymous)
-		if claims.Role != "authenticated" {
+		// Verify user is authenticated (not anonymous). Service role tokens are
+		// accepted for trusted backend callers acting on behalf of users.
+		var userRole string
+		switch {
+		case claims.Role == "service_role":
+			userRole = "service"
+		case claims.Role != "authenticated":
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 			c.Abort()
 			return
+		case claims.AppMetadata.Role == "admin":
+			userRole = "admin"
+		default:
+			userRole = "user"
 		}
 
-		// Set user ID and email in context for use in handlers
+		// Set user ID, role and email in context for use in handlers
 		c.Set("userId", claims.Sub)
+		c.Set("userRole", userRole)
 		c.Set("userEmail", claims.Email)
 		c.Set("token", tokenString)
 
@@ -0,0 +1,35 @@
+-- One UserToTricks link per user and trick, so concurrent uploads for a new trick
+-- resolve to the same row (insert on_conflict=userID,trickID, ignoring duplicates).
+
+-- Existing duplicates are merged into the oldest link of each set, preferring one that
+-- is landed. Their media and logs move to the kept link.
+CREATE TEMP TABLE user_trick_duplicates AS
+SELECT id, keep_id
+FROM (
+  SELECT id,
+         first_value(id) OVER (PARTITION BY "userID", "trickID" ORDER BY landed DESC NULLS LAST, created_at, id) AS keep_id
+  FROM "UserToTricks"
+) ranked
+WHERE id <> keep_id;
+
+UPDATE "TrickMedia" m
+SET user_trick_id = d.keep_id
+FROM user_trick_duplicates d
+WHERE m.user_trick_id = d.id;
+
+UPDATE "TrickLogs" l
+SET user_trick_id = d.keep_id
+FROM user_trick_duplicates d
+WHERE l.user_trick_id = d.id;
+
+DELETE FROM "UserToTricks" u
+USING user_trick_duplicates d
+WHERE u.id = d.id;
+
+DROP TABLE user_trick_duplicates;
+
+ALTER TABLE "UserToTricks"
+  ADD CONSTRAINT "UserToTricks_userID_trickID_key" UNIQUE ("userID", "trickID");
+
+-- Links created by an upload only set the key columns
+ALTER TABLE "UserToTricks" ALTER COLUMN landed SET DEFAULT false;
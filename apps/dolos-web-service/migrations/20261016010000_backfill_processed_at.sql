@@ -0,0 +1,11 @@
+-- Rows completed before the processing worker existed were never probed. Mark them
+-- processed, so a worker deployed later doesn't send them through the pipeline, where
+-- clips outside the current video rules would be failed. Apply before the first worker
+-- runs: rows completed since then and still waiting for it would be skipped too.
+UPDATE "TrickMedia"
+SET metadata = coalesce(metadata, '{}'::jsonb) || jsonb_build_object('processed_at', updated_at, 'backfilled', true)
+WHERE upload_status = 'completed' AND metadata->>'processed_at' IS NULL;
+
+UPDATE "ComboMedia"
+SET metadata = coalesce(metadata, '{}'::jsonb) || jsonb_build_object('processed_at', updated_at, 'backfilled', true)
+WHERE upload_status = 'completed' AND metadata->>'processed_at' IS NULL;
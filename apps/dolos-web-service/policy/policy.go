@@ -0,0 +1,256 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/supabase"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// Action is a media operation that requires authorization
+type Action string
+
+const (
+	ActionRequest   Action = "request"
+	ActionComplete  Action = "complete"
+	ActionThumbnail Action = "thumbnail"
+	ActionDelete    Action = "delete"
+	ActionList      Action = "list"
+	ActionTrim      Action = "trim"
+	ActionLink      Action = "link"
+)
+
+// Role is the caller's privilege level, resolved from the JWT claims by middleware.Auth
+type Role string
+
+const (
+	RoleUser    Role = "user"
+	RoleAdmin   Role = "admin"
+	RoleService Role = "service"
+)
+
+var (
+	ErrUnauthenticated = errors.New("caller not authenticated")
+	ErrForbidden       = errors.New("caller not authorized")
+	ErrNotFound        = errors.New("resource not found")
+)
+
+// Caller is the authenticated identity making a request
+type Caller struct {
+	UserID string
+	Role   Role
+}
+
+// Privileged reports whether the caller bypasses ownership checks
+func (c Caller) Privileged() bool {
+	return c.Role == RoleAdmin || c.Role == RoleService
+}
+
+// CallerFromContext resolves the caller from the values set by middleware.Auth
+func CallerFromContext(c *gin.Context) Caller {
+	role := Role(c.GetString("userRole"))
+	if role == "" {
+		role = RoleUser
+	}
+	return Caller{UserID: c.GetString("userId"), Role: role}
+}
+
+// Media is a media row together with the ownership data of its parent row
+type Media struct {
+	ID             string
+	OwnerID        string // user who owns the parent record
+	ParentID       string // trickID for tricks, UserCombos.id for combos (used in storage keys)
+	ParentRecordID string // UserToTricks.id or UserCombos.id
+	Public         bool
+	Row            map[string]interface{}
+}
+
+// Resource is what a decision is made against
+type Resource struct {
+	OwnerID string
+	Public  bool
+}
+
+// ListScope narrows a listing for callers who don't own the parent
+type ListScope struct {
+	PublicOnly     bool // only media marked public (ComboMedia.public)
+	PublicLogsOnly bool // only show the logs linked to media if they're public
+}
+
+// Denial is returned when a caller is not allowed to perform an action
+type Denial struct {
+	Action Action
+	Err    error // ErrUnauthenticated, ErrForbidden or ErrNotFound
+	Reason string
+}
+
+func (d *Denial) Error() string {
+	return fmt.Sprintf("%s denied: %s", d.Action, d.Reason)
+}
+
+func (d *Denial) Unwrap() error {
+	return d.Err
+}
+
+// Loader fetches the rows a policy decision depends on
+type Loader interface {
+	// Media loads a media row and its parent, returning ErrNotFound if it doesn't exist
+	Media(ctx context.Context, cfg types.MediaConfig, mediaID string) (*Media, error)
+	// ParentOwner returns the owner of a user-owned parent row (e.g. UserCombos)
+	ParentOwner(ctx context.Context, cfg types.MediaConfig, parentID string) (string, error)
+}
+
+// Policy is the central authorization component for media operations
+type Policy struct {
+	loader Loader
+}
+
+func New(loader Loader) *Policy {
+	return &Policy{loader: loader}
+}
+
+// Decide returns nil if the caller may perform action on the resource
+func Decide(caller Caller, action Action, res Resource) error {
+	if caller.Privileged() {
+		log.Printf("policy: %s override for %s (caller %q, owner %q)", caller.Role, action, caller.UserID, res.OwnerID)
+		return nil
+	}
+	if caller.UserID == "" {
+		return &Denial{Action: action, Err: ErrUnauthenticated, Reason: "no user in token"}
+	}
+
+	switch action {
+	case ActionList:
+		// Listing is open to any authenticated user, Authorize narrows the scope
+		return nil
+	case ActionRequest, ActionComplete, ActionThumbnail, ActionDelete, ActionTrim, ActionLink:
+		if res.OwnerID != caller.UserID {
+			return &Denial{Action: action, Err: ErrForbidden, Reason: "caller does not own the resource"}
+		}
+		return nil
+	default:
+		return &Denial{Action: action, Err: ErrForbidden, Reason: "unknown action"}
+	}
+}
+
+// AuthorizeRequest checks that the caller may upload to parentID on behalf of targetUserID
+func (p *Policy) AuthorizeRequest(ctx context.Context, caller Caller, cfg types.MediaConfig, parentID, targetUserID string) error {
+	if err := Decide(caller, ActionRequest, Resource{OwnerID: targetUserID}); err != nil {
+		return err
+	}
+
+	// When the parent ID is the parent row itself (combos), the target user must own it.
+	// Trick parents are shared and the user link is resolved by the upload.
+	if cfg.ParentIDCol == "id" {
+		ownerID, err := p.loader.ParentOwner(ctx, cfg, parentID)
+		if err != nil {
+			return wrapLoadError(ActionRequest, err)
+		}
+		if ownerID != targetUserID {
+			return &Denial{Action: ActionRequest, Err: ErrNotFound, Reason: "parent record not owned by target user"}
+		}
+	}
+	return nil
+}
+
+// AuthorizeMedia loads a media row and checks that the caller may perform action on it
+func (p *Policy) AuthorizeMedia(ctx context.Context, caller Caller, cfg types.MediaConfig, action Action, mediaID string) (*Media, error) {
+	media, err := p.loader.Media(ctx, cfg, mediaID)
+	if err != nil {
+		return nil, wrapLoadError(action, err)
+	}
+	if err := Decide(caller, action, Resource{OwnerID: media.OwnerID, Public: media.Public}); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// AuthorizeList checks that the caller may list media for parentID and returns the scope they see
+func (p *Policy) AuthorizeList(ctx context.Context, caller Caller, cfg types.MediaConfig, parentID string) (ListScope, error) {
+	if err := Decide(caller, ActionList, Resource{}); err != nil {
+		return ListScope{}, err
+	}
+	if caller.Privileged() || cfg.ParentIDCol != "id" {
+		return ListScope{}, nil
+	}
+
+	ownerID, err := p.loader.ParentOwner(ctx, cfg, parentID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Unknown parents simply have no media
+			return ListScope{PublicOnly: true}, nil
+		}
+		return ListScope{}, err
+	}
+	return ListScope{PublicOnly: ownerID != caller.UserID}, nil
+}
+
+// AuthorizeUserList checks that the caller may list userID's media across all parents
+// of cfg's type and returns the scope they see. Others see what the parent listings
+// would show them, and only the public logs.
+func (p *Policy) AuthorizeUserList(caller Caller, cfg types.MediaConfig, userID string) (ListScope, error) {
+	if err := Decide(caller, ActionList, Resource{}); err != nil {
+		return ListScope{}, err
+	}
+	if caller.Privileged() || caller.UserID == userID {
+		return ListScope{}, nil
+	}
+	return ListScope{PublicOnly: cfg.ParentIDCol == "id", PublicLogsOnly: true}, nil
+}
+
+// AuthorizeSession checks that the caller may see the media of a session of cfg's type
+// owned by ownerID and returns the scope they see. Other users only see public sessions,
+// and within them what AuthorizeUserList shows them.
+func (p *Policy) AuthorizeSession(caller Caller, cfg types.MediaConfig, ownerID string, public bool) (ListScope, error) {
+	if err := Decide(caller, ActionList, Resource{}); err != nil {
+		return ListScope{}, err
+	}
+	if !public && !caller.Privileged() && caller.UserID != ownerID {
+		return ListScope{}, &Denial{Action: ActionList, Err: ErrForbidden, Reason: "session is private"}
+	}
+	return p.AuthorizeUserList(caller, cfg, ownerID)
+}
+
+func wrapLoadError(action Action, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return &Denial{Action: action, Err: ErrNotFound, Reason: err.Error()}
+	}
+	return err
+}
+
+var actionVerbs = map[Action]string{
+	ActionComplete:  "complete",
+	ActionThumbnail: "update",
+	ActionDelete:    "delete",
+	ActionList:      "view",
+	ActionTrim:      "trim",
+	ActionLink:      "link",
+}
+
+// Respond writes the HTTP error response for a failed authorization
+func Respond(c *gin.Context, err error) {
+	var denial *Denial
+	if !errors.As(err, &denial) {
+		log.Printf("Failed to authorize request: %v", err)
+		c.JSON(supabase.HTTPStatus(err), gin.H{"error": "Failed to authorize request", "details": err.Error()})
+		return
+	}
+
+	switch {
+	case errors.Is(denial, ErrUnauthenticated):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	case errors.Is(denial, ErrNotFound) && denial.Action == ActionRequest:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Parent record not found"})
+	case errors.Is(denial, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+	case denial.Action == ActionRequest:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to upload on behalf of this user"})
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Not authorized to %s this video", actionVerbs[denial.Action])})
+	}
+}
@@ -0,0 +1,322 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/supabase"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+type fakeLoader struct {
+	media   map[string]*Media
+	parents map[string]string
+	err     error
+}
+
+func (f *fakeLoader) Media(ctx context.Context, cfg types.MediaConfig, mediaID string) (*Media, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	m, ok := f.media[mediaID]
+	if !ok {
+		return nil, fmt.Errorf("%s %s: %w", cfg.Table, mediaID, ErrNotFound)
+	}
+	return m, nil
+}
+
+func (f *fakeLoader) ParentOwner(ctx context.Context, cfg types.MediaConfig, parentID string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	owner, ok := f.parents[parentID]
+	if !ok {
+		return "", fmt.Errorf("%s %s: %w", cfg.ParentTable, parentID, ErrNotFound)
+	}
+	return owner, nil
+}
+
+var (
+	alice   = Caller{UserID: "alice", Role: RoleUser}
+	bob     = Caller{UserID: "bob", Role: RoleUser}
+	admin   = Caller{UserID: "root", Role: RoleAdmin}
+	service = Caller{Role: RoleService}
+	anon    = Caller{Role: RoleUser}
+)
+
+func newTestPolicy() *Policy {
+	return New(&fakeLoader{
+		media: map[string]*Media{
+			"trick-video": {ID: "trick-video", OwnerID: "alice", ParentID: "trick-1", ParentRecordID: "ut-1"},
+			"combo-video": {ID: "combo-video", OwnerID: "alice", ParentID: "combo-1", ParentRecordID: "combo-1", Public: true},
+		},
+		parents: map[string]string{"combo-1": "alice"},
+	})
+}
+
+func TestDecide(t *testing.T) {
+	owned := Resource{OwnerID: "alice"}
+	tests := []struct {
+		name    string
+		caller  Caller
+		action  Action
+		res     Resource
+		wantErr error
+	}{
+		{"owner request", alice, ActionRequest, owned, nil},
+		{"owner complete", alice, ActionComplete, owned, nil},
+		{"owner thumbnail", alice, ActionThumbnail, owned, nil},
+		{"owner delete", alice, ActionDelete, owned, nil},
+		{"owner list", alice, ActionList, owned, nil},
+		{"owner link", alice, ActionLink, owned, nil},
+		{"other request", bob, ActionRequest, owned, ErrForbidden},
+		{"other complete", bob, ActionComplete, owned, ErrForbidden},
+		{"other thumbnail", bob, ActionThumbnail, owned, ErrForbidden},
+		{"other delete", bob, ActionDelete, owned, ErrForbidden},
+		{"other list", bob, ActionList, owned, nil},
+		{"other link", bob, ActionLink, owned, ErrForbidden},
+		{"admin delete", admin, ActionDelete, owned, nil},
+		{"admin complete", admin, ActionComplete, owned, nil},
+		{"service request", service, ActionRequest, owned, nil},
+		{"service thumbnail", service, ActionThumbnail, owned, nil},
+		{"anonymous list", anon, ActionList, owned, ErrUnauthenticated},
+		{"anonymous delete", anon, ActionDelete, owned, ErrUnauthenticated},
+		{"unknown action", alice, Action("share"), owned, ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Decide(tt.caller, tt.action, tt.res)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("expected allow, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRequest(t *testing.T) {
+	trick := types.MediaConfigs[types.VideoTypeTrick]
+	combo := types.MediaConfigs[types.VideoTypeCombo]
+
+	tests := []struct {
+		name     string
+		caller   Caller
+		cfg      types.MediaConfig
+		parentID string
+		target   string
+		wantErr  error
+	}{
+		{"own trick upload", alice, trick, "trick-1", "alice", nil},
+		{"trick upload for someone else", bob, trick, "trick-1", "alice", ErrForbidden},
+		{"own combo upload", alice, combo, "combo-1", "alice", nil},
+		{"combo owned by someone else", bob, combo, "combo-1", "bob", ErrNotFound},
+		{"missing combo", alice, combo, "combo-2", "alice", ErrNotFound},
+		{"admin uploads for owner", admin, combo, "combo-1", "alice", nil},
+		{"service uploads trick for user", service, trick, "trick-1", "alice", nil},
+	}
+
+	p := newTestPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.AuthorizeRequest(context.Background(), tt.caller, tt.cfg, tt.parentID, tt.target)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("expected allow, got %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeMedia(t *testing.T) {
+	trick := types.MediaConfigs[types.VideoTypeTrick]
+
+	tests := []struct {
+		name    string
+		caller  Caller
+		action  Action
+		mediaID string
+		wantErr error
+	}{
+		{"owner completes", alice, ActionComplete, "trick-video", nil},
+		{"owner sets thumbnail", alice, ActionThumbnail, "trick-video", nil},
+		{"owner deletes", alice, ActionDelete, "trick-video", nil},
+		{"other completes", bob, ActionComplete, "trick-video", ErrForbidden},
+		{"other sets thumbnail", bob, ActionThumbnail, "trick-video", ErrForbidden},
+		{"other deletes", bob, ActionDelete, "trick-video", ErrForbidden},
+		{"admin deletes", admin, ActionDelete, "trick-video", nil},
+		{"service completes", service, ActionComplete, "trick-video", nil},
+		{"missing video", alice, ActionDelete, "nope", ErrNotFound},
+	}
+
+	p := newTestPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media, err := p.AuthorizeMedia(context.Background(), tt.caller, trick, tt.action, tt.mediaID)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("expected allow, got %v", err)
+				}
+				if media.ID != tt.mediaID {
+					t.Fatalf("expected media %s, got %s", tt.mediaID, media.ID)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeList(t *testing.T) {
+	trick := types.MediaConfigs[types.VideoTypeTrick]
+	combo := types.MediaConfigs[types.VideoTypeCombo]
+
+	tests := []struct {
+		name           string
+		caller         Caller
+		cfg            types.MediaConfig
+		parentID       string
+		wantPublicOnly bool
+		wantErr        error
+	}{
+		{"trick community list", bob, trick, "trick-1", false, nil},
+		{"combo owner list", alice, combo, "combo-1", false, nil},
+		{"combo non-owner list", bob, combo, "combo-1", true, nil},
+		{"combo admin list", admin, combo, "combo-1", false, nil},
+		{"missing combo", alice, combo, "combo-2", true, nil},
+		{"anonymous list", anon, trick, "trick-1", false, ErrUnauthenticated},
+	}
+
+	p := newTestPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := p.AuthorizeList(context.Background(), tt.caller, tt.cfg, tt.parentID)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected allow, got %v", err)
+			}
+			if scope.PublicOnly != tt.wantPublicOnly {
+				t.Fatalf("expected PublicOnly=%v, got %v", tt.wantPublicOnly, scope.PublicOnly)
+			}
+		})
+	}
+}
+
+func TestAuthorizeUserList(t *testing.T) {
+	trick := types.MediaConfigs[types.VideoTypeTrick]
+	combo := types.MediaConfigs[types.VideoTypeCombo]
+
+	tests := []struct {
+		name   string
+		caller Caller
+		cfg    types.MediaConfig
+		want   ListScope
+	}{
+		{"owner tricks", alice, trick, ListScope{}},
+		{"owner combos", alice, combo, ListScope{}},
+		{"other user tricks", bob, trick, ListScope{PublicLogsOnly: true}},
+		{"other user combos", bob, combo, ListScope{PublicOnly: true, PublicLogsOnly: true}},
+		{"admin combos", admin, combo, ListScope{}},
+	}
+
+	p := newTestPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := p.AuthorizeUserList(tt.caller, tt.cfg, "alice")
+			if err != nil || scope != tt.want {
+				t.Fatalf("expected %+v, got %+v, %v", tt.want, scope, err)
+			}
+		})
+	}
+	if _, err := p.AuthorizeUserList(anon, trick, "alice"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected ErrUnauthenticated, got %v", err)
+	}
+}
+
+func TestAuthorizeSession(t *testing.T) {
+	combo := types.MediaConfigs[types.VideoTypeCombo]
+
+	tests := []struct {
+		name    string
+		caller  Caller
+		public  bool
+		want    ListScope
+		wantErr error
+	}{
+		{"owner private", alice, false, ListScope{}, nil},
+		{"other user private", bob, false, ListScope{}, ErrForbidden},
+		{"other user public", bob, true, ListScope{PublicOnly: true, PublicLogsOnly: true}, nil},
+		{"admin private", admin, false, ListScope{}, nil},
+		{"anonymous public", anon, true, ListScope{}, ErrUnauthenticated},
+	}
+
+	p := newTestPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := p.AuthorizeSession(tt.caller, combo, "alice", tt.public)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || scope != tt.want {
+				t.Fatalf("expected %+v, got %+v, %v", tt.want, scope, err)
+			}
+		})
+	}
+}
+
+func TestRespond(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{"unauthenticated", &Denial{Action: ActionDelete, Err: ErrUnauthenticated}, http.StatusUnauthorized},
+		{"forbidden", &Denial{Action: ActionDelete, Err: ErrForbidden}, http.StatusForbidden},
+		{"not found", &Denial{Action: ActionComplete, Err: ErrNotFound}, http.StatusNotFound},
+		{"parent not found", &Denial{Action: ActionRequest, Err: ErrNotFound}, http.StatusNotFound},
+		{"loader failure", errors.New("connection refused"), http.StatusInternalServerError},
+		{"malformed id", fmt.Errorf("failed to fetch TrickMedia: %w", &supabase.Error{Status: 400, Code: supabase.CodeInvalidText}), http.StatusBadRequest},
+		{"supabase down", &supabase.Error{Status: 503}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			Respond(c, tt.err)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestLoaderFailureIsNotADenial(t *testing.T) {
+	p := New(&fakeLoader{err: errors.New("supabase unavailable")})
+	_, err := p.AuthorizeMedia(context.Background(), alice, types.MediaConfigs[types.VideoTypeTrick], ActionDelete, "trick-video")
+	var denial *Denial
+	if err == nil || errors.As(err, &denial) {
+		t.Fatalf("expected a plain loader error, got %v", err)
+	}
+}
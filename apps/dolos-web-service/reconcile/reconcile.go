@@ -0,0 +1,328 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// Kind is a kind of drift between storage and the media tables
+type Kind string
+
+const (
+	OrphanedObject   Kind = "orphaned_object"   // object without a media row
+	MissingObject    Kind = "missing_object"    // row whose video isn't in storage
+	MissingThumbnail Kind = "missing_thumbnail" // processed row without a thumbnail_url
+	StaleThumbnail   Kind = "stale_thumbnail"   // thumbnail_url pointing at a missing object
+)
+
+// Categories groups kinds for the --only flag
+var Categories = map[string][]Kind{
+	"orphans":    {OrphanedObject},
+	"missing":    {MissingObject},
+	"thumbnails": {MissingThumbnail, StaleThumbnail},
+}
+
+// Action is the fix --apply makes for a drift
+type Action string
+
+const (
+	DeleteObject        Action = "delete_object"
+	MarkFailed          Action = "mark_failed"
+	Reprocess           Action = "reprocess"      // point the row back at the original upload and requeue it
+	LinkThumbnail       Action = "link_thumbnail" // set thumbnail_url to a thumbnail found in storage
+	RegenerateThumbnail Action = "regenerate_thumbnail"
+)
+
+// pageSize is how many media rows are fetched per request
+const pageSize = 1000
+
+// Drift is one inconsistency and the fix for it
+type Drift struct {
+	Kind    Kind   `json:"kind"`
+	Table   string `json:"table"`
+	MediaID string `json:"mediaId,omitempty"`
+	Key     string `json:"key,omitempty"` // the object involved, or the one the fix uses
+	Detail  string `json:"detail"`
+	Action  Action `json:"action"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+
+	row map[string]interface{}
+}
+
+// Report is the result of a reconciliation run
+type Report struct {
+	DryRun  bool         `json:"dryRun"`
+	Objects int          `json:"objects"` // objects listed
+	Rows    int          `json:"rows"`    // media rows listed
+	Counts  map[Kind]int `json:"counts"`
+	Drifts  []Drift      `json:"drifts"`
+	Errors  int          `json:"errors"` // fixes that failed
+}
+
+// Options configures a run
+type Options struct {
+	Apply  bool
+	Kinds  map[Kind]bool // kinds to report, all if empty
+	MinAge time.Duration // newer objects and rows may be mid-upload and are skipped
+}
+
+// Row is a media row with the ownership data its storage key is built from. Media is
+// nil when the row's parent record no longer exists.
+type Row struct {
+	Config types.MediaConfig
+	ID     string
+	Media  *policy.Media
+	Raw    map[string]interface{}
+}
+
+// Run lists the bucket and the media tables, reports the drift and fixes it with Apply
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	var objects []storage.ObjectInfo
+	var rows []Row
+	for _, cfg := range types.MediaConfigs {
+		listed, err := clients.Storage.List(ctx, cfg.PathPrefix+"/")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s/: %w", cfg.PathPrefix, err)
+		}
+		objects = append(objects, listed...)
+
+		tableRows, err := listRows(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, tableRows...)
+	}
+
+	report := &Report{
+		DryRun:  !opts.Apply,
+		Objects: len(objects),
+		Rows:    len(rows),
+		Counts:  map[Kind]int{},
+	}
+	for _, drift := range Diff(objects, rows, time.Now(), opts.MinAge) {
+		if len(opts.Kinds) > 0 && !opts.Kinds[drift.Kind] {
+			continue
+		}
+		if opts.Apply {
+			if err := apply(ctx, &drift); err != nil {
+				drift.Error = err.Error()
+				report.Errors++
+			} else {
+				drift.Applied = true
+			}
+		}
+		report.Counts[drift.Kind]++
+		report.Drifts = append(report.Drifts, drift)
+	}
+	return report, nil
+}
+
+// listRows pages through a media table with the parent record expanded
+func listRows(ctx context.Context, cfg types.MediaConfig) ([]Row, error) {
+	var rows []Row
+	page := repository.Page{Sort: repository.SortOldest, Limit: pageSize}
+	for {
+		listed, err := clients.Media.ListMedia(ctx, cfg, repository.MediaFilter{}, page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", cfg.Table, err)
+		}
+		for i := range listed.Media {
+			row := Row{Config: cfg, ID: listed.Media[i].ID, Raw: listed.Media[i].Row(cfg)}
+			if media, err := repository.PolicyMedia(cfg, &listed.Media[i]); err == nil {
+				row.Media = media
+			}
+			rows = append(rows, row)
+		}
+		if listed.Next == nil {
+			return rows, nil
+		}
+		page.After = listed.Next
+	}
+}
+
+// Diff compares the listed objects with the media rows. Rows are matched to objects by
+// key, see rowKey, so rows written under an earlier public base URL still match.
+func Diff(objects []storage.ObjectInfo, rows []Row, now time.Time, minAge time.Duration) []Drift {
+	byKey := map[string]storage.ObjectInfo{}
+	for _, obj := range objects {
+		byKey[obj.Key] = obj
+	}
+	rowsByID := map[string]Row{}
+	for _, row := range rows {
+		rowsByID[row.Config.Table+"/"+row.ID] = row
+	}
+
+	var drifts []Drift
+
+	for _, obj := range objects {
+		key, ok := types.ParseStorageKey(obj.Key)
+		if !ok || now.Sub(obj.LastModified) < minAge {
+			continue
+		}
+		cfg := types.MediaConfigs[key.Type]
+		row, exists := rowsByID[cfg.Table+"/"+key.VideoID]
+		switch {
+		case !exists:
+			drifts = append(drifts, Drift{Kind: OrphanedObject, Table: cfg.Table, MediaID: key.VideoID, Key: obj.Key, Action: DeleteObject,
+				Detail: "no media row"})
+		case row.Media != nil && videoKey(row) != key.VideoKey():
+			drifts = append(drifts, Drift{Kind: OrphanedObject, Table: cfg.Table, MediaID: key.VideoID, Key: obj.Key, Action: DeleteObject,
+				Detail: fmt.Sprintf("row's video is stored under %s", videoKey(row))})
+		}
+	}
+
+	for _, row := range rows {
+		if createdAt, ok := types.RowTime(row.Raw, "created_at"); ok && now.Sub(createdAt) < minAge {
+			continue
+		}
+		status, _ := row.Raw["upload_status"].(string)
+		if status != "completed" && status != "processing" {
+			// Pending rows belong to the janitor, failed rows are already handled
+			continue
+		}
+
+		url, _ := row.Raw["url"].(string)
+		key, _ := rowKey(row, url)
+		if _, ok := byKey[key]; !ok {
+			drift := Drift{Kind: MissingObject, Table: row.Config.Table, MediaID: row.ID, Action: MarkFailed, row: row.Raw,
+				Detail: fmt.Sprintf("url %s has no object", url)}
+			if row.Media != nil {
+				// A lost trim or rendition can be rebuilt from the original upload
+				if original := videoKey(row); key != original {
+					if _, ok := byKey[original]; ok {
+						drift.Action = Reprocess
+						drift.Key = original
+					}
+				}
+			}
+			drifts = append(drifts, drift)
+			continue
+		}
+
+		// Thumbnails are only expected once the worker has been through the row
+		metadata, _ := row.Raw["metadata"].(map[string]interface{})
+		if status != "completed" || metadata["processed_at"] == nil || row.Media == nil {
+			continue
+		}
+		thumbnailURL, _ := row.Raw["thumbnail_url"].(string)
+		if key, ok := rowKey(row, thumbnailURL); ok {
+			if _, ok := byKey[key]; ok {
+				continue
+			}
+		}
+		drift := Drift{Kind: MissingThumbnail, Table: row.Config.Table, MediaID: row.ID, Action: RegenerateThumbnail, row: row.Raw,
+			Detail: "no thumbnail_url"}
+		if thumbnailURL != "" {
+			drift.Kind = StaleThumbnail
+			drift.Detail = fmt.Sprintf("thumbnail_url %s has no object", thumbnailURL)
+		}
+		for _, name := range []string{"thumbnail.jpg", "thumbnail.png"} {
+			if candidate := videoKey(row) + "/" + name; byKey[candidate].Key != "" {
+				drift.Action = LinkThumbnail
+				drift.Key = candidate
+				break
+			}
+		}
+		drifts = append(drifts, drift)
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		if drifts[i].Kind != drifts[j].Kind {
+			return drifts[i].Kind < drifts[j].Kind
+		}
+		return drifts[i].Key < drifts[j].Key
+	})
+	return drifts
+}
+
+// apply makes the fix for one drift. Row updates are conditional on the status the
+// drift was found in, so rows that moved on meanwhile are left alone.
+func apply(ctx context.Context, drift *Drift) error {
+	now := time.Now().Format(time.RFC3339)
+	cfg, ok := configForTable(drift.Table)
+	if !ok {
+		return fmt.Errorf("unknown table %s", drift.Table)
+	}
+	status, _ := drift.row["upload_status"].(string)
+
+	var updates map[string]interface{}
+	switch drift.Action {
+	case DeleteObject:
+		return clients.Storage.Delete(ctx, drift.Key)
+	case MarkFailed:
+		updates = map[string]interface{}{
+			"upload_status": "failed",
+			"metadata": types.MergeMetadata(drift.row, map[string]interface{}{
+				"failure_reason": "video is missing from storage",
+				"failed_at":      now,
+			}),
+		}
+	case Reprocess:
+		updates = map[string]interface{}{
+			"url":           clients.Storage.PublicURL(drift.Key),
+			"upload_status": "completed",
+			"metadata":      types.MergeMetadata(drift.row, nil, "trim", "processed_at"), // clearing processed_at requeues it
+		}
+	case LinkThumbnail:
+		updates = map[string]interface{}{"thumbnail_url": clients.Storage.PublicURL(drift.Key)}
+	case RegenerateThumbnail:
+		// The worker's thumbnail step fills thumbnail_url when the row is processed again
+		updates = map[string]interface{}{
+			"thumbnail_url": nil,
+			"metadata":      types.MergeMetadata(drift.row, nil, "processed_at"),
+		}
+	default:
+		return fmt.Errorf("unknown action %s", drift.Action)
+	}
+
+	updates["updated_at"] = now
+	updated, err := clients.Media.UpdateMedia(ctx, cfg, drift.MediaID, status, updates)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return fmt.Errorf("row changed since it was listed")
+	}
+	log.Printf("reconcile: %s %s %s", drift.Action, cfg.Table, drift.MediaID)
+	return nil
+}
+
+func videoKey(row Row) string {
+	return row.Config.VideoKey(row.Media.ParentID, row.Media.OwnerID, row.ID)
+}
+
+// rowKey returns the key of the object a URL stored on the row points at. The URL's base
+// is whatever was configured when the row was written, so instead of rebuilding URLs
+// the key is cut from the URL where the row's video key starts. Rows whose parent is
+// gone only have the config's path prefix to go by.
+func rowKey(row Row, url string) (string, bool) {
+	start := row.Config.PathPrefix + "/"
+	if row.Media != nil {
+		start = videoKey(row)
+	}
+	i := strings.Index(url, "/"+start)
+	if i < 0 {
+		return "", false
+	}
+	return url[i+1:], true
+}
+
+func configForTable(table string) (types.MediaConfig, bool) {
+	for _, cfg := range types.MediaConfigs {
+		if cfg.Table == table {
+			return cfg, true
+		}
+	}
+	return types.MediaConfig{}, false
+}
@@ -0,0 +1,94 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	publicURL := func(key string) string { return "https://cdn.test/" + key }
+	// Rows written before the public base URL changed
+	oldURL := func(key string) string { return "https://pub-old.r2.dev/media/" + key }
+	trick := types.MediaConfigs[types.VideoTypeTrick]
+
+	object := func(key string, modified time.Time) storage.ObjectInfo {
+		return storage.ObjectInfo{Key: key, LastModified: modified}
+	}
+	row := func(id, url, thumbnail string, processed bool) Row {
+		raw := map[string]interface{}{
+			"id":            id,
+			"url":           url,
+			"upload_status": "completed",
+			"created_at":    old.Format(time.RFC3339),
+			"metadata":      map[string]interface{}{},
+		}
+		if thumbnail != "" {
+			raw["thumbnail_url"] = thumbnail
+		}
+		if processed {
+			raw["metadata"] = map[string]interface{}{"processed_at": old.Format(time.RFC3339)}
+		}
+		return Row{Config: trick, ID: id, Raw: raw, Media: &policy.Media{ID: id, ParentID: "t1", OwnerID: "u1"}}
+	}
+
+	objects := []storage.ObjectInfo{
+		object("tricks/t1/videos/u1/ok", old),
+		object("tricks/t1/videos/u1/ok/thumbnail.jpg", old),
+		object("tricks/t1/videos/u1/gone", old),               // row deleted
+		object("tricks/t1/videos/u1/gone/thumbnail.jpg", old), // and its thumbnail
+		object("tricks/t1/videos/u1/fresh", now),              // may be mid-upload
+		object("tricks/t2/videos/u1/moved", old),              // row's parent is t1
+		object("tricks/t1/videos/u1/trimmed", old),
+		object("tricks/t1/videos/u1/unlinked", old),
+		object("tricks/t1/videos/u1/unlinked/thumbnail.png", old),
+		object("tricks/t1/videos/u1/stale", old),
+		object("tricks/t1/videos/u1/rebased", old),
+		object("tricks/t1/videos/u1/rebased/thumbnail.jpg", old),
+		object("tricks/t1/videos/u1/rebased-gone/thumbnail.jpg", old),
+		object("uploads/tus/x/00000000000000000000", old), // not a media key
+	}
+	rows := []Row{
+		row("ok", publicURL("tricks/t1/videos/u1/ok"), publicURL("tricks/t1/videos/u1/ok/thumbnail.jpg"), true),
+		row("moved", publicURL("tricks/t1/videos/u1/moved"), "", false),
+		row("trimmed", publicURL("tricks/t1/videos/u1/trimmed/trimmed/0-2000.mp4"), "", false),
+		row("unlinked", publicURL("tricks/t1/videos/u1/unlinked"), "", true),
+		row("stale", publicURL("tricks/t1/videos/u1/stale"), publicURL("tricks/t1/videos/u1/stale/thumbnail.jpg"), true),
+		row("rebased", oldURL("tricks/t1/videos/u1/rebased"), oldURL("tricks/t1/videos/u1/rebased/thumbnail.jpg"), true),
+		row("rebased-gone", oldURL("tricks/t1/videos/u1/rebased-gone"), "", true),
+	}
+
+	type result struct {
+		kind   Kind
+		id     string
+		action Action
+		key    string
+	}
+	var got []result
+	for _, d := range Diff(objects, rows, now, 24*time.Hour) {
+		got = append(got, result{d.Kind, d.MediaID, d.Action, d.Key})
+	}
+	want := []result{
+		{MissingObject, "moved", MarkFailed, ""},
+		{MissingObject, "rebased-gone", MarkFailed, ""},
+		{MissingObject, "trimmed", Reprocess, "tricks/t1/videos/u1/trimmed"},
+		{MissingThumbnail, "unlinked", LinkThumbnail, "tricks/t1/videos/u1/unlinked/thumbnail.png"},
+		{OrphanedObject, "gone", DeleteObject, "tricks/t1/videos/u1/gone"},
+		{OrphanedObject, "gone", DeleteObject, "tricks/t1/videos/u1/gone/thumbnail.jpg"},
+		{OrphanedObject, "moved", DeleteObject, "tricks/t2/videos/u1/moved"},
+		{StaleThumbnail, "stale", RegenerateThumbnail, ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d drifts, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("drift %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// Memory keeps the media, parent, log and session tables in memory, for tests. It enforces the
+// database's unique IDs and unique (user, trick) links, and filters and orders like
+// the Supabase backend.
+type Memory struct {
+	mu       sync.Mutex
+	media    map[string]map[string]*Media  // table -> id
+	parents  map[string]map[string]*Parent // table -> id
+	logs     map[string]map[string]*Log    // table -> id
+	sessions map[string]*Session
+	now      func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		media:    map[string]map[string]*Media{},
+		parents:  map[string]map[string]*Parent{},
+		logs:     map[string]map[string]*Log{},
+		sessions: map[string]*Session{},
+		now:      time.Now,
+	}
+}
+
+// AddParent inserts a parent row
+func (m *Memory) AddParent(cfg types.MediaConfig, parent Parent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.insertParent(cfg, parent)
+}
+
+// AddMedia inserts a media row. CreatedAt defaults to now.
+func (m *Memory) AddMedia(cfg types.MediaConfig, media Media) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.insertMedia(cfg, media)
+}
+
+// AddLog inserts a log row
+func (m *Memory) AddLog(cfg types.MediaConfig, log Log) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	logs := table(m.logs, cfg.LogTable)
+	if _, exists := logs[log.ID]; exists {
+		return fmt.Errorf("%s %s: %w", cfg.LogTable, log.ID, ErrDuplicate)
+	}
+	logs[log.ID] = &log
+	return nil
+}
+
+// AddSession inserts a session row
+func (m *Memory) AddSession(session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.sessions[session.ID]; exists {
+		return fmt.Errorf("Sessions %s: %w", session.ID, ErrDuplicate)
+	}
+	m.sessions[session.ID] = &session
+	return nil
+}
+
+func (m *Memory) CreatePendingMedia(ctx context.Context, cfg types.MediaConfig, media PendingMedia) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var parentRecordID string
+	if found := m.findParents(cfg, media.ParentID, media.UserID); len(found) > 0 {
+		parentRecordID = found[0].ID
+	} else if !cfg.AutoCreateUserLink {
+		return "", fmt.Errorf("%s %s for user %s: %w", cfg.ParentTable, media.ParentID, media.UserID, ErrParentNotFound)
+	}
+
+	// Check the media row before creating the link, so a failure writes nothing
+	if _, exists := table(m.media, cfg.Table)[media.ID]; exists {
+		return "", fmt.Errorf("%s %s: %w", cfg.Table, media.ID, ErrDuplicate)
+	}
+	if parentRecordID == "" {
+		parentRecordID = uuid.New().String()
+		if err := m.insertParent(cfg, Parent{ID: parentRecordID, UserID: media.UserID, ParentID: media.ParentID}); err != nil {
+			return "", err
+		}
+	}
+
+	row := Media{
+		ID:             media.ID,
+		ParentRecordID: parentRecordID,
+		URL:            media.URL,
+		FileSizeBytes:  &media.FileSize,
+		MimeType:       &media.MimeType,
+		MediaType:      media.mediaType(),
+		UploadStatus:   "pending",
+		Metadata:       media.Metadata,
+	}
+	if media.DurationSeconds != nil {
+		seconds := float64(*media.DurationSeconds)
+		row.DurationSeconds = &seconds
+	}
+	if media.LogID != "" {
+		row.LogID = &media.LogID
+	}
+	return parentRecordID, m.insertMedia(cfg, row)
+}
+
+func (m *Memory) GetMedia(ctx context.Context, cfg types.MediaConfig, id string) (*Media, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row, ok := table(m.media, cfg.Table)[id]
+	if !ok {
+		return nil, fmt.Errorf("%s %s: %w", cfg.Table, id, ErrNotFound)
+	}
+	media := copyMedia(row)
+	if parent, ok := table(m.parents, cfg.ParentTable)[row.ParentRecordID]; ok {
+		parentCopy := *parent
+		media.Parent = &parentCopy
+	}
+	return &media, nil
+}
+
+func (m *Memory) ListMedia(ctx context.Context, cfg types.MediaConfig, filter MediaFilter, page Page) (MediaPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if page.Sort == "" {
+		page.Sort = SortNewest
+	}
+
+	var parentIDs map[string]bool
+	if filter.ParentRecordIDs != nil {
+		parentIDs = map[string]bool{}
+		for _, id := range filter.ParentRecordIDs {
+			parentIDs[id] = true
+		}
+	}
+
+	media := []Media{}
+	parents := table(m.parents, cfg.ParentTable)
+	for _, row := range table(m.media, cfg.Table) {
+		createdAt, _ := time.Parse(time.RFC3339Nano, row.CreatedAt)
+		parent := parents[row.ParentRecordID]
+		var linked *Log
+		if row.LogID != nil {
+			linked = table(m.logs, cfg.LogTable)[*row.LogID]
+		}
+		switch {
+		case filter.ParentID != "" && (parent == nil || parent.ParentID != filter.ParentID):
+		case filter.UserID != "" && (parent == nil || parent.UserID != filter.UserID):
+		case parentIDs != nil && !parentIDs[row.ParentRecordID]:
+		case filter.UploadStatus != "" && row.UploadStatus != filter.UploadStatus:
+		case filter.Verified && row.UploadStatus != "completed" && row.UploadStatus != "processing":
+		case filter.MediaType != "" && row.MediaType != filter.MediaType:
+		case filter.PublicOnly && !row.Public:
+		case !filter.CreatedFrom.IsZero() && createdAt.Before(filter.CreatedFrom):
+		case !filter.CreatedTo.IsZero() && createdAt.After(filter.CreatedTo):
+		case filter.LogID != "" && (row.LogID == nil || *row.LogID != filter.LogID):
+		case filter.Logged != nil && *filter.Logged != (row.LogID != nil):
+		case filter.SessionID != "" && (linked == nil || linked.SessionID == nil || *linked.SessionID != filter.SessionID):
+		default:
+			found := copyMedia(row)
+			if parent != nil {
+				parentCopy := *parent
+				found.Parent = &parentCopy
+			}
+			if linked != nil {
+				logCopy := *linked
+				found.Log = &logCopy
+			}
+			media = append(media, found)
+		}
+	}
+	sort.Slice(media, func(i, j int) bool { return compareMedia(page.Sort, media[i], media[j]) < 0 })
+
+	result := MediaPage{Media: media}
+	if page.Count {
+		total := len(media)
+		result.Total = &total
+	}
+	if page.After != nil {
+		cursor := Media{ID: page.After.ID, CreatedAt: page.After.CreatedAt, DurationSeconds: page.After.Duration}
+		start := sort.Search(len(media), func(i int) bool { return compareMedia(page.Sort, media[i], cursor) > 0 })
+		result.Media = media[start:]
+	}
+	if page.Limit > 0 && len(result.Media) > page.Limit {
+		result.Media = result.Media[:page.Limit]
+		result.Next = cursorAt(page.Sort, result.Media[page.Limit-1])
+	}
+	return result, nil
+}
+
+func (m *Memory) UpdateMedia(ctx context.Context, cfg types.MediaConfig, id string, ifStatus string, set map[string]interface{}) (bool, error) {
+	return m.UpdateMediaIf(ctx, cfg, id, MediaCondition{UploadStatus: ifStatus}, set)
+}
+
+func (m *Memory) UpdateMediaIf(ctx context.Context, cfg types.MediaConfig, id string, cond MediaCondition, set map[string]interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row, ok := table(m.media, cfg.Table)[id]
+	if !ok || !matches(row, cond) {
+		return false, nil
+	}
+
+	// Apply the update the way the database would, through the row's columns
+	columns := row.Row(cfg)
+	for column, value := range set {
+		columns[column] = value
+	}
+	updated, err := decodeMedia(cfg, columns)
+	if err != nil {
+		return false, err
+	}
+	*row = updated
+	return true, nil
+}
+
+// matches reports whether a row meets an update's condition
+func matches(row *Media, cond MediaCondition) bool {
+	switch {
+	case cond.UploadStatus != "" && row.UploadStatus != cond.UploadStatus:
+	case cond.UpdatedAt != "" && row.UpdatedAt != cond.UpdatedAt:
+	case cond.Unprocessed && row.Metadata["processed_at"] != nil:
+	case cond.NoThumbnail && row.ThumbnailURL != nil:
+	case cond.MatchTrim && (!sameMs(row.TrimStartMs, cond.TrimStartMs) || !sameMs(row.TrimEndMs, cond.TrimEndMs)):
+	default:
+		return true
+	}
+	return false
+}
+
+func sameMs(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (m *Memory) DeleteMedia(ctx context.Context, cfg types.MediaConfig, id string, ifStatus string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rows := table(m.media, cfg.Table)
+	row, ok := rows[id]
+	if !ok || (ifStatus != "" && row.UploadStatus != ifStatus) {
+		return false, nil
+	}
+	delete(rows, id)
+	return true, nil
+}
+
+func (m *Memory) ListMediaByStatus(ctx context.Context, cfg types.MediaConfig, filter StatusFilter, limit int) ([]Media, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	media := []Media{}
+	parents := table(m.parents, cfg.ParentTable)
+	for _, row := range table(m.media, cfg.Table) {
+		updatedAt, _ := time.Parse(time.RFC3339Nano, row.UpdatedAt)
+		switch {
+		case row.UploadStatus != filter.UploadStatus:
+		case !filter.UpdatedBefore.IsZero() && !updatedAt.Before(filter.UpdatedBefore):
+		case filter.Unprocessed && row.Metadata["processed_at"] != nil:
+		case filter.NoFailedStep && row.Metadata["failed_step"] != nil:
+		default:
+			found := copyMedia(row)
+			if parent := parents[row.ParentRecordID]; parent != nil {
+				parentCopy := *parent
+				found.Parent = &parentCopy
+			}
+			media = append(media, found)
+		}
+	}
+	sort.Slice(media, func(i, j int) bool {
+		a, _ := time.Parse(time.RFC3339Nano, media[i].UpdatedAt)
+		b, _ := time.Parse(time.RFC3339Nano, media[j].UpdatedAt)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return media[i].ID < media[j].ID
+	})
+	if len(media) > limit {
+		media = media[:limit]
+	}
+	return media, nil
+}
+
+func (m *Memory) ListStalePending(ctx context.Context, cfg types.MediaConfig, before, resumableBefore time.Time, limit int) ([]Media, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	media := []Media{}
+	parents := table(m.parents, cfg.ParentTable)
+	for _, row := range table(m.media, cfg.Table) {
+		createdAt, _ := time.Parse(time.RFC3339Nano, row.CreatedAt)
+		cutoff := before
+		multipart, _ := row.Metadata["multipart"].(map[string]interface{})
+		if _, tus := row.Metadata["tus"]; tus || multipart["uploadId"] != nil {
+			cutoff = resumableBefore
+		}
+		if row.UploadStatus != "pending" || !createdAt.Before(cutoff) {
+			continue
+		}
+		found := copyMedia(row)
+		if parent := parents[row.ParentRecordID]; parent != nil {
+			parentCopy := *parent
+			found.Parent = &parentCopy
+		}
+		media = append(media, found)
+	}
+	sort.Slice(media, func(i, j int) bool { return compareMedia(SortOldest, media[i], media[j]) < 0 })
+	if len(media) > limit {
+		media = media[:limit]
+	}
+	return media, nil
+}
+
+func (m *Memory) FindParents(ctx context.Context, cfg types.MediaConfig, parentID string, userID string) ([]Parent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.findParents(cfg, parentID, userID), nil
+}
+
+func (m *Memory) GetLog(ctx context.Context, cfg types.MediaConfig, id string) (*Log, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	log, ok := table(m.logs, cfg.LogTable)[id]
+	if !ok {
+		return nil, fmt.Errorf("%s %s: %w", cfg.LogTable, id, ErrNotFound)
+	}
+	logCopy := *log
+	return &logCopy, nil
+}
+
+func (m *Memory) ListLogsBySession(ctx context.Context, cfg types.MediaConfig, sessionID string) ([]Log, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	logs := []Log{}
+	for _, log := range table(m.logs, cfg.LogTable) {
+		if log.SessionID != nil && *log.SessionID == sessionID {
+			logs = append(logs, *log)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		if logs[i].LoggedAt != logs[j].LoggedAt {
+			return logs[i].LoggedAt < logs[j].LoggedAt
+		}
+		return logs[i].ID < logs[j].ID
+	})
+	return logs, nil
+}
+
+func (m *Memory) GetSession(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("Sessions %s: %w", id, ErrNotFound)
+	}
+	sessionCopy := *session
+	return &sessionCopy, nil
+}
+
+func (m *Memory) findParents(cfg types.MediaConfig, parentID string, userID string) []Parent {
+	parents := []Parent{}
+	for _, parent := range table(m.parents, cfg.ParentTable) {
+		if parent.ParentID == parentID && (userID == "" || parent.UserID == userID) {
+			parents = append(parents, *parent)
+		}
+	}
+	sort.Slice(parents, func(i, j int) bool { return parents[i].ID < parents[j].ID })
+	return parents
+}
+
+func (m *Memory) insertParent(cfg types.MediaConfig, parent Parent) error {
+	parents := table(m.parents, cfg.ParentTable)
+	if cfg.ParentIDCol == "id" {
+		parent.ParentID = parent.ID
+	}
+	if _, exists := parents[parent.ID]; exists {
+		return fmt.Errorf("%s %s: %w", cfg.ParentTable, parent.ID, ErrDuplicate)
+	}
+	if cfg.AutoCreateUserLink && len(m.findParents(cfg, parent.ParentID, parent.UserID)) > 0 {
+		return fmt.Errorf("%s for user %s and %s %s: %w", cfg.ParentTable, parent.UserID, cfg.ParentIDCol, parent.ParentID, ErrDuplicate)
+	}
+	parents[parent.ID] = &parent
+	return nil
+}
+
+func (m *Memory) insertMedia(cfg types.MediaConfig, media Media) error {
+	rows := table(m.media, cfg.Table)
+	if _, exists := rows[media.ID]; exists {
+		return fmt.Errorf("%s %s: %w", cfg.Table, media.ID, ErrDuplicate)
+	}
+	if _, ok := table(m.parents, cfg.ParentTable)[media.ParentRecordID]; !ok {
+		return fmt.Errorf("%s %s references a missing %s", cfg.Table, media.ID, cfg.ParentTable)
+	}
+	now := m.now().UTC().Format(time.RFC3339Nano)
+	if media.CreatedAt == "" {
+		media.CreatedAt = now
+	}
+	if media.UpdatedAt == "" {
+		media.UpdatedAt = now
+	}
+	media.Parent = nil
+	rows[media.ID] = &media
+	return nil
+}
+
+func table[T any](tables map[string]map[string]*T, name string) map[string]*T {
+	if tables[name] == nil {
+		tables[name] = map[string]*T{}
+	}
+	return tables[name]
+}
+
+// copyMedia copies a row so callers can't change the table through it
+func copyMedia(row *Media) Media {
+	media := *row
+	if row.Metadata != nil {
+		media.Metadata = map[string]interface{}{}
+		for k, v := range row.Metadata {
+			media.Metadata[k] = v
+		}
+	}
+	return media
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestMemoryCreatesOneLinkPerUserAndTrick(t *testing.T) {
+	ctx, cfg := context.Background(), types.MediaConfigs[types.VideoTypeTrick]
+	store := NewMemory()
+
+	first, err := store.CreatePendingMedia(ctx, cfg, pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := pending
+	second.ID = "v2"
+	if id, err := store.CreatePendingMedia(ctx, cfg, second); err != nil || id != first {
+		t.Fatalf("expected the second upload to reuse link %s, got %q, %v", first, id, err)
+	}
+	if _, err := store.CreatePendingMedia(ctx, cfg, second); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected ErrDuplicate for a reused media id, got %v", err)
+	}
+	if err := store.AddParent(cfg, Parent{ID: "other", UserID: "alice", ParentID: "trick-1"}); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("expected the unique (userID, trickID) constraint, got %v", err)
+	}
+}
+
+func TestMemoryListMediaFiltersAndOrders(t *testing.T) {
+	ctx, cfg := context.Background(), types.MediaConfigs[types.VideoTypeCombo]
+	store := NewMemory()
+	store.AddParent(cfg, Parent{ID: "combo-1", UserID: "alice"})
+	store.AddParent(cfg, Parent{ID: "combo-2", UserID: "alice"})
+	store.AddMedia(cfg, Media{ID: "a", ParentRecordID: "combo-1", MediaType: "video", UploadStatus: "completed", CreatedAt: "2026-01-01T00:00:00Z", Public: true})
+	store.AddMedia(cfg, Media{ID: "b", ParentRecordID: "combo-1", MediaType: "video", UploadStatus: "completed", CreatedAt: "2026-01-01T00:00:00Z"})
+	store.AddMedia(cfg, Media{ID: "c", ParentRecordID: "combo-2", MediaType: "video", UploadStatus: "pending", CreatedAt: "2026-02-01T00:00:00Z"})
+
+	ids := func(filter MediaFilter) string {
+		page, err := store.ListMedia(ctx, cfg, filter, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, m := range page.Media {
+			got += m.ID
+		}
+		return got
+	}
+	cases := []struct {
+		filter MediaFilter
+		want   string
+	}{
+		{MediaFilter{}, "cba"}, // created_at desc, then id desc
+		{MediaFilter{ParentRecordIDs: []string{"combo-1"}}, "ba"},
+		{MediaFilter{ParentRecordIDs: []string{}}, ""},
+		{MediaFilter{UploadStatus: "completed", PublicOnly: true}, "a"},
+	}
+	for _, tc := range cases {
+		if got := ids(tc.filter); got != tc.want {
+			t.Errorf("ListMedia(%+v) = %q, expected %q", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestMemoryUpdateMediaIsConditional(t *testing.T) {
+	ctx, cfg := context.Background(), types.MediaConfigs[types.VideoTypeTrick]
+	store := NewMemory()
+	store.AddParent(cfg, Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	store.AddMedia(cfg, Media{ID: "v1", ParentRecordID: "ut-1", UploadStatus: "processing"})
+
+	if updated, err := store.UpdateMedia(ctx, cfg, "v1", "completed", map[string]interface{}{"trim_start_ms": 1000}); err != nil || updated {
+		t.Fatalf("expected no update while processing, got %v, %v", updated, err)
+	}
+	if updated, err := store.UpdateMedia(ctx, cfg, "v1", "processing", map[string]interface{}{"upload_status": "completed", "trim_start_ms": 1000}); err != nil || !updated {
+		t.Fatalf("expected the update, got %v, %v", updated, err)
+	}
+	media, _ := store.GetMedia(ctx, cfg, "v1")
+	if media.UploadStatus != "completed" || media.TrimStartMs == nil || *media.TrimStartMs != 1000 || media.Parent == nil || media.Parent.UserID != "alice" {
+		t.Fatalf("unexpected row %+v", media)
+	}
+}
+
+func TestMemoryUpdateMediaIfMatchesCondition(t *testing.T) {
+	ctx, cfg := context.Background(), types.MediaConfigs[types.VideoTypeTrick]
+	store := NewMemory()
+	store.AddParent(cfg, Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	start, other := int64(1000), int64(2000)
+	thumbnail := "https://cdn.test/thumbnail.jpg"
+	store.AddMedia(cfg, Media{ID: "v1", ParentRecordID: "ut-1", UploadStatus: "processing", TrimStartMs: &start, ThumbnailURL: &thumbnail})
+
+	set := map[string]interface{}{"url": "https://cdn.test/v1"}
+	cases := []struct {
+		cond MediaCondition
+		want bool
+	}{
+		{MediaCondition{UploadStatus: "processing", MatchTrim: true, TrimStartMs: &other}, false},
+		{MediaCondition{UploadStatus: "processing", MatchTrim: true}, false}, // the row is trimmed
+		{MediaCondition{NoThumbnail: true}, false},
+		{MediaCondition{UpdatedAt: "2020-01-01T00:00:00Z"}, false},
+		{MediaCondition{UploadStatus: "processing", MatchTrim: true, TrimStartMs: &start}, true},
+	}
+	for _, tc := range cases {
+		if updated, err := store.UpdateMediaIf(ctx, cfg, "v1", tc.cond, set); err != nil || updated != tc.want {
+			t.Errorf("UpdateMediaIf(%+v) = %v, %v, expected %v", tc.cond, updated, err, tc.want)
+		}
+	}
+
+	if deleted, _ := store.DeleteMedia(ctx, cfg, "v1", "failed"); deleted {
+		t.Fatal("expected a processing row not to be deleted as failed")
+	}
+	if deleted, _ := store.DeleteMedia(ctx, cfg, "v1", ""); !deleted {
+		t.Fatal("expected the row to be deleted")
+	}
+}
+
+func TestMemoryListLogsBySession(t *testing.T) {
+	ctx, cfg := context.Background(), types.MediaConfigs[types.VideoTypeTrick]
+	store := NewMemory()
+	session, other := "s1", "s2"
+	store.AddLog(cfg, Log{ID: "l2", SessionID: &session, LoggedAt: "2026-01-02T00:00:00Z"})
+	store.AddLog(cfg, Log{ID: "l1", SessionID: &session, LoggedAt: "2026-01-01T00:00:00Z"})
+	store.AddLog(cfg, Log{ID: "l3", SessionID: &other, LoggedAt: "2026-01-01T00:00:00Z"})
+
+	logs, err := store.ListLogsBySession(ctx, cfg, "s1")
+	if err != nil || len(logs) != 2 || logs[0].ID != "l1" || logs[1].ID != "l2" {
+		t.Fatalf("expected l1, l2, got %+v, %v", logs, err)
+	}
+	if _, err := store.GetLog(ctx, cfg, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// MediaSort orders a media listing. Every order ends on id so rows with equal keys
+// still page deterministically.
+type MediaSort string
+
+const (
+	SortNewest   MediaSort = "newest"   // created_at desc
+	SortOldest   MediaSort = "oldest"   // created_at asc
+	SortDuration MediaSort = "duration" // longest first, unknown durations last
+)
+
+// ErrInvalidCursor is returned for a cursor that can't be decoded or was issued for a
+// different sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page asks ListMedia for one page of rows
+type Page struct {
+	Sort  MediaSort // defaults to SortNewest
+	Limit int       // 0 returns every row
+	After *Cursor   // continue after this row
+	Count bool      // also count every row matching the filter
+}
+
+// MediaPage is a page of rows. Next is nil on the last page, Total is only set when
+// the page asked for a count.
+type MediaPage struct {
+	Media []Media
+	Next  *Cursor
+	Total *int
+}
+
+// Cursor is the sort key of the last row of a page
+type Cursor struct {
+	Sort      MediaSort `json:"s"`
+	CreatedAt string    `json:"c,omitempty"`
+	Duration  *float64  `json:"d,omitempty"`
+	ID        string    `json:"i"`
+}
+
+// ParseSort validates a sort name, "" meaning SortNewest
+func ParseSort(value string) (MediaSort, bool) {
+	switch sort := MediaSort(value); sort {
+	case "":
+		return SortNewest, true
+	case SortNewest, SortOldest, SortDuration:
+		return sort, true
+	default:
+		return "", false
+	}
+}
+
+// cursorAt returns the cursor that continues after media
+func cursorAt(sort MediaSort, media Media) *Cursor {
+	cursor := &Cursor{Sort: sort, ID: media.ID}
+	if sort == SortDuration {
+		cursor.Duration = media.DurationSeconds
+	} else {
+		cursor.CreatedAt = media.CreatedAt
+	}
+	return cursor
+}
+
+// Encode returns the cursor as an opaque URL-safe string
+func (c *Cursor) Encode() string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// DecodeCursor parses a string from Encode, checking it was issued for sort
+func DecodeCursor(value string, sort MediaSort) (*Cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil || cursor.ID == "" || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	if sort != SortDuration && cursor.CreatedAt == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// MergePages merges pages listed from different media tables with the same page request
+// into one page in its sort order. from[i] is the index of the page the i-th row came
+// from. A cursor from the merged page continues every table.
+func MergePages(page Page, pages ...MediaPage) (merged MediaPage, from []int) {
+	if page.Sort == "" {
+		page.Sort = SortNewest
+	}
+	type entry struct {
+		media Media
+		from  int
+	}
+	var entries []entry
+	more := false
+	for i, p := range pages {
+		for _, media := range p.Media {
+			entries = append(entries, entry{media, i})
+		}
+		more = more || p.Next != nil
+		if p.Total != nil {
+			if merged.Total == nil {
+				merged.Total = new(int)
+			}
+			*merged.Total += *p.Total
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return compareMedia(page.Sort, entries[i].media, entries[j].media) < 0 })
+
+	// Each table returned its first Limit rows, so the first Limit merged rows are exact
+	if page.Limit > 0 && len(entries) > page.Limit {
+		entries = entries[:page.Limit]
+		more = true
+	}
+	merged.Media = make([]Media, len(entries))
+	from = make([]int, len(entries))
+	for i, e := range entries {
+		merged.Media[i], from[i] = e.media, e.from
+	}
+	if more && len(entries) > 0 {
+		merged.Next = cursorAt(page.Sort, entries[len(entries)-1].media)
+	}
+	return merged, from
+}
+
+// compareMedia returns a negative number if a sorts before b
+func compareMedia(sort MediaSort, a, b Media) int {
+	switch sort {
+	case SortOldest:
+		if c := strings.Compare(a.CreatedAt, b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	case SortDuration:
+		switch {
+		case a.DurationSeconds == nil && b.DurationSeconds != nil:
+			return 1
+		case a.DurationSeconds != nil && b.DurationSeconds == nil:
+			return -1
+		case a.DurationSeconds != nil && *a.DurationSeconds != *b.DurationSeconds:
+			if *a.DurationSeconds > *b.DurationSeconds {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(b.ID, a.ID)
+	default:
+		if c := strings.Compare(b.CreatedAt, a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	}
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// PolicyLoader loads policy data through the media and parent repositories
+type PolicyLoader struct {
+	MediaRepo  MediaRepository
+	ParentRepo ParentRepository
+}
+
+func NewPolicyLoader(media MediaRepository, parents ParentRepository) *PolicyLoader {
+	return &PolicyLoader{MediaRepo: media, ParentRepo: parents}
+}
+
+func (l *PolicyLoader) Media(ctx context.Context, cfg types.MediaConfig, mediaID string) (*policy.Media, error) {
+	media, err := l.MediaRepo.GetMedia(ctx, cfg, mediaID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%s %s: %w", cfg.Table, mediaID, policy.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", cfg.Table, err)
+	}
+	return PolicyMedia(cfg, media)
+}
+
+// PolicyMedia converts a row loaded with its parent to the policy's view of it. A row
+// whose parent is gone can't be authorized and returns policy.ErrNotFound.
+func PolicyMedia(cfg types.MediaConfig, media *Media) (*policy.Media, error) {
+	if media.Parent == nil {
+		return nil, fmt.Errorf("%s %s has no %s record: %w", cfg.Table, media.ID, cfg.ParentTable, policy.ErrNotFound)
+	}
+
+	return &policy.Media{
+		ID:             media.ID,
+		OwnerID:        media.Parent.UserID,
+		ParentID:       media.Parent.ParentID,
+		ParentRecordID: media.Parent.ID,
+		Public:         media.Public,
+		Row:            media.Row(cfg),
+	}, nil
+}
+
+func (l *PolicyLoader) ParentOwner(ctx context.Context, cfg types.MediaConfig, parentID string) (string, error) {
+	parents, err := l.ParentRepo.FindParents(ctx, cfg, parentID, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", cfg.ParentTable, err)
+	}
+	if len(parents) == 0 {
+		return "", fmt.Errorf("%s %s: %w", cfg.ParentTable, parentID, policy.ErrNotFound)
+	}
+	return parents[0].UserID, nil
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperbolic/dolos-web-service/types"
+	"github.com/lib/pq"
+)
+
+// PostgresStore talks to the database directly (DATABASE_URL), so multi-row operations
+// run in a transaction
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// OpenPostgres connects to databaseURL and checks the connection
+func OpenPostgres(ctx context.Context, databaseURL string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return NewPostgresStore(db), nil
+}
+
+func (s *PostgresStore) CreatePendingMedia(ctx context.Context, cfg types.MediaConfig, media PendingMedia) (string, error) {
+	var parentRecordID string
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			fmt.Sprintf(`SELECT id FROM %s WHERE %s = $1 AND %s = $2 LIMIT 1`,
+				pq.QuoteIdentifier(cfg.ParentTable), pq.QuoteIdentifier(cfg.UserIDCol), pq.QuoteIdentifier(cfg.ParentIDCol)),
+			media.UserID, media.ParentID,
+		).Scan(&parentRecordID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && cfg.AutoCreateUserLink:
+			// A concurrent transaction inserting the same link makes this wait for it and
+			// then return its row
+			if err := tx.QueryRowContext(ctx,
+				fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, %[3]s) VALUES ($1, $2) ON CONFLICT (%[2]s, %[3]s) DO UPDATE SET %[2]s = EXCLUDED.%[2]s RETURNING id`,
+					pq.QuoteIdentifier(cfg.ParentTable), pq.QuoteIdentifier(cfg.UserIDCol), pq.QuoteIdentifier(cfg.ParentIDCol)),
+				media.UserID, media.ParentID,
+			).Scan(&parentRecordID); err != nil {
+				return fmt.Errorf("failed to create %s record: %w", cfg.ParentTable, err)
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("%s %s for user %s: %w", cfg.ParentTable, media.ParentID, media.UserID, ErrParentNotFound)
+		case err != nil:
+			return fmt.Errorf("failed to look up %s: %w", cfg.ParentTable, err)
+		}
+
+		if err := insertRow(ctx, tx, cfg.Table, mediaRow(cfg, media, parentRecordID)); err != nil {
+			return fmt.Errorf("failed to insert %s: %w", cfg.Table, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return parentRecordID, nil
+}
+
+// withTx runs fn in a transaction, committing if it returns nil and rolling back otherwise
+func (s *PostgresStore) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertRow inserts a row given as column values. Maps are stored as JSON.
+func insertRow(ctx context.Context, tx *sql.Tx, table string, row map[string]interface{}) error {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	quoted := make([]string, len(columns))
+	placeholders := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		quoted[i] = pq.QuoteIdentifier(column)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = row[column]
+		if value, ok := args[i].(map[string]interface{}); ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			args[i] = string(encoded)
+		}
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", pq.QuoteIdentifier(table), strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
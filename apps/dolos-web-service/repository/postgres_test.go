@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// fakeDB is a database/sql driver that records statements and transaction outcomes.
+// Lookups find no row, INSERT ... RETURNING returns the id "ut-new", and inserts into
+// failTable fail.
+type fakeDB struct {
+	failTable  string
+	statements []string
+	committed  int
+	rolledBack int
+}
+
+func (d *fakeDB) Connect(ctx context.Context) (driver.Conn, error) { return &fakeConn{db: d}, nil }
+func (d *fakeDB) Driver() driver.Driver                            { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return &fakeTx{db: c.db}, nil }
+
+// CheckNamedValue passes every argument through, the stub never encodes them
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (t *fakeTx) Commit() error   { t.db.committed++; return nil }
+func (t *fakeTx) Rollback() error { t.db.rolledBack++; return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.statements = append(s.db.statements, s.query)
+	if s.db.failTable != "" && strings.HasPrefix(s.query, `INSERT INTO "`+s.db.failTable+`"`) {
+		return nil, errors.New("violates check constraint")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.statements = append(s.db.statements, s.query)
+	var ids []string
+	if strings.Contains(s.query, "RETURNING id") {
+		ids = []string{"ut-new"}
+	}
+	return &fakeRows{ids: ids}, nil
+}
+
+type fakeRows struct{ ids []string }
+
+func (r *fakeRows) Columns() []string { return []string{"id"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.ids) == 0 {
+		return io.EOF
+	}
+	dest[0], r.ids = r.ids[0], r.ids[1:]
+	return nil
+}
+
+func TestPostgresStoreRollsBackParentOnFailure(t *testing.T) {
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	media := PendingMedia{ID: "v1", UserID: "alice", ParentID: "trick-1", URL: "https://cdn.test/v1", FileSize: 5, MimeType: "video/mp4", MediaType: "video"}
+
+	db := &fakeDB{failTable: cfg.Table}
+	store := NewPostgresStore(sql.OpenDB(db))
+	if _, err := store.CreatePendingMedia(context.Background(), cfg, media); err == nil {
+		t.Fatal("expected the failed media insert to be returned")
+	}
+	if db.rolledBack != 1 || db.committed != 0 {
+		t.Fatalf("expected the transaction to be rolled back, got %d commits and %d rollbacks", db.committed, db.rolledBack)
+	}
+	if len(db.statements) != 3 || !strings.HasPrefix(db.statements[1], `INSERT INTO "`+cfg.ParentTable+`"`) {
+		t.Fatalf("expected the link to be created in the same transaction, got %q", db.statements)
+	}
+
+	db = &fakeDB{}
+	store = NewPostgresStore(sql.OpenDB(db))
+	parentRecordID, err := store.CreatePendingMedia(context.Background(), cfg, media)
+	if err != nil || parentRecordID != "ut-new" {
+		t.Fatalf("expected the new link's id, got %q %v", parentRecordID, err)
+	}
+	if db.committed != 1 || db.rolledBack != 0 {
+		t.Fatalf("expected the transaction to be committed, got %d commits and %d rollbacks", db.committed, db.rolledBack)
+	}
+}
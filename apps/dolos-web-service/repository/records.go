@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// Media is a TrickMedia/ComboMedia row
+type Media struct {
+	ID              string                 `json:"id"`
+	ParentRecordID  string                 `json:"-"` // the config's ForeignKey column
+	LogID           *string                `json:"-"` // the config's LogKey column
+	URL             string                 `json:"url"`
+	ThumbnailURL    *string                `json:"thumbnail_url"`
+	DurationSeconds *float64               `json:"duration_seconds"`
+	FileSizeBytes   *int64                 `json:"file_size_bytes"`
+	MimeType        *string                `json:"mime_type"`
+	MediaType       string                 `json:"media_type"`
+	UploadStatus    string                 `json:"upload_status"`
+	Public          bool                   `json:"public,omitempty"` // ComboMedia only
+	TrimStartMs     *int64                 `json:"trim_start_ms,omitempty"`
+	TrimEndMs       *int64                 `json:"trim_end_ms,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata"`
+	CreatedAt       string                 `json:"created_at,omitempty"`
+	UpdatedAt       string                 `json:"updated_at,omitempty"`
+
+	Parent *Parent `json:"-"` // set by GetMedia and ListMedia
+	Log    *Log    `json:"-"` // the linked log, set by ListMedia
+}
+
+// Parent is a UserToTricks/UserCombos row
+type Parent struct {
+	ID       string // the row's own ID, referenced by media rows
+	UserID   string
+	ParentID string // trickID for UserToTricks, the row ID for UserCombos
+	Name     string // the trick's or combo's name, set by ListMedia
+}
+
+// Log is a TrickLogs/ComboLogs row
+type Log struct {
+	ID             string  `json:"id"`
+	ParentRecordID string  `json:"-"` // the config's ForeignKey column
+	SessionID      *string `json:"session_id"`
+	IsPublic       *bool   `json:"is_public"`
+	Landed         *bool   `json:"landed"`
+	LoggedAt       string  `json:"logged_at"`
+	CreatedAt      string  `json:"created_at,omitempty"`
+}
+
+// Session is a Sessions row
+type Session struct {
+	ID        string  `json:"id"`
+	UserID    string  `json:"user_id"`
+	StartedAt string  `json:"started_at"`
+	EndedAt   *string `json:"ended_at"`
+	IsPublic  *bool   `json:"is_public"`
+}
+
+// MediaFilter selects media rows for ListMedia. Empty fields don't filter, except an
+// empty non-nil ParentRecordIDs, which matches nothing.
+type MediaFilter struct {
+	ParentID        string // the parent's ParentIDCol, e.g. the trick
+	UserID          string // the parent's owner
+	ParentRecordIDs []string
+	UploadStatus    string
+	Verified        bool // completed or processing: the upload checked out, whether or not the worker is done with it
+	MediaType       string
+	PublicOnly      bool
+	CreatedFrom     time.Time // created at or after
+	CreatedTo       time.Time // created at or before
+	LogID           string    // linked to this log
+	Logged          *bool     // linked to any log, or to none
+	SessionID       string    // linked to a log of this session
+}
+
+// MediaCondition restricts UpdateMediaIf to a row still in the state the caller saw.
+// Empty fields don't restrict.
+type MediaCondition struct {
+	UploadStatus string
+	UpdatedAt    string // updated_at is exactly this, nobody touched the row since
+	Unprocessed  bool   // metadata.processed_at isn't set
+	NoThumbnail  bool   // thumbnail_url is null
+	MatchTrim    bool   // the trim points equal TrimStartMs and TrimEndMs, nil matching null
+	TrimStartMs  *int64
+	TrimEndMs    *int64
+}
+
+// StatusFilter selects the rows in one upload_status for ListMediaByStatus
+type StatusFilter struct {
+	UploadStatus  string
+	UpdatedBefore time.Time // last updated before, zero doesn't filter
+	Unprocessed   bool      // metadata.processed_at isn't set
+	NoFailedStep  bool      // metadata.failed_step isn't set: the upload failed, not the worker
+}
+
+// Row returns the media row as PostgREST would return it, for code that still works
+// with untyped rows
+func (m *Media) Row(cfg types.MediaConfig) map[string]interface{} {
+	row := map[string]interface{}{}
+	if encoded, err := json.Marshal(m); err == nil {
+		json.Unmarshal(encoded, &row)
+	}
+	row[cfg.ForeignKey] = m.ParentRecordID
+	if m.LogID != nil {
+		row[cfg.LogKey] = *m.LogID
+	} else {
+		row[cfg.LogKey] = nil
+	}
+	return row
+}
+
+// Video converts the row to the API shape
+func (m *Media) Video() types.VideoMetadata {
+	row := types.MediaRow{
+		ID:              m.ID,
+		URL:             m.URL,
+		ThumbnailURL:    m.ThumbnailURL,
+		DurationSeconds: m.DurationSeconds,
+		FileSizeBytes:   m.FileSizeBytes,
+		MimeType:        m.MimeType,
+		MediaType:       m.MediaType,
+		UploadStatus:    m.UploadStatus,
+		CreatedAt:       m.CreatedAt,
+	}
+	// The processing results in metadata are typed by MediaRow
+	if encoded, err := json.Marshal(m.Metadata); err == nil {
+		json.Unmarshal(encoded, &row.Metadata)
+	}
+	video := row.VideoMetadata()
+	if m.LogID != nil {
+		video.LogID = *m.LogID
+	}
+	if m.Parent != nil {
+		video.UserID = m.Parent.UserID
+	}
+	return video
+}
+
+// decodeMedia builds a Media from a PostgREST row, with the parent if it was embedded
+func decodeMedia(cfg types.MediaConfig, row map[string]interface{}) (Media, error) {
+	var media Media
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		return media, err
+	}
+	if err := json.Unmarshal(encoded, &media); err != nil {
+		return media, fmt.Errorf("invalid %s row: %w", cfg.Table, err)
+	}
+	switch parent := row[cfg.ForeignKey].(type) {
+	case string:
+		media.ParentRecordID = parent
+	case map[string]interface{}:
+		decoded := decodeParent(cfg, parent)
+		media.ParentRecordID = decoded.ID
+		media.Parent = &decoded
+	}
+	switch log := row[cfg.LogKey].(type) {
+	case string:
+		media.LogID = &log
+	case map[string]interface{}:
+		decoded, err := decodeLog(cfg, log)
+		if err != nil {
+			return media, err
+		}
+		media.LogID = &decoded.ID
+		media.Log = &decoded
+	}
+	return media, nil
+}
+
+func decodeParent(cfg types.MediaConfig, row map[string]interface{}) Parent {
+	parent := Parent{}
+	parent.ID, _ = row["id"].(string)
+	parent.UserID, _ = row[cfg.UserIDCol].(string)
+	parent.ParentID, _ = row[cfg.ParentIDCol].(string)
+	if named, ok := row[cfg.NameTable].(map[string]interface{}); ok {
+		parent.Name, _ = named["name"].(string)
+	} else {
+		parent.Name, _ = row["name"].(string)
+	}
+	return parent
+}
+
+func decodeLog(cfg types.MediaConfig, row map[string]interface{}) (Log, error) {
+	var log Log
+	encoded, err := json.Marshal(row)
+	if err != nil {
+		return log, err
+	}
+	if err := json.Unmarshal(encoded, &log); err != nil {
+		return log, fmt.Errorf("invalid %s row: %w", cfg.LogTable, err)
+	}
+	log.ParentRecordID, _ = row[cfg.ForeignKey].(string)
+	return log, nil
+}
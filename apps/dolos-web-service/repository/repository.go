@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/supabase"
+	"github.com/hyperbolic/dolos-web-service/types"
+	"github.com/lib/pq"
+)
+
+// ErrNotFound is returned when a row looked up by ID doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned by the in-memory repositories for a unique constraint
+// violation. The Supabase and Postgres backends return their own error types, which
+// HTTPStatus maps the same way.
+var ErrDuplicate = errors.New("duplicate key")
+
+// ErrParentNotFound is returned when an upload's parent record doesn't exist and isn't
+// created automatically (combos)
+var ErrParentNotFound = errors.New("parent record not found")
+
+// PendingMedia is the media row inserted when an upload is requested
+type PendingMedia struct {
+	ID              string
+	ParentID        string // trick ID or UserCombos ID, as in the request
+	UserID          string
+	URL             string
+	FileSize        int64
+	MimeType        string
+	MediaType       string // video or image, video if empty
+	DurationSeconds *int
+	LogID           string // TrickLogs or ComboLogs row the video records, if any
+	Metadata        map[string]interface{}
+}
+
+// mediaType is the row's media_type, video unless the upload is an image
+func (m PendingMedia) mediaType() string {
+	if m.MediaType == "" {
+		return "video"
+	}
+	return m.MediaType
+}
+
+// Store runs the data operations that touch more than one row
+type Store interface {
+	// CreatePendingMedia resolves the parent record, creating the user link when the
+	// config allows it, and inserts the pending media row. Either both rows are written
+	// or neither is. It returns the parent record's ID.
+	CreatePendingMedia(ctx context.Context, cfg types.MediaConfig, media PendingMedia) (string, error)
+}
+
+// MediaRepository reads and changes TrickMedia/ComboMedia rows
+type MediaRepository interface {
+	// GetMedia returns the row with its parent, or ErrNotFound
+	GetMedia(ctx context.Context, cfg types.MediaConfig, id string) (*Media, error)
+	// ListMedia returns a page of the matching rows in the page's sort order
+	ListMedia(ctx context.Context, cfg types.MediaConfig, filter MediaFilter, page Page) (MediaPage, error)
+	// UpdateMedia sets columns on a row. With ifStatus set, only a row still in that
+	// upload_status is updated. It reports whether a row was updated.
+	UpdateMedia(ctx context.Context, cfg types.MediaConfig, id string, ifStatus string, set map[string]interface{}) (bool, error)
+	// UpdateMediaIf sets columns on a row only if it matches cond. It reports whether a
+	// row was updated.
+	UpdateMediaIf(ctx context.Context, cfg types.MediaConfig, id string, cond MediaCondition, set map[string]interface{}) (bool, error)
+	// DeleteMedia deletes a row. With ifStatus set, only a row still in that upload_status
+	// is deleted. It reports whether a row was deleted.
+	DeleteMedia(ctx context.Context, cfg types.MediaConfig, id string, ifStatus string) (bool, error)
+	// ListMediaByStatus returns up to limit rows matching the filter with their parents,
+	// least recently updated first
+	ListMediaByStatus(ctx context.Context, cfg types.MediaConfig, filter StatusFilter, limit int) ([]Media, error)
+	// ListStalePending returns up to limit pending rows with their parents, oldest first:
+	// single PUT uploads created before before, and resumable (multipart or tus) uploads
+	// created before resumableBefore
+	ListStalePending(ctx context.Context, cfg types.MediaConfig, before, resumableBefore time.Time, limit int) ([]Media, error)
+}
+
+// ParentRepository reads UserToTricks/UserCombos rows
+type ParentRepository interface {
+	// FindParents returns the parent rows for a trick ID (or UserCombos ID), only the
+	// user's if userID is set
+	FindParents(ctx context.Context, cfg types.MediaConfig, parentID string, userID string) ([]Parent, error)
+}
+
+// LogRepository reads TrickLogs/ComboLogs rows
+type LogRepository interface {
+	// GetLog returns the log row, or ErrNotFound
+	GetLog(ctx context.Context, cfg types.MediaConfig, id string) (*Log, error)
+	// ListLogsBySession returns the session's logs, oldest first
+	ListLogsBySession(ctx context.Context, cfg types.MediaConfig, sessionID string) ([]Log, error)
+}
+
+// SessionRepository reads Sessions rows
+type SessionRepository interface {
+	// GetSession returns the session row, or ErrNotFound
+	GetSession(ctx context.Context, id string) (*Session, error)
+}
+
+// userLink is the parent row created for a user's first upload to a trick. Only the
+// key columns are set, so resolving a conflict with an existing link doesn't reset its
+// progress (landed defaults to false).
+func userLink(cfg types.MediaConfig, media PendingMedia) map[string]interface{} {
+	return map[string]interface{}{
+		cfg.UserIDCol:   media.UserID,
+		cfg.ParentIDCol: media.ParentID,
+	}
+}
+
+// mediaRow is the column set of a pending media row
+func mediaRow(cfg types.MediaConfig, media PendingMedia, parentRecordID string) map[string]interface{} {
+	row := map[string]interface{}{
+		"id":              media.ID,
+		cfg.ForeignKey:    parentRecordID,
+		"url":             media.URL,
+		"file_size_bytes": media.FileSize,
+		"mime_type":       media.MimeType,
+		"media_type":      media.mediaType(),
+		"upload_status":   "pending",
+	}
+	if media.DurationSeconds != nil {
+		row["duration_seconds"] = *media.DurationSeconds
+	}
+	if media.LogID != "" {
+		row[cfg.LogKey] = media.LogID
+	}
+	if media.Metadata != nil {
+		row["metadata"] = media.Metadata
+	}
+	return row
+}
+
+// HTTPStatus maps a Store error to the status a handler should respond with
+func HTTPStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrParentNotFound), errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDuplicate):
+		return http.StatusConflict
+	}
+	// Postgres errors carry the same SQLSTATE codes PostgREST passes through
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return supabase.HTTPStatus(&supabase.Error{Code: string(pqErr.Code), Message: pqErr.Message})
+	}
+	return supabase.HTTPStatus(err)
+}
@@ -0,0 +1,461 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/supabase"
+	"github.com/hyperbolic/dolos-web-service/types"
+	"github.com/lib/pq"
+)
+
+// fakePostgREST records requests and answers them from handlers keyed by "METHOD table"
+type fakePostgREST struct {
+	mu       sync.Mutex
+	requests []string
+	handlers map[string]func(w http.ResponseWriter, r *http.Request)
+}
+
+func (f *fakePostgREST) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := r.Method + " " + strings.TrimPrefix(r.URL.Path, "/rest/v1/")
+	f.mu.Lock()
+	f.requests = append(f.requests, route)
+	f.mu.Unlock()
+	if handler, ok := f.handlers[route]; ok {
+		handler(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func newFakeStore(t *testing.T, handlers map[string]func(w http.ResponseWriter, r *http.Request)) (*SupabaseStore, *fakePostgREST) {
+	fake := &fakePostgREST{handlers: handlers}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return NewSupabaseStore(&supabase.Client{BaseURL: server.URL}), fake
+}
+
+func respond(body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, body) }
+}
+
+var pending = PendingMedia{ID: "v1", ParentID: "trick-1", UserID: "alice", URL: "https://cdn/v1", FileSize: 1024, MimeType: "video/mp4"}
+
+func TestSupabaseStoreCreatesParentAndMedia(t *testing.T) {
+	store, fake := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET UserToTricks":  respond(`[]`),
+		"POST UserToTricks": respond(`[{"id":"ut-1"}]`),
+		"POST TrickMedia":   respond(`[{"id":"v1"}]`),
+	})
+
+	parentRecordID, err := store.CreatePendingMedia(context.Background(), types.MediaConfigs[types.VideoTypeTrick], pending)
+	if err != nil || parentRecordID != "ut-1" {
+		t.Fatalf("unexpected result %q, %v", parentRecordID, err)
+	}
+	if got := strings.Join(fake.requests, ", "); got != "GET UserToTricks, POST UserToTricks, POST TrickMedia" {
+		t.Fatalf("unexpected requests %s", got)
+	}
+}
+
+func TestSupabaseStoreRemovesCreatedParentOnFailure(t *testing.T) {
+	store, fake := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET UserToTricks":  respond(`[]`),
+		"POST UserToTricks": respond(`[{"id":"ut-1"}]`),
+		"POST TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"code":"23505","message":"duplicate key"}`)
+		},
+		"DELETE UserToTricks": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("id") != "eq.ut-1" {
+				t.Errorf("unexpected delete filter %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `[]`)
+		},
+	})
+
+	_, err := store.CreatePendingMedia(context.Background(), types.MediaConfigs[types.VideoTypeTrick], pending)
+	if !supabase.IsUniqueViolation(err) || HTTPStatus(err) != http.StatusConflict {
+		t.Fatalf("expected the insert's unique violation, got %v", err)
+	}
+	if got := fake.requests[len(fake.requests)-1]; got != "DELETE UserToTricks" {
+		t.Fatalf("expected the created parent to be deleted, requests were %v", fake.requests)
+	}
+}
+
+func TestSupabaseStoreKeepsConcurrentParentOnFailure(t *testing.T) {
+	// A concurrent upload inserted the link between the select and this upload's insert
+	selects := 0
+	store, fake := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET UserToTricks": func(w http.ResponseWriter, r *http.Request) {
+			if selects++; selects == 1 {
+				fmt.Fprint(w, `[]`)
+				return
+			}
+			fmt.Fprint(w, `[{"id":"ut-other"}]`)
+		},
+		"POST UserToTricks": respond(`[]`),
+		"POST TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":"23514","message":"violates check constraint"}`)
+		},
+	})
+
+	if _, err := store.CreatePendingMedia(context.Background(), types.MediaConfigs[types.VideoTypeTrick], pending); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := strings.Join(fake.requests, ", "); got != "GET UserToTricks, POST UserToTricks, GET UserToTricks, POST TrickMedia" {
+		t.Fatalf("expected the other upload's link to be found and kept, requests were %s", got)
+	}
+}
+
+func TestSupabaseStoreKeepsExistingParentOnFailure(t *testing.T) {
+	store, fake := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET UserCombos": respond(`[{"id":"combo-1"}]`),
+		"POST ComboMedia": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	})
+
+	combo := pending
+	combo.ParentID = "combo-1"
+	if _, err := store.CreatePendingMedia(context.Background(), types.MediaConfigs[types.VideoTypeCombo], combo); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, request := range fake.requests {
+		if strings.HasPrefix(request, "DELETE") {
+			t.Fatalf("an existing parent must not be deleted, requests were %v", fake.requests)
+		}
+	}
+}
+
+func TestSupabaseStoreMissingCombo(t *testing.T) {
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET UserCombos": respond(`[]`),
+	})
+
+	_, err := store.CreatePendingMedia(context.Background(), types.MediaConfigs[types.VideoTypeCombo], pending)
+	if !errors.Is(err, ErrParentNotFound) || HTTPStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected ErrParentNotFound, got %v", err)
+	}
+}
+
+func TestHTTPStatusForPostgresErrors(t *testing.T) {
+	err := fmt.Errorf("failed to insert TrickMedia: %w", &pq.Error{Code: "23505"})
+	if got := HTTPStatus(err); got != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", got)
+	}
+	if got := HTTPStatus(&pq.Error{Code: "57014"}); got != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", got)
+	}
+}
+
+// userLinks is a fake UserToTricks table with the unique (userID, trickID) constraint
+type userLinks struct {
+	mu    sync.Mutex
+	links map[string]string // userID/trickID -> id
+}
+
+func (u *userLinks) insert(w http.ResponseWriter, r *http.Request) {
+	var row map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&row); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := row["userID"] + "/" + row["trickID"]
+	ignore := r.URL.Query().Get("on_conflict") == "userID,trickID" && strings.Contains(r.Header.Get("Prefer"), "resolution=ignore-duplicates")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, exists := u.links[key]; exists {
+		if !ignore {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"code":"23505","message":"duplicate key value violates unique constraint \"UserToTricks_userID_trickID_key\""}`)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+		return
+	}
+	id := fmt.Sprintf("ut-%d", len(u.links)+1)
+	u.links[key] = id
+	fmt.Fprintf(w, `[{"id":%q}]`, id)
+}
+
+func (u *userLinks) find(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Query().Get("userID"), "eq.") + "/" + strings.TrimPrefix(r.URL.Query().Get("trickID"), "eq.")
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if id, ok := u.links[key]; ok {
+		fmt.Fprintf(w, `[{"id":%q}]`, id)
+		return
+	}
+	fmt.Fprint(w, `[]`)
+}
+
+func TestConcurrentUploadsCreateOneUserLink(t *testing.T) {
+	const uploads = 8
+	links := &userLinks{links: map[string]string{}}
+
+	// Every upload's first select runs before any link exists, the race the constraint
+	// resolves. The uploads that lose the insert select again.
+	var selects sync.WaitGroup
+	var calls atomic.Int32
+	selects.Add(uploads)
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET UserToTricks": func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) > uploads {
+				links.find(w, r)
+				return
+			}
+			selects.Done()
+			selects.Wait()
+			fmt.Fprint(w, `[]`)
+		},
+		"POST UserToTricks": links.insert,
+		"POST TrickMedia":   respond(`[{}]`),
+	})
+
+	ids := make([]string, uploads)
+	errs := make([]error, uploads)
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			media := pending
+			media.ID = fmt.Sprintf("v%d", i)
+			ids[i], errs[i] = store.CreatePendingMedia(context.Background(), types.MediaConfigs[types.VideoTypeTrick], media)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range ids {
+		if errs[i] != nil {
+			t.Fatalf("upload %d failed: %v", i, errs[i])
+		}
+		if ids[i] != ids[0] {
+			t.Fatalf("uploads linked to different parents: %v", ids)
+		}
+	}
+	if len(links.links) != 1 {
+		t.Fatalf("expected one UserToTricks link, got %v", links.links)
+	}
+}
+
+func TestSupabaseStoreListMediaPages(t *testing.T) {
+	var second string
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("or") == "" {
+				// First page: limit+1 rows tell there is a next page, counted in the same request
+				if query.Get("limit") != "3" || r.Header.Get("Prefer") != "count=exact" || query.Get("order") != "created_at.desc,id.desc" {
+					t.Errorf("unexpected first page request %s (Prefer %s)", r.URL.RawQuery, r.Header.Get("Prefer"))
+				}
+				w.Header().Set("Content-Range", "0-2/7")
+				fmt.Fprint(w, `[{"id":"v3","created_at":"2026-01-03T00:00:00+00:00","user_trick_id":"ut-1","tricklog_id":"log-1"},
+					{"id":"v2","created_at":"2026-01-02T00:00:00+00:00","user_trick_id":"ut-1"},
+					{"id":"v1","created_at":"2026-01-01T00:00:00+00:00","user_trick_id":"ut-1"}]`)
+				return
+			}
+			second = query.Get("or")
+			fmt.Fprint(w, `[{"id":"v1","created_at":"2026-01-01T00:00:00+00:00","user_trick_id":"ut-1"}]`)
+		},
+	})
+
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	filter := MediaFilter{ParentRecordIDs: []string{"ut-1"}}
+	page, err := store.ListMedia(context.Background(), cfg, filter, Page{Limit: 2, Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Media) != 2 || page.Next == nil || page.Total == nil || *page.Total != 7 {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	if page.Media[0].LogID == nil || *page.Media[0].LogID != "log-1" || page.Media[1].LogID != nil {
+		t.Fatalf("expected the log link to be decoded, got %+v", page.Media)
+	}
+
+	cursor, err := DecodeCursor(page.Next.Encode(), SortNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = store.ListMedia(context.Background(), cfg, filter, Page{Limit: 2, After: cursor})
+	if err != nil || len(page.Media) != 1 || page.Next != nil {
+		t.Fatalf("unexpected last page %+v, %v", page, err)
+	}
+	if want := `(created_at.lt."2026-01-02T00:00:00+00:00",and(created_at.eq."2026-01-02T00:00:00+00:00",id.lt."v2"))`; second != want {
+		t.Fatalf("unexpected keyset filter %s", second)
+	}
+	if _, err := DecodeCursor(page.Media[0].ID, SortNewest); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+	if _, err := DecodeCursor(cursor.Encode(), SortOldest); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatal("a cursor must not be accepted for another sort")
+	}
+}
+
+func TestSupabaseStoreListsTrickMediaInOneRequest(t *testing.T) {
+	store, fake := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if got := query.Get("select"); got != "*,user_trick_id!inner(id,userID,trickID,Tricks(name)),tricklog_id(id,session_id,is_public,landed,logged_at)" {
+				t.Errorf("unexpected select %q", got)
+			}
+			if got := query.Get("user_trick_id.trickID"); got != "eq.trick-1" {
+				t.Errorf("unexpected trick filter %q", got)
+			}
+			if query.Has("user_trick_id") || query.Has("or") {
+				t.Errorf("expected no list of link ids, got %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `[{"id":"v1","created_at":"2026-01-01T00:00:00+00:00",
+				"user_trick_id":{"id":"ut-9","userID":"bob","trickID":"trick-1","Tricks":{"name":"Kickflip"}},
+				"tricklog_id":{"id":"log-1","is_public":true,"logged_at":"2026-01-01T00:00:00+00:00"}}]`)
+		},
+	})
+
+	page, err := store.ListMedia(context.Background(), types.MediaConfigs[types.VideoTypeTrick], MediaFilter{ParentID: "trick-1"}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Media) != 1 || page.Media[0].ParentRecordID != "ut-9" || page.Media[0].Parent.UserID != "bob" || page.Media[0].Parent.Name != "Kickflip" {
+		t.Fatalf("unexpected media %+v", page.Media)
+	}
+	if log := page.Media[0].Log; log == nil || log.ID != "log-1" || *page.Media[0].LogID != "log-1" {
+		t.Fatalf("expected the embedded log, got %+v", log)
+	}
+	if len(fake.requests) != 1 {
+		t.Fatalf("expected one request, got %v", fake.requests)
+	}
+}
+
+func TestSupabaseStoreComboParentColumns(t *testing.T) {
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET ComboMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if got := query.Get("select"); got != "*,user_combo_id!inner(id,user_id,name),combolog_id(id,session_id,is_public,landed,logged_at)" {
+				t.Errorf("unexpected select %q", got)
+			}
+			if query.Get("user_combo_id.id") != "eq.combo-1" || query.Get("user_combo_id.user_id") != "eq.alice" {
+				t.Errorf("unexpected filters %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `[]`)
+		},
+	})
+
+	filter := MediaFilter{ParentID: "combo-1", UserID: "alice"}
+	if _, err := store.ListMedia(context.Background(), types.MediaConfigs[types.VideoTypeCombo], filter, Page{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupabaseStoreListsSessionMediaThroughLogs(t *testing.T) {
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET ComboMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if got := query.Get("select"); got != "*,user_combo_id(id,user_id,name),combolog_id!inner(id,session_id,is_public,landed,logged_at)" {
+				t.Errorf("unexpected select %q", got)
+			}
+			if got := query.Get("combolog_id.session_id"); got != "eq.session-1" {
+				t.Errorf("unexpected session filter %q", got)
+			}
+			fmt.Fprint(w, `[]`)
+		},
+	})
+
+	if _, err := store.ListMedia(context.Background(), types.MediaConfigs[types.VideoTypeCombo], MediaFilter{SessionID: "session-1"}, Page{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSupabaseStoreListStalePendingSkipsResumableRowsInQuery(t *testing.T) {
+	before := time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)
+	resumableBefore := time.Date(2025, 12, 31, 11, 0, 0, 0, time.UTC)
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if got := query.Get("upload_status"); got != "eq.pending" {
+				t.Errorf("unexpected status filter %q", got)
+			}
+			want := `(and(metadata->multipart->>uploadId.is.null,metadata->tus.is.null,created_at.lt."2026-01-01T11:00:00Z"),created_at.lt."2025-12-31T11:00:00Z")`
+			if got := query.Get("or"); got != want {
+				t.Errorf("unexpected expiry filter %q", got)
+			}
+			if got := query.Get("limit"); got != "200" {
+				t.Errorf("unexpected limit %q", got)
+			}
+			fmt.Fprint(w, `[{"id":"v1","user_trick_id":{"id":"ut-1","userID":"alice","trickID":"trick-1"},"upload_status":"pending"}]`)
+		},
+	})
+
+	media, err := store.ListStalePending(context.Background(), types.MediaConfigs[types.VideoTypeTrick], before, resumableBefore, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media) != 1 || media[0].ID != "v1" || media[0].Parent == nil || media[0].Parent.UserID != "alice" {
+		t.Fatalf("unexpected rows %+v", media)
+	}
+}
+
+func TestSupabaseStoreUpdateMediaIfFilters(t *testing.T) {
+	start := int64(1000)
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"PATCH TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			for column, want := range map[string]string{
+				"id":                      "eq.v1",
+				"upload_status":           "eq.processing",
+				"thumbnail_url":           "is.null",
+				"trim_start_ms":           "eq.1000",
+				"trim_end_ms":             "is.null",
+				"updated_at":              "",
+				"metadata->>processed_at": "",
+			} {
+				if got := query.Get(column); got != want {
+					t.Errorf("unexpected %s filter %q, expected %q", column, got, want)
+				}
+			}
+			fmt.Fprint(w, `[]`)
+		},
+	})
+
+	cond := MediaCondition{UploadStatus: "processing", NoThumbnail: true, MatchTrim: true, TrimStartMs: &start}
+	updated, err := store.UpdateMediaIf(context.Background(), types.MediaConfigs[types.VideoTypeTrick], "v1", cond, map[string]interface{}{"url": "x"})
+	if err != nil || updated {
+		t.Fatalf("expected no row to match, got %v, %v", updated, err)
+	}
+}
+
+func TestSupabaseStoreListMediaByStatus(t *testing.T) {
+	store, _ := newFakeStore(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET TrickMedia": func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			for column, want := range map[string]string{
+				"upload_status":          "eq.failed",
+				"updated_at":             "lt.2026-01-01T00:00:00Z",
+				"metadata->>failed_step": "is.null",
+				"order":                  "updated_at.asc,id.asc",
+				"limit":                  "50",
+			} {
+				if got := query.Get(column); got != want {
+					t.Errorf("unexpected %s %q, expected %q", column, got, want)
+				}
+			}
+			fmt.Fprint(w, `[{"id":"v1","user_trick_id":{"id":"ut-1","userID":"alice","trickID":"trick-1"},"upload_status":"failed"}]`)
+		},
+	})
+
+	filter := StatusFilter{UploadStatus: "failed", UpdatedBefore: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), NoFailedStep: true}
+	media, err := store.ListMediaByStatus(context.Background(), types.MediaConfigs[types.VideoTypeTrick], filter, 50)
+	if err != nil || len(media) != 1 || media[0].Parent == nil || media[0].Parent.UserID != "alice" {
+		t.Fatalf("unexpected rows %+v, %v", media, err)
+	}
+}
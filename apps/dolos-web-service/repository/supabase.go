@@ -0,0 +1,432 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/supabase"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// SupabaseStore goes through PostgREST, which can't span requests with a transaction.
+// A parent record created for an upload is deleted again if the media insert fails.
+type SupabaseStore struct {
+	Client *supabase.Client
+}
+
+func NewSupabaseStore(client *supabase.Client) *SupabaseStore {
+	return &SupabaseStore{Client: client}
+}
+
+func (s *SupabaseStore) CreatePendingMedia(ctx context.Context, cfg types.MediaConfig, media PendingMedia) (string, error) {
+	parentRecordID, err := s.findParent(ctx, cfg, media)
+	if err != nil {
+		return "", err
+	}
+
+	created := false
+	switch {
+	case parentRecordID != "":
+	case cfg.AutoCreateUserLink:
+		// Concurrent uploads can all miss the select. The unique (user, parent) constraint
+		// lets one of them insert the link, the others get nothing back and look it up.
+		respData, err := s.Client.InsertIfAbsent(ctx, cfg.ParentTable, supabase.NewQuery().OnConflict(cfg.UserIDCol, cfg.ParentIDCol), userLink(cfg, media))
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s record: %w", cfg.ParentTable, err)
+		}
+		var linkRows []map[string]interface{}
+		if err := json.Unmarshal(respData, &linkRows); err != nil {
+			return "", fmt.Errorf("failed to parse created %s record: %w", cfg.ParentTable, err)
+		}
+		if len(linkRows) > 0 {
+			parentRecordID, _ = linkRows[0]["id"].(string)
+			created = true
+		} else if parentRecordID, err = s.findParent(ctx, cfg, media); err != nil {
+			return "", err
+		} else if parentRecordID == "" {
+			return "", fmt.Errorf("%s %s for user %s was neither created nor found", cfg.ParentTable, media.ParentID, media.UserID)
+		}
+	default:
+		return "", fmt.Errorf("%s %s for user %s: %w", cfg.ParentTable, media.ParentID, media.UserID, ErrParentNotFound)
+	}
+
+	if _, err := s.Client.Insert(ctx, cfg.Table, mediaRow(cfg, media, parentRecordID)); err != nil {
+		if created {
+			// Compensate for the missing transaction, only for a link this upload inserted.
+			// The request may have been cancelled, the cleanup shouldn't be. A concurrent
+			// upload that linked to the same row in the meantime keeps it, its media row's
+			// foreign key blocks the delete.
+			if _, delErr := s.Client.Delete(context.WithoutCancel(ctx), cfg.ParentTable, supabase.NewQuery().Eq("id", parentRecordID)); delErr != nil {
+				log.Printf("Failed to remove %s %s after a failed upload insert: %v", cfg.ParentTable, parentRecordID, delErr)
+			}
+		}
+		return "", fmt.Errorf("failed to insert %s: %w", cfg.Table, err)
+	}
+	return parentRecordID, nil
+}
+
+// findParent returns the id of the upload's parent record, "" if there's none yet. For
+// combos ParentIDCol is id, so this matches the UserCombos row itself.
+func (s *SupabaseStore) findParent(ctx context.Context, cfg types.MediaConfig, media PendingMedia) (string, error) {
+	respData, err := s.Client.Select(ctx, cfg.ParentTable, supabase.NewQuery().Select("id").
+		Eq(cfg.UserIDCol, media.UserID).Eq(cfg.ParentIDCol, media.ParentID))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", cfg.ParentTable, err)
+	}
+	var parents []map[string]interface{}
+	if err := json.Unmarshal(respData, &parents); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", cfg.ParentTable, err)
+	}
+	if len(parents) == 0 {
+		return "", nil
+	}
+	id, _ := parents[0]["id"].(string)
+	return id, nil
+}
+
+func (s *SupabaseStore) GetMedia(ctx context.Context, cfg types.MediaConfig, id string) (*Media, error) {
+	rows, err := s.selectRows(ctx, cfg.Table, supabase.NewQuery().Select("*", supabase.Embed(cfg.ForeignKey)).Eq("id", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", cfg.Table, id, ErrNotFound)
+	}
+	media, err := decodeMedia(cfg, rows[0])
+	if err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+func (s *SupabaseStore) ListMedia(ctx context.Context, cfg types.MediaConfig, filter MediaFilter, page Page) (MediaPage, error) {
+	if filter.ParentRecordIDs != nil && len(filter.ParentRecordIDs) == 0 {
+		result := MediaPage{Media: []Media{}}
+		if page.Count {
+			result.Total = new(int)
+		}
+		return result, nil
+	}
+	if page.Sort == "" {
+		page.Sort = SortNewest
+	}
+
+	query := mediaQuery(cfg, filter, "*")
+	switch page.Sort {
+	case SortOldest:
+		query.Order("created_at", true).Order("id", true)
+	case SortDuration:
+		query.OrderNullsLast("duration_seconds", false).Order("id", false)
+	default:
+		query.Order("created_at", false).Order("id", false)
+	}
+	if page.After != nil {
+		after(query, *page.After)
+	}
+	if page.Limit > 0 {
+		// One extra row tells whether there is a next page
+		query.Limit(page.Limit + 1)
+	}
+
+	var result MediaPage
+	var respData []byte
+	var err error
+	if page.Count && page.After == nil {
+		var total int
+		respData, total, err = s.Client.SelectCount(ctx, cfg.Table, query)
+		result.Total = &total
+	} else {
+		respData, err = s.Client.Select(ctx, cfg.Table, query)
+	}
+	if err != nil {
+		return result, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(respData, &rows); err != nil {
+		return result, fmt.Errorf("failed to parse %s: %w", cfg.Table, err)
+	}
+
+	result.Media = make([]Media, 0, len(rows))
+	for _, row := range rows {
+		decoded, err := decodeMedia(cfg, row)
+		if err != nil {
+			return result, err
+		}
+		result.Media = append(result.Media, decoded)
+	}
+	if page.Limit > 0 && len(result.Media) > page.Limit {
+		result.Media = result.Media[:page.Limit]
+		result.Next = cursorAt(page.Sort, result.Media[page.Limit-1])
+	}
+
+	// Later pages are counted separately, the keyset filter would exclude earlier rows
+	if page.Count && page.After != nil {
+		_, total, err := s.Client.SelectCount(ctx, cfg.Table, mediaQuery(cfg, filter, "id").Limit(0))
+		if err != nil {
+			return result, err
+		}
+		result.Total = &total
+	}
+	return result, nil
+}
+
+// logColumns are the columns of a log embedded in a media row
+var logColumns = []string{"id", "session_id", "is_public", "landed", "logged_at"}
+
+// mediaQuery selects columns, the parent row and the linked log with the filter's conditions. Parent
+// conditions filter through an inner join on the embedded parent, so listing a trick's
+// videos is one request however many users linked it. A session filters the same way
+// through the embedded log.
+func mediaQuery(cfg types.MediaConfig, filter MediaFilter, columns ...string) *supabase.Query {
+	parent := cfg.ForeignKey
+	if filter.ParentID != "" || filter.UserID != "" {
+		parent += "!inner"
+	}
+	parentColumns := []string{"id", cfg.UserIDCol}
+	if cfg.ParentIDCol != "id" {
+		parentColumns = append(parentColumns, cfg.ParentIDCol)
+	}
+	if cfg.NameTable != "" {
+		parentColumns = append(parentColumns, supabase.Embed(cfg.NameTable, "name"))
+	} else {
+		parentColumns = append(parentColumns, "name")
+	}
+	logEmbed := cfg.LogKey
+	if filter.SessionID != "" {
+		logEmbed += "!inner"
+	}
+	columns = append(columns, supabase.Embed(parent, parentColumns...), supabase.Embed(logEmbed, logColumns...))
+	query := supabase.NewQuery().Select(columns...)
+	if filter.ParentID != "" {
+		query.Eq(cfg.ForeignKey+"."+cfg.ParentIDCol, filter.ParentID)
+	}
+	if filter.UserID != "" {
+		query.Eq(cfg.ForeignKey+"."+cfg.UserIDCol, filter.UserID)
+	}
+	if filter.ParentRecordIDs != nil {
+		query.In(cfg.ForeignKey, filter.ParentRecordIDs...)
+	}
+	if filter.UploadStatus != "" {
+		query.Eq("upload_status", filter.UploadStatus)
+	}
+	if filter.Verified {
+		query.In("upload_status", "completed", "processing")
+	}
+	if filter.MediaType != "" {
+		query.Eq("media_type", filter.MediaType)
+	}
+	if filter.PublicOnly {
+		query.Eq("public", true)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		query.Gte("created_at", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		query.Lte("created_at", filter.CreatedTo)
+	}
+	if filter.LogID != "" {
+		query.Eq(cfg.LogKey, filter.LogID)
+	}
+	if filter.SessionID != "" {
+		query.Eq(cfg.LogKey+".session_id", filter.SessionID)
+	}
+	if filter.Logged != nil {
+		if *filter.Logged {
+			query.NotNull(cfg.LogKey)
+		} else {
+			query.IsNull(cfg.LogKey)
+		}
+	}
+	return query
+}
+
+// after adds the keyset condition for the rows that sort after cursor
+func after(query *supabase.Query, cursor Cursor) {
+	switch cursor.Sort {
+	case SortOldest:
+		query.Or(supabase.Cond("created_at", "gt", cursor.CreatedAt),
+			supabase.And(supabase.Cond("created_at", "eq", cursor.CreatedAt), supabase.Cond("id", "gt", cursor.ID)))
+	case SortDuration:
+		if cursor.Duration == nil {
+			query.IsNull("duration_seconds").Lt("id", cursor.ID)
+			return
+		}
+		query.Or(supabase.Cond("duration_seconds", "lt", *cursor.Duration),
+			supabase.And(supabase.Cond("duration_seconds", "eq", *cursor.Duration), supabase.Cond("id", "lt", cursor.ID)),
+			supabase.IsNullCond("duration_seconds"))
+	default:
+		query.Or(supabase.Cond("created_at", "lt", cursor.CreatedAt),
+			supabase.And(supabase.Cond("created_at", "eq", cursor.CreatedAt), supabase.Cond("id", "lt", cursor.ID)))
+	}
+}
+
+func (s *SupabaseStore) UpdateMedia(ctx context.Context, cfg types.MediaConfig, id string, ifStatus string, set map[string]interface{}) (bool, error) {
+	return s.UpdateMediaIf(ctx, cfg, id, MediaCondition{UploadStatus: ifStatus}, set)
+}
+
+func (s *SupabaseStore) UpdateMediaIf(ctx context.Context, cfg types.MediaConfig, id string, cond MediaCondition, set map[string]interface{}) (bool, error) {
+	query := supabase.NewQuery().Eq("id", id)
+	if cond.UploadStatus != "" {
+		query.Eq("upload_status", cond.UploadStatus)
+	}
+	if cond.UpdatedAt != "" {
+		query.Eq("updated_at", cond.UpdatedAt)
+	}
+	if cond.Unprocessed {
+		query.IsNull("metadata->>processed_at")
+	}
+	if cond.NoThumbnail {
+		query.IsNull("thumbnail_url")
+	}
+	if cond.MatchTrim {
+		query.EqOrNull("trim_start_ms", cond.TrimStartMs).EqOrNull("trim_end_ms", cond.TrimEndMs)
+	}
+	respData, err := s.Client.Update(ctx, cfg.Table, query, set)
+	if err != nil {
+		return false, err
+	}
+	var updated []map[string]interface{}
+	if err := json.Unmarshal(respData, &updated); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", cfg.Table, err)
+	}
+	return len(updated) > 0, nil
+}
+
+func (s *SupabaseStore) DeleteMedia(ctx context.Context, cfg types.MediaConfig, id string, ifStatus string) (bool, error) {
+	query := supabase.NewQuery().Eq("id", id)
+	if ifStatus != "" {
+		query.Eq("upload_status", ifStatus)
+	}
+	respData, err := s.Client.Delete(ctx, cfg.Table, query)
+	if err != nil {
+		return false, err
+	}
+	var deleted []map[string]interface{}
+	if err := json.Unmarshal(respData, &deleted); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", cfg.Table, err)
+	}
+	return len(deleted) > 0, nil
+}
+
+func (s *SupabaseStore) ListStalePending(ctx context.Context, cfg types.MediaConfig, before, resumableBefore time.Time, limit int) ([]Media, error) {
+	singlePut := supabase.And(
+		supabase.IsNullCond("metadata->multipart->>uploadId"),
+		supabase.IsNullCond("metadata->tus"),
+		supabase.Cond("created_at", "lt", before),
+	)
+	return s.listMedia(ctx, cfg, supabase.NewQuery().Select("*", supabase.Embed(cfg.ForeignKey)).
+		Eq("upload_status", "pending").Or(singlePut, supabase.Cond("created_at", "lt", resumableBefore)).
+		Order("created_at", true).Limit(limit))
+}
+
+func (s *SupabaseStore) ListMediaByStatus(ctx context.Context, cfg types.MediaConfig, filter StatusFilter, limit int) ([]Media, error) {
+	query := supabase.NewQuery().Select("*", supabase.Embed(cfg.ForeignKey)).Eq("upload_status", filter.UploadStatus)
+	if !filter.UpdatedBefore.IsZero() {
+		query.Lt("updated_at", filter.UpdatedBefore)
+	}
+	if filter.Unprocessed {
+		query.IsNull("metadata->>processed_at")
+	}
+	if filter.NoFailedStep {
+		query.IsNull("metadata->>failed_step")
+	}
+	return s.listMedia(ctx, cfg, query.Order("updated_at", true).Order("id", true).Limit(limit))
+}
+
+// listMedia selects media rows and decodes them
+func (s *SupabaseStore) listMedia(ctx context.Context, cfg types.MediaConfig, query *supabase.Query) ([]Media, error) {
+	rows, err := s.selectRows(ctx, cfg.Table, query)
+	if err != nil {
+		return nil, err
+	}
+	media := make([]Media, 0, len(rows))
+	for _, row := range rows {
+		decoded, err := decodeMedia(cfg, row)
+		if err != nil {
+			return nil, err
+		}
+		media = append(media, decoded)
+	}
+	return media, nil
+}
+
+func (s *SupabaseStore) FindParents(ctx context.Context, cfg types.MediaConfig, parentID string, userID string) ([]Parent, error) {
+	query := supabase.NewQuery().Select("id", cfg.UserIDCol, cfg.ParentIDCol).Eq(cfg.ParentIDCol, parentID).Order("id", true)
+	if userID != "" {
+		query.Eq(cfg.UserIDCol, userID)
+	}
+	rows, err := s.selectRows(ctx, cfg.ParentTable, query)
+	if err != nil {
+		return nil, err
+	}
+	parents := make([]Parent, 0, len(rows))
+	for _, row := range rows {
+		parents = append(parents, decodeParent(cfg, row))
+	}
+	return parents, nil
+}
+
+func (s *SupabaseStore) GetLog(ctx context.Context, cfg types.MediaConfig, id string) (*Log, error) {
+	rows, err := s.selectRows(ctx, cfg.LogTable, supabase.NewQuery().Select("*").Eq("id", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", cfg.LogTable, id, ErrNotFound)
+	}
+	log, err := decodeLog(cfg, rows[0])
+	if err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
+func (s *SupabaseStore) ListLogsBySession(ctx context.Context, cfg types.MediaConfig, sessionID string) ([]Log, error) {
+	rows, err := s.selectRows(ctx, cfg.LogTable, supabase.NewQuery().Select("*").Eq("session_id", sessionID).Order("logged_at", true).Order("id", true))
+	if err != nil {
+		return nil, err
+	}
+	logs := make([]Log, 0, len(rows))
+	for _, row := range rows {
+		decoded, err := decodeLog(cfg, row)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, decoded)
+	}
+	return logs, nil
+}
+
+func (s *SupabaseStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	rows, err := s.selectRows(ctx, "Sessions", supabase.NewQuery().Select("id", "user_id", "started_at", "ended_at", "is_public").Eq("id", id))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Sessions %s: %w", id, ErrNotFound)
+	}
+	var session Session
+	encoded, err := json.Marshal(rows[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(encoded, &session); err != nil {
+		return nil, fmt.Errorf("invalid Sessions row: %w", err)
+	}
+	return &session, nil
+}
+
+func (s *SupabaseStore) selectRows(ctx context.Context, table string, query *supabase.Query) ([]map[string]interface{}, error) {
+	respData, err := s.Client.Select(ctx, table, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", table, err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(respData, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", table, err)
+	}
+	return rows, nil
+}
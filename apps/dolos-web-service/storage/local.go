@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// LocalRoutePrefix is where the service serves locally stored objects
+	LocalRoutePrefix = "/storage"
+
+	// objectSuffix keeps object files apart from "directories" that share a key prefix,
+	// e.g. .../{videoId} and .../{videoId}/thumbnail.jpg
+	objectSuffix = ".obj"
+	metaSuffix   = ".meta"
+
+	maxLocalUploadBytes = 512 * 1024 * 1024
+)
+
+type localMeta struct {
+	ContentType string `json:"contentType"`
+}
+
+// LocalStore keeps objects on the local filesystem and issues HMAC-signed
+// upload/download URLs that are served by the service itself
+type LocalStore struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+func NewLocalStore(root, baseURL string, secret []byte) (*LocalStore, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("local storage requires a signing secret")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage dir: %w", err)
+	}
+	return &LocalStore{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+	}, nil
+}
+
+func (l *LocalStore) PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (string, error) {
+	return l.signedURL(http.MethodPut, key, contentType, expires)
+}
+
+func (l *LocalStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return l.signedURL(http.MethodGet, key, "", expires)
+}
+
+func (l *LocalStore) signedURL(method, key, contentType string, expires time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expiresAt)
+	query.Set("signature", l.sign(method, key, expiresAt, contentType))
+	return fmt.Sprintf("%s?%s", l.PublicURL(key), query.Encode()), nil
+}
+
+func (l *LocalStore) sign(method, key, expiresAt, contentType string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, key, expiresAt, contentType)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *LocalStore) verify(method, key, expiresAt, contentType, signature string) bool {
+	unix, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	expected := l.sign(method, key, expiresAt, contentType)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (l *LocalStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temp file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("%s: wrote %d bytes, expected %d", key, written, size)
+	}
+
+	meta, err := json.Marshal(localMeta{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+metaSuffix, meta, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := l.Head(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, _ := l.path(key)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func (l *LocalStore) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}
+		return nil, err
+	}
+
+	var meta localMeta
+	if raw, err := os.ReadFile(path + metaSuffix); err == nil {
+		_ = json.Unmarshal(raw, &meta)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  meta.ContentType,
+		ETag:         fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (l *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(path + metaSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *LocalStore) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	objects, err := l.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, obj := range objects {
+		if err := l.Delete(ctx, obj.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (l *LocalStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(l.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, objectSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.root, strings.TrimSuffix(path, objectSuffix))
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := l.Head(ctx, key)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (l *LocalStore) PublicURL(key string) string {
+	return fmt.Sprintf("%s%s/%s", l.baseURL, LocalRoutePrefix, key)
+}
+
+func (l *LocalStore) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)) + objectSuffix, nil
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
+
+// RegisterRoutes serves signed uploads and downloads for the local store.
+// Unsigned GETs are allowed, like a public R2 bucket.
+func (l *LocalStore) RegisterRoutes(r gin.IRoutes) {
+	r.GET(LocalRoutePrefix+"/*key", l.serveGet)
+	r.HEAD(LocalRoutePrefix+"/*key", l.serveGet)
+	r.PUT(LocalRoutePrefix+"/*key", l.servePut)
+}
+
+func (l *LocalStore) serveGet(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if signature := c.Query("signature"); signature != "" {
+		if !l.verify(http.MethodGet, key, c.Query("expires"), "", signature) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+			return
+		}
+	}
+
+	body, info, err := l.Get(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Object not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.Close()
+
+	c.Header("ETag", info.ETag)
+	if info.ContentType != "" {
+		c.Header("Content-Type", info.ContentType)
+	}
+	http.ServeContent(c.Writer, c.Request, "", info.LastModified, body.(io.ReadSeeker))
+}
+
+func (l *LocalStore) servePut(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if c.Query("uploadId") != "" {
+		l.servePartPut(c, key)
+		return
+	}
+
+	contentType := c.GetHeader("Content-Type")
+	if !l.verify(http.MethodPut, key, c.Query("expires"), contentType, c.Query("signature")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxLocalUploadBytes)
+	if err := l.Put(c.Request.Context(), key, body, c.Request.ContentLength, contentType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to store object", "details": err.Error()})
+		return
+	}
+
+	info, err := l.Head(c.Request.Context(), key)
+	if err == nil {
+		c.Header("ETag", info.ETag)
+	}
+	c.Status(http.StatusOK)
+}
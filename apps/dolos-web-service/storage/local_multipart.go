@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartDir holds in-progress local multipart uploads, outside the object namespace
+const multipartDir = ".multipart"
+
+type localUpload struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"contentType"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+func (l *LocalStore) uploadDir(uploadID string) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\.`) {
+		return "", fmt.Errorf("invalid upload ID %q", uploadID)
+	}
+	return filepath.Join(l.root, multipartDir, uploadID), nil
+}
+
+func (l *LocalStore) readUpload(uploadID string) (*localUpload, string, error) {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return nil, "", err
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "info.json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, "", fmt.Errorf("upload %s: %w", uploadID, ErrNotFound)
+		}
+		return nil, "", err
+	}
+	var upload localUpload
+	if err := json.Unmarshal(raw, &upload); err != nil {
+		return nil, "", err
+	}
+	return &upload, dir, nil
+}
+
+func (l *LocalStore) CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir, _ := l.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	info, err := json.Marshal(localUpload{Key: key, ContentType: contentType, Initiated: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "info.json"), info, 0o644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (l *LocalStore) PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	part := strconv.Itoa(int(partNumber))
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	query.Set("partNumber", part)
+	query.Set("expires", expiresAt)
+	query.Set("signature", l.sign(http.MethodPut, partResource(key, uploadID, part), expiresAt, ""))
+	return fmt.Sprintf("%s?%s", l.PublicURL(key), query.Encode()), nil
+}
+
+func partResource(key, uploadID, partNumber string) string {
+	return fmt.Sprintf("%s#%s#%s", key, uploadID, partNumber)
+}
+
+func (l *LocalStore) uploadPart(uploadID string, partNumber int, body io.Reader) (string, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return "", fmt.Errorf("invalid part number %d", partNumber)
+	}
+	_, dir, err := l.readUpload(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+func (l *LocalStore) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	upload, dir, err := l.readUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	if upload.Key != key {
+		return fmt.Errorf("upload %s is for a different key", uploadID)
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var size int64
+	for _, part := range parts {
+		f, err := os.Open(filepath.Join(dir, fmt.Sprintf("part-%05d", part.PartNumber)))
+		if err != nil {
+			return fmt.Errorf("upload %s: part %d missing", uploadID, part.PartNumber)
+		}
+		files = append(files, f)
+
+		hash := md5.New()
+		n, err := io.Copy(hash, f)
+		if err != nil {
+			return err
+		}
+		if `"`+hex.EncodeToString(hash.Sum(nil))+`"` != part.ETag {
+			return fmt.Errorf("upload %s: part %d ETag mismatch", uploadID, part.PartNumber)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		size += n
+		readers = append(readers, f)
+	}
+
+	if err := l.Put(ctx, key, io.MultiReader(readers...), size, upload.ContentType); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *LocalStore) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *LocalStore) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(l.root, multipartDir))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var uploads []MultipartUpload
+	for _, entry := range entries {
+		upload, _, err := l.readUpload(entry.Name())
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(upload.Key, prefix) {
+			uploads = append(uploads, MultipartUpload{Key: upload.Key, UploadID: entry.Name(), Initiated: upload.Initiated})
+		}
+	}
+	return uploads, nil
+}
+
+func (l *LocalStore) servePartPut(c *gin.Context, key string) {
+	uploadID := c.Query("uploadId")
+	part := c.Query("partNumber")
+	if !l.verify(http.MethodPut, partResource(key, uploadID, part), c.Query("expires"), "", c.Query("signature")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired signature"})
+		return
+	}
+
+	partNumber, err := strconv.Atoi(part)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid part number"})
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxLocalUploadBytes)
+	etag, err := l.uploadPart(uploadID, partNumber, body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to store part", "details": err.Error()})
+		return
+	}
+	c.Header("ETag", etag)
+	c.Status(http.StatusOK)
+}
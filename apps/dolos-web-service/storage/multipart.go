@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMultipartUnsupported is returned when the configured backend can't do multipart uploads
+var ErrMultipartUnsupported = errors.New("multipart uploads not supported by storage backend")
+
+// CompletedPart identifies an uploaded part when completing a multipart upload
+type CompletedPart struct {
+	PartNumber int32
+	ETag       string
+}
+
+// MultipartUpload is an in-progress multipart upload
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// MultipartStore is implemented by backends that support S3-style multipart uploads
+type MultipartStore interface {
+	CreateMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	// PresignUploadPart returns a URL the client can PUT a single part to
+	PresignUploadPart(ctx context.Context, key, uploadID string, partNumber int32, expires time.Duration) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	// ListMultipartUploads returns the in-progress uploads under prefix
+	ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+}
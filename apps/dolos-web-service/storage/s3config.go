@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Config configures any S3-compatible store (Cloudflare R2, MinIO, SeaweedFS, AWS)
+type S3Config struct {
+	Endpoint           string // e.g. https://<account>.r2.cloudflarestorage.com or http://localhost:9000
+	Region             string // "auto" for R2, anything the server accepts otherwise
+	Bucket             string
+	AccessKeyID        string
+	SecretAccessKey    string
+	UsePathStyle       bool   // address objects as {endpoint}/{bucket}/{key}, needed by MinIO and SeaweedFS
+	InsecureSkipVerify bool   // skip TLS verification for self-signed staging endpoints
+	PublicBaseURL      string // base URL for public media URLs, derived from path-style endpoints when empty
+}
+
+// DefaultPublicBaseURL returns the bucket's URL on a path-style endpoint, for stores
+// like MinIO that serve a public bucket without a separate CDN domain. It's empty for
+// virtual-host endpoints: R2's API host isn't publicly readable.
+func (c S3Config) DefaultPublicBaseURL() string {
+	if !c.UsePathStyle {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(c.Endpoint, "/"), c.Bucket)
+}
+
+// NewS3StoreFromConfig builds an S3Store for the configured endpoint
+func NewS3StoreFromConfig(ctx context.Context, c S3Config) (*S3Store, error) {
+	if c.Endpoint == "" || c.Bucket == "" || c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, errors.New("S3 endpoint, bucket and credentials are required")
+	}
+	if c.Region == "" {
+		c.Region = "auto"
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, "")),
+		config.WithRegion(c.Region),
+	}
+	if c.InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		opts = append(opts, config.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load S3 config: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(c.Endpoint)
+		o.UsePathStyle = c.UsePathStyle
+	})
+
+	publicBaseURL := c.PublicBaseURL
+	if publicBaseURL == "" {
+		publicBaseURL = c.DefaultPublicBaseURL()
+	}
+	if publicBaseURL == "" {
+		return nil, errors.New("a public base URL is required unless the endpoint is path-style")
+	}
+	return NewS3Store(client, c.Bucket, publicBaseURL), nil
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// ObjectStore is the storage backend for media objects
+type ObjectStore interface {
+	// PresignPut returns a URL the client can PUT the object to directly
+	PresignPut(ctx context.Context, key, contentType string, expires time.Duration) (string, error)
+	// PresignGet returns a time-limited download URL
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get opens the object for reading, the caller must close it
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Head returns the object's info or ErrNotFound
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete removes an object, deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	// DeletePrefix removes every object under prefix and returns how many were deleted
+	DeletePrefix(ctx context.Context, prefix string) (int, error)
+	// List returns every object under prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PublicURL returns the URL clients use to read the object
+	PublicURL(key string) string
+}
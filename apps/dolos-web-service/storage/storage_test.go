@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:8080", []byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestObjectStores(t *testing.T) {
+	stores := map[string]ObjectStore{
+		"memory": NewMemoryStore(),
+		"local":  newTestLocalStore(t),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			video := "tricks/t1/videos/u1/v1"
+			thumb := video + "/thumbnail.jpg"
+
+			if _, err := store.Head(ctx, video); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound before upload, got %v", err)
+			}
+
+			if err := store.Put(ctx, video, strings.NewReader("video-bytes"), 11, "video/mp4"); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(ctx, thumb, strings.NewReader("jpg"), 3, "image/jpeg"); err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := store.Head(ctx, video)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size != 11 || info.ContentType != "video/mp4" {
+				t.Fatalf("unexpected info %+v", info)
+			}
+
+			body, _, err := store.Get(ctx, thumb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(body)
+			body.Close()
+			if string(data) != "jpg" {
+				t.Fatalf("unexpected thumbnail body %q", data)
+			}
+
+			objects, err := store.List(ctx, "tricks/t1/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 2 || objects[0].Key != video || objects[1].Key != thumb {
+				t.Fatalf("unexpected listing %+v", objects)
+			}
+
+			deleted, err := store.DeletePrefix(ctx, video+"/")
+			if err != nil || deleted != 1 {
+				t.Fatalf("expected 1 deleted, got %d (%v)", deleted, err)
+			}
+			if err := store.Delete(ctx, video); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(ctx, video); err != nil {
+				t.Fatalf("deleting a missing object should succeed, got %v", err)
+			}
+			if objects, _ := store.List(ctx, ""); len(objects) != 0 {
+				t.Fatalf("expected empty store, got %+v", objects)
+			}
+		})
+	}
+}
+
+func TestLocalStoreSignedURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newTestLocalStore(t)
+	r := gin.New()
+	store.RegisterRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	store.baseURL = server.URL
+
+	ctx := context.Background()
+	key := "combos/c1/videos/u1/v1"
+
+	uploadURL, err := store.PresignPut(ctx, key, "video/mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(url, contentType string) int {
+		req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader([]byte("clip")))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := put(uploadURL, "video/quicktime"); status != http.StatusForbidden {
+		t.Fatalf("expected content type mismatch to be rejected, got %d", status)
+	}
+	if status := put(store.PublicURL(key), "video/mp4"); status != http.StatusForbidden {
+		t.Fatalf("expected unsigned upload to be rejected, got %d", status)
+	}
+	if status := put(uploadURL, "video/mp4"); status != http.StatusOK {
+		t.Fatalf("expected signed upload to succeed, got %d", status)
+	}
+
+	downloadURL, err := store.PresignGet(ctx, key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "clip" {
+		t.Fatalf("unexpected download %d %q", resp.StatusCode, body)
+	}
+
+	expired, _ := store.PresignGet(ctx, key, -time.Minute)
+	if resp, _ := http.Get(expired); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected expired signature to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestLocalStoreMultipart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := newTestLocalStore(t)
+	r := gin.New()
+	store.RegisterRoutes(r)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	store.baseURL = server.URL
+
+	ctx := context.Background()
+	key := "tricks/t1/videos/u1/v1"
+
+	uploadID, err := store.CreateMultipartUpload(ctx, key, "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploads, _ := store.ListMultipartUploads(ctx, "tricks/"); len(uploads) != 1 || uploads[0].UploadID != uploadID {
+		t.Fatalf("unexpected uploads %+v", uploads)
+	}
+
+	var parts []CompletedPart
+	for i, chunk := range []string{"first-", "second"} {
+		partNumber := int32(i + 1)
+		partURL, err := store.PresignUploadPart(ctx, key, uploadID, partNumber, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest(http.MethodPut, partURL, strings.NewReader(chunk))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == "" {
+			t.Fatalf("part %d upload failed: %d", partNumber, resp.StatusCode)
+		}
+		parts = append(parts, CompletedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+	}
+
+	bad := []CompletedPart{{PartNumber: 1, ETag: `"wrong"`}}
+	if err := store.CompleteMultipartUpload(ctx, key, uploadID, bad); err == nil {
+		t.Fatal("expected ETag mismatch to be rejected")
+	}
+	if err := store.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		t.Fatal(err)
+	}
+
+	body, info, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "first-second" || info.ContentType != "video/mp4" {
+		t.Fatalf("unexpected assembled object %q %+v", data, info)
+	}
+	if uploads, _ := store.ListMultipartUploads(ctx, ""); len(uploads) != 0 {
+		t.Fatalf("expected completed upload to be cleaned up, got %+v", uploads)
+	}
+
+	abandoned, _ := store.CreateMultipartUpload(ctx, key+"-2", "video/mp4")
+	if err := store.AbortMultipartUpload(ctx, key+"-2", abandoned); err != nil {
+		t.Fatal(err)
+	}
+	if uploads, _ := store.ListMultipartUploads(ctx, ""); len(uploads) != 0 {
+		t.Fatalf("expected aborted upload to be removed, got %+v", uploads)
+	}
+}
+
+func TestLocalStoreRejectsTraversal(t *testing.T) {
+	store := newTestLocalStore(t)
+	err := store.Put(context.Background(), "../escape", strings.NewReader("x"), 1, "text/plain")
+	if err == nil {
+		t.Fatal("expected traversal key to be rejected")
+	}
+}
+
+func TestS3StoreFollowsEndpointConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           S3Config
+		wantUpload    string
+		wantPublicURL string
+	}{
+		{
+			name: "r2 virtual host",
+			cfg: S3Config{
+				Endpoint:      "https://acct.r2.cloudflarestorage.com",
+				Bucket:        "videos",
+				PublicBaseURL: "https://pub-123.r2.dev",
+			},
+			wantUpload:    "https://videos.acct.r2.cloudflarestorage.com/tricks/t1/videos/u1/v1?",
+			wantPublicURL: "https://pub-123.r2.dev/tricks/t1/videos/u1/v1",
+		},
+		{
+			name: "minio path style",
+			cfg: S3Config{
+				Endpoint:     "http://localhost:9000",
+				Region:       "us-east-1",
+				Bucket:       "videos",
+				UsePathStyle: true,
+			},
+			wantUpload:    "http://localhost:9000/videos/tricks/t1/videos/u1/v1?",
+			wantPublicURL: "http://localhost:9000/videos/tricks/t1/videos/u1/v1",
+		},
+		{
+			name: "custom region virtual host",
+			cfg: S3Config{
+				Endpoint:      "https://s3.eu-central-1.example.com",
+				Region:        "eu-central-1",
+				Bucket:        "videos",
+				PublicBaseURL: "https://cdn.example.com",
+			},
+			wantUpload:    "https://videos.s3.eu-central-1.example.com/tricks/t1/videos/u1/v1?",
+			wantPublicURL: "https://cdn.example.com/tricks/t1/videos/u1/v1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.AccessKeyID = "key"
+			tt.cfg.SecretAccessKey = "secret"
+			store, err := NewS3StoreFromConfig(context.Background(), tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key := "tricks/t1/videos/u1/v1"
+			uploadURL, err := store.PresignPut(context.Background(), key, "video/mp4", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(uploadURL, tt.wantUpload) {
+				t.Fatalf("expected upload URL to start with %s, got %s", tt.wantUpload, uploadURL)
+			}
+			if tt.cfg.Region != "" && !strings.Contains(uploadURL, tt.cfg.Region) {
+				t.Fatalf("expected upload URL to be signed for %s, got %s", tt.cfg.Region, uploadURL)
+			}
+			if got := store.PublicURL(key); got != tt.wantPublicURL {
+				t.Fatalf("expected public URL %s, got %s", tt.wantPublicURL, got)
+			}
+		})
+	}
+}
+
+func TestS3StoreNeedsPublicBaseURLForVirtualHosts(t *testing.T) {
+	// R2's API host isn't publicly readable, so there's no URL to fall back to
+	_, err := NewS3StoreFromConfig(context.Background(), S3Config{
+		Endpoint:        "https://acct.r2.cloudflarestorage.com",
+		Bucket:          "videos",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	})
+	if err == nil {
+		t.Fatal("expected a virtual-host endpoint without a public base URL to fail")
+	}
+}
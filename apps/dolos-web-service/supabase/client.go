@@ -2,86 +2,220 @@ package supabase
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	preferReturn = "return=representation"
+	preferIgnore = "return=representation,resolution=ignore-duplicates"
+	preferCount  = "count=exact"
+)
+
 type Client struct {
 	BaseURL    string
 	ServiceKey string
+
+	Timeout      time.Duration // per attempt
+	Retries      int           // extra attempts for idempotent requests
+	RetryBackoff time.Duration // base delay, doubled on each attempt and jittered
+
 	httpClient *http.Client
 }
 
 func NewClient() *Client {
-	return &Client{
-		BaseURL:    os.Getenv("SUPABASE_URL"),
-		ServiceKey: os.Getenv("SUPABASE_SERVICE_KEY"),
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+	c := &Client{
+		BaseURL:      os.Getenv("SUPABASE_URL"),
+		ServiceKey:   os.Getenv("SUPABASE_SERVICE_KEY"),
+		Timeout:      30 * time.Second,
+		Retries:      3,
+		RetryBackoff: 200 * time.Millisecond,
+		httpClient:   &http.Client{},
+	}
+	if d, err := time.ParseDuration(os.Getenv("SUPABASE_TIMEOUT")); err == nil && d > 0 {
+		c.Timeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("SUPABASE_RETRIES")); err == nil && n >= 0 {
+		c.Retries = n
 	}
+	if d, err := time.ParseDuration(os.Getenv("SUPABASE_RETRY_BACKOFF")); err == nil && d > 0 {
+		c.RetryBackoff = d
+	}
+	return c
 }
 
-// Generic method to make requests to Supabase REST API
-func (c *Client) makeRequest(method, table, query string, body interface{}) ([]byte, error) {
+// Generic method to make requests to Supabase REST API. GET, DELETE and InsertIfAbsent are
+// retried on network errors and 5xx responses, inserts and updates aren't since a lost
+// response doesn't tell whether they were applied.
+func (c *Client) makeRequest(ctx context.Context, method, table, query, prefer string, body interface{}) ([]byte, http.Header, error) {
 	url := fmt.Sprintf("%s/rest/v1/%s%s", c.BaseURL, table, query)
 
-	var reqBody io.Reader
+	var jsonBody []byte
 	if body != nil {
-		jsonBody, err := json.Marshal(body)
-		if err != nil {
-			return nil, err
+		var err error
+		if jsonBody, err = json.Marshal(body); err != nil {
+			return nil, nil, err
 		}
-		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	attempts := 1
+	if method == http.MethodGet || method == http.MethodDelete || prefer == preferIgnore {
+		attempts += c.Retries
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay := c.backoff(attempt - 1)
+			log.Printf("Retrying %s %s in %s (attempt %d/%d): %v", method, table, delay, attempt, attempts, lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		respBody, header, err := c.do(ctx, method, url, prefer, jsonBody)
+		if err == nil {
+			return respBody, header, nil
+		}
+		lastErr = err
+		if !retryable(ctx, err) {
+			break
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// do makes a single attempt
+func (c *Client) do(ctx context.Context, method, url, prefer string, jsonBody []byte) ([]byte, http.Header, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var reqBody io.Reader
+	if jsonBody != nil {
+		reqBody = bytes.NewReader(jsonBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Set headers
 	req.Header.Set("apikey", c.ServiceKey)
 	req.Header.Set("Authorization", "Bearer "+c.ServiceKey)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Prefer", "return=representation")
+	req.Header.Set("Prefer", prefer)
 
-	resp, err := c.httpClient.Do(req)
+	httpClient := c.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("supabase error %d: %s", resp.StatusCode, string(respBody))
+		return nil, nil, parseError(resp.StatusCode, respBody)
+	}
+
+	return respBody, resp.Header, nil
+}
+
+// backoff returns the delay before retry n (1-based): RetryBackoff doubled per retry,
+// jittered between half and all of it so concurrent callers don't retry in lockstep
+func (c *Client) backoff(n int) time.Duration {
+	if c.RetryBackoff <= 0 {
+		return 0
 	}
+	delay := c.RetryBackoff << (n - 1)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
 
-	return respBody, nil
+// retryable reports whether a failed attempt may succeed if repeated
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500
+	}
+	// Anything else is a network error or a per-attempt timeout
+	return true
 }
 
 // Insert a record
-func (c *Client) Insert(table string, data interface{}) ([]byte, error) {
-	return c.makeRequest("POST", table, "", data)
+func (c *Client) Insert(ctx context.Context, table string, data interface{}) ([]byte, error) {
+	body, _, err := c.makeRequest(ctx, "POST", table, "", preferReturn, data)
+	return body, err
+}
+
+// InsertIfAbsent inserts a record unless it conflicts with an existing row on the query's
+// OnConflict columns. Only an inserted row is returned, so an empty result means the row
+// already existed.
+func (c *Client) InsertIfAbsent(ctx context.Context, table string, query *Query, data interface{}) ([]byte, error) {
+	body, _, err := c.makeRequest(ctx, "POST", table, query.String(), preferIgnore, data)
+	return body, err
 }
 
-// Update a record
-func (c *Client) Update(table, query string, data interface{}) ([]byte, error) {
-	return c.makeRequest("PATCH", table, query, data)
+// Update the records matching query
+func (c *Client) Update(ctx context.Context, table string, query *Query, data interface{}) ([]byte, error) {
+	body, _, err := c.makeRequest(ctx, "PATCH", table, query.String(), preferReturn, data)
+	return body, err
 }
 
 // Select records
-func (c *Client) Select(table, query string) ([]byte, error) {
-	return c.makeRequest("GET", table, query, nil)
+func (c *Client) Select(ctx context.Context, table string, query *Query) ([]byte, error) {
+	body, _, err := c.makeRequest(ctx, "GET", table, query.String(), preferReturn, nil)
+	return body, err
 }
 
-// Delete a record
-func (c *Client) Delete(table, query string) ([]byte, error) {
-	return c.makeRequest("DELETE", table, query, nil)
-}
\ No newline at end of file
+// SelectCount selects records like Select and also returns how many rows match the
+// query's filters, ignoring limit and offset (Prefer: count=exact)
+func (c *Client) SelectCount(ctx context.Context, table string, query *Query) ([]byte, int, error) {
+	body, header, err := c.makeRequest(ctx, "GET", table, query.String(), preferCount, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := parseCount(header.Get("Content-Range"))
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, count, nil
+}
+
+// parseCount reads the total from a Content-Range header such as 0-24/3573 or */0
+func parseCount(contentRange string) (int, error) {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("no count in Content-Range %q", contentRange)
+	}
+	return strconv.Atoi(total)
+}
+
+// Delete the records matching query
+func (c *Client) Delete(ctx context.Context, table string, query *Query) ([]byte, error) {
+	body, _, err := c.makeRequest(ctx, "DELETE", table, query.String(), preferReturn, nil)
+	return body, err
+}
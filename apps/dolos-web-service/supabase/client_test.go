@@ -0,0 +1,132 @@
+package supabase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &Client{
+		BaseURL:      server.URL,
+		Timeout:      time.Second,
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+		httpClient:   server.Client(),
+	}
+}
+
+func TestSelectRetriesServerErrors(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[{"id":"v1"}]`)
+	})
+
+	body, err := c.Select(context.Background(), "TrickMedia", NewQuery().Eq("id", "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `[{"id":"v1"}]` || calls != 3 {
+		t.Fatalf("unexpected result %s after %d calls", body, calls)
+	}
+}
+
+func TestUpdateIsNotRetried(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := c.Update(context.Background(), "TrickMedia", NewQuery().Eq("id", "v1"), map[string]interface{}{"public": true})
+	if HTTPStatus(err) != http.StatusBadGateway || calls != 1 {
+		t.Fatalf("expected one failed call, got %d calls and %v", calls, err)
+	}
+}
+
+func TestClientErrorsAreTyped(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"code":"23505","message":"duplicate key value violates unique constraint","details":"Key (id)=(v1) already exists.","hint":null}`)
+	})
+
+	_, err := c.Select(context.Background(), "TrickMedia", nil)
+	var apiErr *Error
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &apiErr) {
+		t.Fatalf("expected a *Error, got %T", err)
+	}
+	if apiErr.Status != http.StatusConflict || apiErr.Details != "Key (id)=(v1) already exists." || calls != 1 {
+		t.Fatalf("unexpected error %+v after %d calls", apiErr, calls)
+	}
+	if !IsUniqueViolation(err) || HTTPStatus(err) != http.StatusConflict {
+		t.Fatalf("expected a unique violation, got %v", err)
+	}
+}
+
+func TestRetriesStopWhenContextIsDone(t *testing.T) {
+	var calls int32
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		cancel()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	c.RetryBackoff = time.Hour
+
+	if _, err := c.Delete(ctx, "TrickMedia", NewQuery().Eq("id", "v1")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Fatalf("expected no retries after cancellation, got %d calls", calls)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&Error{Status: 409, Code: CodeUniqueViolation}, http.StatusConflict},
+		{&Error{Status: 409, Code: CodeForeignKeyViolation}, http.StatusUnprocessableEntity},
+		{&Error{Status: 400, Code: CodeInvalidText}, http.StatusBadRequest},
+		{&Error{Status: 406, Code: CodeNoRows}, http.StatusNotFound},
+		{&Error{Status: 404, Code: "42P01"}, http.StatusNotFound},
+		{&Error{Status: 401, Code: CodeJWTExpired}, http.StatusBadGateway},
+		{&Error{Status: 403, Code: CodeInsufficientPrivs}, http.StatusBadGateway},
+		{&Error{Status: 503}, http.StatusBadGateway},
+		{errors.New("connection reset"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := HTTPStatus(tt.err); got != tt.want {
+			t.Errorf("HTTPStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSelectCountReadsContentRange(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Prefer") != "count=exact" {
+			t.Errorf("unexpected Prefer header %q", r.Header.Get("Prefer"))
+		}
+		w.Header().Set("Content-Range", "0-1/3573")
+		fmt.Fprint(w, `[{"id":"v1"},{"id":"v2"}]`)
+	})
+
+	body, count, err := c.SelectCount(context.Background(), "TrickMedia", NewQuery().Limit(2))
+	if err != nil || count != 3573 || string(body) != `[{"id":"v1"},{"id":"v2"}]` {
+		t.Fatalf("unexpected result %s, %d, %v", body, count, err)
+	}
+}
@@ -0,0 +1,106 @@
+package supabase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PostgreSQL and PostgREST error codes the service maps to HTTP statuses
+const (
+	CodeUniqueViolation     = "23505"
+	CodeForeignKeyViolation = "23503"
+	CodeNotNullViolation    = "23502"
+	CodeCheckViolation      = "23514"
+	CodeInvalidText         = "22P02" // e.g. a malformed uuid
+	CodeInsufficientPrivs   = "42501"
+	CodeNoRows              = "PGRST116" // a single row was requested and none (or several) matched
+	CodeJWTExpired          = "PGRST301"
+)
+
+// Error is an error response from PostgREST
+type Error struct {
+	Status  int    `json:"-"` // HTTP status of the response
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details string `json:"details"`
+	Hint    string `json:"hint"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("supabase error %d", e.Status)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Details != "" {
+		msg += ": " + e.Details
+	}
+	return msg
+}
+
+// parseError builds an Error from a response body. Bodies that aren't PostgREST JSON
+// (proxies, gateways) end up in Message.
+func parseError(status int, body []byte) *Error {
+	apiErr := &Error{Status: status}
+	if err := json.Unmarshal(body, apiErr); err != nil || (apiErr.Code == "" && apiErr.Message == "") {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	apiErr.Status = status
+	return apiErr
+}
+
+// IsUniqueViolation reports whether err is a unique constraint violation
+func IsUniqueViolation(err error) bool {
+	return hasCode(err, CodeUniqueViolation)
+}
+
+// IsNotFound reports whether err is a missing row or table
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && (apiErr.Code == CodeNoRows || apiErr.Status == http.StatusNotFound)
+}
+
+// IsPermission reports whether err is a rejected key or a missing grant or policy
+func IsPermission(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == CodeInsufficientPrivs || apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden
+}
+
+// HTTPStatus maps a Supabase error to the status a handler should respond with.
+// Errors that don't come from PostgREST are internal errors.
+func HTTPStatus(err error) int {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return http.StatusInternalServerError
+	}
+	switch {
+	case apiErr.Code == CodeUniqueViolation:
+		return http.StatusConflict
+	case apiErr.Code == CodeForeignKeyViolation, apiErr.Code == CodeNotNullViolation, apiErr.Code == CodeCheckViolation:
+		return http.StatusUnprocessableEntity
+	case apiErr.Code == CodeInvalidText:
+		return http.StatusBadRequest
+	case IsNotFound(err):
+		return http.StatusNotFound
+	case IsPermission(err):
+		// The service key was rejected, not the caller
+		return http.StatusBadGateway
+	case apiErr.Status >= 500:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func hasCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
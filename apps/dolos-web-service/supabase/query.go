@@ -0,0 +1,266 @@
+package supabase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Query builds a PostgREST query string. Values are escaped, so user input can't add
+// filters or break out of in/or lists.
+//
+//	supabase.NewQuery().Select("*", supabase.Embed("user_trick_id", "*")).Eq("id", videoId)
+type Query struct {
+	params []param
+}
+
+type param struct {
+	key   string
+	value string
+}
+
+// Filter is a single condition, used inside Or
+type Filter struct {
+	Column   string
+	Operator string // eq, neq, lt, lte, gt, gte, is, in, ...
+	Value    string // already formatted for the operator
+}
+
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Select sets the columns to return. Embedded resources are written with Embed.
+func (q *Query) Select(columns ...string) *Query {
+	return q.set("select", strings.Join(columns, ","))
+}
+
+// Embed returns a select entry for a related table, e.g. Embed("user_trick_id", "*")
+// for user_trick_id(*). Prefix the name with an alias or add !inner as PostgREST allows.
+func Embed(resource string, columns ...string) string {
+	if len(columns) == 0 {
+		columns = []string{"*"}
+	}
+	return resource + "(" + strings.Join(columns, ",") + ")"
+}
+
+// Eq filters on column = value
+func (q *Query) Eq(column string, value interface{}) *Query {
+	return q.Filter(column, "eq", value)
+}
+
+// Neq filters on column <> value
+func (q *Query) Neq(column string, value interface{}) *Query {
+	return q.Filter(column, "neq", value)
+}
+
+// Lt filters on column < value
+func (q *Query) Lt(column string, value interface{}) *Query {
+	return q.Filter(column, "lt", value)
+}
+
+// Lte filters on column <= value
+func (q *Query) Lte(column string, value interface{}) *Query {
+	return q.Filter(column, "lte", value)
+}
+
+// Gt filters on column > value
+func (q *Query) Gt(column string, value interface{}) *Query {
+	return q.Filter(column, "gt", value)
+}
+
+// Gte filters on column >= value
+func (q *Query) Gte(column string, value interface{}) *Query {
+	return q.Filter(column, "gte", value)
+}
+
+// IsNull filters on column IS NULL
+func (q *Query) IsNull(column string) *Query {
+	return q.add(column, "is.null")
+}
+
+// NotNull filters on column IS NOT NULL
+func (q *Query) NotNull(column string) *Query {
+	return q.add(column, "not.is.null")
+}
+
+// EqOrNull filters on column = *value, or IS NULL when value is nil
+func (q *Query) EqOrNull(column string, value *int64) *Query {
+	if value == nil {
+		return q.IsNull(column)
+	}
+	return q.Eq(column, *value)
+}
+
+// In filters on column IN (values)
+func (q *Query) In(column string, values ...string) *Query {
+	return q.add(column, "in."+list(values))
+}
+
+// Filter adds a condition with any PostgREST operator. The column may be a JSON path
+// such as metadata->>processed_at.
+func (q *Query) Filter(column, operator string, value interface{}) *Query {
+	return q.add(column, operator+"."+formatValue(value))
+}
+
+// Or adds a group of conditions of which at least one must match
+func (q *Query) Or(filters ...Filter) *Query {
+	return q.add("or", group(filters))
+}
+
+// And returns a group of conditions that must all match, for use inside Or
+func And(filters ...Filter) Filter {
+	return Filter{Operator: "and", Value: group(filters)}
+}
+
+// Cond returns a Filter for Or. The value is quoted, so reserved characters are safe.
+func Cond(column, operator string, value interface{}) Filter {
+	return Filter{Column: column, Operator: operator, Value: quote(formatValue(value))}
+}
+
+// InCond returns an in filter for Or
+func InCond(column string, values ...string) Filter {
+	return Filter{Column: column, Operator: "in", Value: list(values)}
+}
+
+// IsNullCond returns an is.null filter for Or
+func IsNullCond(column string) Filter {
+	return Filter{Column: column, Operator: "is", Value: "null"}
+}
+
+func (f Filter) String() string {
+	if f.Column == "" {
+		return f.Operator + f.Value // a nested and/or group
+	}
+	return f.Column + "." + f.Operator + "." + f.Value
+}
+
+func group(filters []Filter) string {
+	conditions := make([]string, len(filters))
+	for i, f := range filters {
+		conditions[i] = f.String()
+	}
+	return "(" + strings.Join(conditions, ",") + ")"
+}
+
+// Order sorts by column. Call it again to add tie-breakers.
+func (q *Query) Order(column string, ascending bool) *Query {
+	direction := "desc"
+	if ascending {
+		direction = "asc"
+	}
+	return q.order(column + "." + direction)
+}
+
+// OrderNullsLast sorts by column with NULLs after every value, whatever the direction
+func (q *Query) OrderNullsLast(column string, ascending bool) *Query {
+	direction := "desc"
+	if ascending {
+		direction = "asc"
+	}
+	return q.order(column + "." + direction + ".nullslast")
+}
+
+func (q *Query) order(term string) *Query {
+	for i, p := range q.params {
+		if p.key == "order" {
+			q.params[i].value += "," + term
+			return q
+		}
+	}
+	return q.add("order", term)
+}
+
+// Limit caps the number of rows returned
+func (q *Query) Limit(n int) *Query {
+	return q.set("limit", fmt.Sprint(n))
+}
+
+// Offset skips the first n rows
+func (q *Query) Offset(n int) *Query {
+	return q.set("offset", fmt.Sprint(n))
+}
+
+// Range returns rows from to to, inclusive and zero-based, like the Range header
+func (q *Query) Range(from, to int) *Query {
+	return q.Offset(from).Limit(to - from + 1)
+}
+
+// OnConflict sets the columns an upsert or InsertIfAbsent resolves conflicts on
+func (q *Query) OnConflict(columns ...string) *Query {
+	return q.set("on_conflict", strings.Join(columns, ","))
+}
+
+// String returns the encoded query string, including the leading "?"
+func (q *Query) String() string {
+	if q == nil || len(q.params) == 0 {
+		return ""
+	}
+	parts := make([]string, len(q.params))
+	for i, p := range q.params {
+		parts[i] = escape(p.key) + "=" + escape(p.value)
+	}
+	return "?" + strings.Join(parts, "&")
+}
+
+func (q *Query) add(key, value string) *Query {
+	q.params = append(q.params, param{key: key, value: value})
+	return q
+}
+
+// set replaces a parameter that may only appear once
+func (q *Query) set(key, value string) *Query {
+	for i, p := range q.params {
+		if p.key == key {
+			q.params[i].value = value
+			return q
+		}
+	}
+	return q.add(key, value)
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// list formats values for in.(...), quoting each one
+func list(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quote(v)
+	}
+	return "(" + strings.Join(quoted, ",") + ")"
+}
+
+// quote wraps a value in double quotes so commas, dots, colons and parentheses inside
+// it aren't read as PostgREST syntax
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+// escape percent-encodes a key or value. Unlike url.QueryEscape it leaves the
+// characters PostgREST syntax uses and URLs allow (,.():*) alone, which keeps queries
+// readable in logs.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~,():*", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
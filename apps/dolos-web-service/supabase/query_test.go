@@ -0,0 +1,85 @@
+package supabase
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestQueryString(t *testing.T) {
+	q := NewQuery().Select("*", Embed("user_trick_id", "id", "userID")).
+		Eq("media_type", "video").
+		IsNull("metadata->>processed_at").
+		Order("created_at", false).
+		Order("id", true).
+		Range(20, 29)
+
+	want := "?select=*,user_trick_id(id,userID)&media_type=eq.video&metadata-%3E%3Eprocessed_at=is.null&order=created_at.desc,id.asc&offset=20&limit=10"
+	if got := q.String(); got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+	if NewQuery().String() != "" {
+		t.Fatal("expected an empty query to encode to nothing")
+	}
+}
+
+func TestQueryEscapesValues(t *testing.T) {
+	// A parameter that tries to add its own filter must stay a single value
+	q := NewQuery().Eq("trickID", "t1&userID=eq.u2").Eq("userID", "u1")
+	values, err := url.ParseQuery(q.String()[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values.Get("trickID"); got != "eq.t1&userID=eq.u2" {
+		t.Fatalf("unexpected trickID %q", got)
+	}
+	if got := values["userID"]; len(got) != 1 || got[0] != "eq.u1" {
+		t.Fatalf("unexpected userID %q", got)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+	values, _ = url.ParseQuery(NewQuery().Lt("updated_at", at).String()[1:])
+	if got := values.Get("updated_at"); got != "lt.2024-01-02T03:04:05+01:00" {
+		t.Fatalf("unexpected time filter %q", got)
+	}
+}
+
+func TestQueryQuotesListValues(t *testing.T) {
+	q := NewQuery().
+		In("user_trick_id", "a", "b),id.neq.(x", `c"d`).
+		Or(Cond("user_trick_id", "eq", "e,f"), InCond("id", "g"))
+	values, err := url.ParseQuery(q.String()[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values.Get("user_trick_id"); got != `in.("a","b),id.neq.(x","c\"d")` {
+		t.Fatalf("unexpected in filter %q", got)
+	}
+	if got := values.Get("or"); got != `(user_trick_id.eq."e,f",id.in.("g"))` {
+		t.Fatalf("unexpected or filter %q", got)
+	}
+}
+
+func TestQueryEqOrNull(t *testing.T) {
+	ms := int64(1500)
+	if got := NewQuery().EqOrNull("trim_start_ms", &ms).EqOrNull("trim_end_ms", nil).String(); got != "?trim_start_ms=eq.1500&trim_end_ms=is.null" {
+		t.Fatalf("unexpected query %s", got)
+	}
+}
+
+func TestQueryKeysetConditions(t *testing.T) {
+	q := NewQuery().
+		Or(Cond("duration_seconds", "lt", 12.5), And(Cond("duration_seconds", "eq", 12.5), Cond("id", "lt", "v1")), IsNullCond("duration_seconds")).
+		OrderNullsLast("duration_seconds", false).
+		Order("id", false)
+	values, err := url.ParseQuery(q.String()[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values.Get("or"); got != `(duration_seconds.lt."12.5",and(duration_seconds.eq."12.5",id.lt."v1"),duration_seconds.is.null)` {
+		t.Fatalf("unexpected or filter %q", got)
+	}
+	if got := values.Get("order"); got != "duration_seconds.desc.nullslast,id.desc" {
+		t.Fatalf("unexpected order %q", got)
+	}
+}
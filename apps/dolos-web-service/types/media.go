@@ -1,35 +1,48 @@
 package types
 
+import (
+	"fmt"
+	"strings"
+)
+
 // MediaConfig holds table and path configuration for trick vs combo media
 type MediaConfig struct {
-	Table            string // TrickMedia or ComboMedia
-	ParentTable      string // UserToTricks or UserCombos
-	PathPrefix       string // tricks or combos
-	ForeignKey       string // user_trick_id or user_combo_id
-	ParentIDCol      string // trickID or id (column name in parent table)
-	UserIDCol        string // userID or user_id (column name in parent table)
-	AutoCreateUserLink bool // Whether to auto-create UserToTricks link record if not found
+	Table              string // TrickMedia or ComboMedia
+	ParentTable        string // UserToTricks or UserCombos
+	PathPrefix         string // tricks or combos
+	ForeignKey         string // user_trick_id or user_combo_id
+	ParentIDCol        string // trickID or id (column name in parent table)
+	UserIDCol          string // userID or user_id (column name in parent table)
+	AutoCreateUserLink bool   // Whether to auto-create UserToTricks link record if not found
+	LogTable           string // TrickLogs or ComboLogs, linked to the parent through ForeignKey
+	LogKey             string // tricklog_id or combolog_id, the media row's optional log
+	NameTable          string // Tricks, where the parent's name is; "" when the parent row has it (UserCombos.name)
 }
 
 // MediaConfigs maps VideoType to its corresponding MediaConfig
 var MediaConfigs = map[VideoType]MediaConfig{
 	VideoTypeTrick: {
-		Table:            "TrickMedia",
-		ParentTable:      "UserToTricks",
-		PathPrefix:       "tricks",
-		ForeignKey:       "user_trick_id",
-		ParentIDCol:      "trickID",
-		UserIDCol:        "userID",
+		Table:              "TrickMedia",
+		ParentTable:        "UserToTricks",
+		PathPrefix:         "tricks",
+		ForeignKey:         "user_trick_id",
+		ParentIDCol:        "trickID",
+		UserIDCol:          "userID",
 		AutoCreateUserLink: true,
+		LogTable:           "TrickLogs",
+		LogKey:             "tricklog_id",
+		NameTable:          "Tricks",
 	},
 	VideoTypeCombo: {
-		Table:            "ComboMedia",
-		ParentTable:      "UserCombos",
-		PathPrefix:       "combos",
-		ForeignKey:       "user_combo_id",
-		ParentIDCol:      "id",
-		UserIDCol:        "user_id",
+		Table:              "ComboMedia",
+		ParentTable:        "UserCombos",
+		PathPrefix:         "combos",
+		ForeignKey:         "user_combo_id",
+		ParentIDCol:        "id",
+		UserIDCol:          "user_id",
 		AutoCreateUserLink: false,
+		LogTable:           "ComboLogs",
+		LogKey:             "combolog_id",
 	},
 }
 
@@ -38,3 +51,45 @@ func GetMediaConfig(videoType VideoType) (MediaConfig, bool) {
 	cfg, ok := MediaConfigs[videoType]
 	return cfg, ok
 }
+
+// VideoKey returns the storage key of a video. Files derived from the video (thumbnails,
+// renditions) are stored under VideoKey + "/".
+func (c MediaConfig) VideoKey(parentID, userID, videoID string) string {
+	return fmt.Sprintf("%s/%s/videos/%s/%s", c.PathPrefix, parentID, userID, videoID)
+}
+
+// StorageKey identifies the video a stored object belongs to
+type StorageKey struct {
+	Type     VideoType
+	ParentID string
+	UserID   string
+	VideoID  string
+	Name     string // derived file under the video, e.g. thumbnail.jpg; "" for the video itself
+}
+
+// VideoKey returns the key of the video the object belongs to
+func (k StorageKey) VideoKey() string {
+	return MediaConfigs[k.Type].VideoKey(k.ParentID, k.UserID, k.VideoID)
+}
+
+// ParseStorageKey splits a key built by VideoKey, or a file derived from one
+func ParseStorageKey(key string) (StorageKey, bool) {
+	parts := strings.SplitN(key, "/", 6)
+	if len(parts) < 5 || parts[2] != "videos" {
+		return StorageKey{}, false
+	}
+	for videoType, cfg := range MediaConfigs {
+		if cfg.PathPrefix != parts[0] {
+			continue
+		}
+		k := StorageKey{Type: videoType, ParentID: parts[1], UserID: parts[3], VideoID: parts[4]}
+		if len(parts) == 6 {
+			k.Name = parts[5]
+		}
+		if k.ParentID == "" || k.UserID == "" || k.VideoID == "" {
+			return StorageKey{}, false
+		}
+		return k, true
+	}
+	return StorageKey{}, false
+}
@@ -0,0 +1,24 @@
+package types
+
+import "testing"
+
+func TestParseStorageKey(t *testing.T) {
+	key := MediaConfigs[VideoTypeCombo].VideoKey("c1", "u1", "v1")
+	if key != "combos/c1/videos/u1/v1" {
+		t.Fatalf("unexpected key %s", key)
+	}
+
+	parsed, ok := ParseStorageKey(key + "/hls/720/index.m3u8")
+	if !ok {
+		t.Fatal("expected a derived key to parse")
+	}
+	if parsed.Type != VideoTypeCombo || parsed.VideoID != "v1" || parsed.Name != "hls/720/index.m3u8" || parsed.VideoKey() != key {
+		t.Fatalf("unexpected parse %+v", parsed)
+	}
+
+	for _, bad := range []string{"uploads/tus/v1/0", "tricks/t1/videos/u1", "tricks/t1/images/u1/v1", "other/t1/videos/u1/v1", "tricks//videos/u1/v1"} {
+		if _, ok := ParseStorageKey(bad); ok {
+			t.Errorf("expected %q not to parse", bad)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package types
+
+import "time"
+
+// MergeMetadata returns a copy of an untyped media row's metadata JSONB with fields set
+// and the remove keys deleted. The row itself is left alone.
+func MergeMetadata(row map[string]interface{}, fields map[string]interface{}, remove ...string) map[string]interface{} {
+	merged := map[string]interface{}{}
+	if existing, ok := row["metadata"].(map[string]interface{}); ok {
+		for k, v := range existing {
+			merged[k] = v
+		}
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	for _, k := range remove {
+		delete(merged, k)
+	}
+	return merged
+}
+
+// RowTime parses a timestamp column of an untyped row
+func RowTime(row map[string]interface{}, key string) (time.Time, bool) {
+	value, ok := row[key].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,30 @@
+package types
+
+import "testing"
+
+func TestMergeMetadata(t *testing.T) {
+	row := map[string]interface{}{"metadata": map[string]interface{}{"processed_at": "x", "trim": "y", "probe": "z"}}
+	merged := MergeMetadata(row, map[string]interface{}{"failed_at": "now"}, "processed_at", "trim")
+	if len(merged) != 2 || merged["probe"] != "z" || merged["failed_at"] != "now" {
+		t.Fatalf("unexpected metadata %v", merged)
+	}
+	if _, ok := row["metadata"].(map[string]interface{})["trim"]; !ok {
+		t.Fatal("expected the row's metadata to be left alone")
+	}
+	if merged := MergeMetadata(map[string]interface{}{}, nil); len(merged) != 0 {
+		t.Fatalf("expected empty metadata for a row without any, got %v", merged)
+	}
+}
+
+func TestRowTime(t *testing.T) {
+	row := map[string]interface{}{"created_at": "2026-01-02T03:04:05.123456+00:00", "bad": "yesterday"}
+	if got, ok := RowTime(row, "created_at"); !ok || got.Nanosecond() != 123456000 {
+		t.Fatalf("unexpected time %v %v", got, ok)
+	}
+	if _, ok := RowTime(row, "bad"); ok {
+		t.Fatal("expected an unparseable time to fail")
+	}
+	if _, ok := RowTime(row, "missing"); ok {
+		t.Fatal("expected a missing column to fail")
+	}
+}
@@ -14,11 +14,30 @@ const (
 type VideoUploadRequest struct {
 	Type     VideoType `json:"type" binding:"required"`
 	ParentID string    `json:"parentId" binding:"required"` // trickId or comboId depending on type
-	UserID   string    `json:"userId" binding:"required"`
+	UserID   string    `json:"userId,omitempty"`            // defaults to the authenticated user
 	FileName string    `json:"fileName" binding:"required"`
 	FileSize int64     `json:"fileSize" binding:"required"`
 	MimeType string    `json:"mimeType" binding:"required"`
 	Duration *float64  `json:"duration,omitempty"` // in milliseconds
+
+	ThumbnailTimeMs *int64 `json:"thumbnailTimeMs,omitempty"` // poster frame for the generated thumbnail
+
+	// The logged attempt the video records, set for the request's type only
+	TrickLogID string `json:"trickLogId,omitempty"`
+	ComboLogID string `json:"comboLogId,omitempty"`
+}
+
+// LogID returns the log the upload should link to, and false if a log of the other
+// type was given
+func (r VideoUploadRequest) LogID() (string, bool) {
+	switch r.Type {
+	case VideoTypeTrick:
+		return r.TrickLogID, r.ComboLogID == ""
+	case VideoTypeCombo:
+		return r.ComboLogID, r.TrickLogID == ""
+	default:
+		return "", r.TrickLogID == "" && r.ComboLogID == ""
+	}
 }
 
 // VideoUploadResponse matches TypeScript interface
@@ -40,7 +59,170 @@ type VideoMetadata struct {
 	FileSize     int64     `json:"fileSize"`
 	MimeType     string    `json:"mimeType"`
 	UploadedAt   time.Time `json:"uploadedAt"`
-	Status       string    `json:"status"` // pending, processing, completed, failed
+	Status       string    `json:"status"`          // pending, processing, completed, failed
+	MediaType    string    `json:"mediaType"`       // video or image
+	LogID        string    `json:"logId,omitempty"` // the TrickLogs/ComboLogs entry the video records
+
+	Renditions []Rendition `json:"renditions,omitempty"` // H.264 transcodes, smallest first
+	HLSURL     string      `json:"hlsUrl,omitempty"`     // HLS master playlist
+
+	PreviewURL     string `json:"previewUrl,omitempty"`     // short looping animation for gallery tiles
+	ScrubTrackURL  string `json:"scrubTrackUrl,omitempty"`  // WebVTT thumbnail track
+	SpriteSheetURL string `json:"spriteSheetUrl,omitempty"` // image referenced by the scrub track
+
+	Derivatives []ImageDerivative `json:"derivatives,omitempty"` // resized JPEG copies of an image, smallest first
+}
+
+// VideoPage is one page of a video listing. Pass NextCursor as ?cursor= to get the next
+// page, it's empty on the last one.
+type VideoPage struct {
+	Videos     []VideoMetadata `json:"videos"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+	Total      *int            `json:"total,omitempty"` // only with ?count=exact
+}
+
+// MediaItem is an entry of a user's media feed: a video with what it belongs to
+type MediaItem struct {
+	VideoMetadata
+	Type VideoType   `json:"type"`
+	Name string      `json:"name,omitempty"` // the trick's or combo's name
+	Log  *LogSummary `json:"log,omitempty"`  // the logged attempt the video records
+}
+
+// LogSummary is the TrickLogs/ComboLogs entry a video is linked to
+type LogSummary struct {
+	ID        string `json:"id"`
+	SessionID string `json:"sessionId,omitempty"`
+	Landed    *bool  `json:"landed,omitempty"`
+	LoggedAt  string `json:"loggedAt"`
+}
+
+// MediaFeed is one page of a user's media feed, paged like VideoPage
+type MediaFeed struct {
+	Items      []MediaItem `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	Total      *int        `json:"total,omitempty"`
+}
+
+// SessionMedia is the videos recorded during a training session, grouped by trick or
+// combo in the order they were first logged
+type SessionMedia struct {
+	SessionID string       `json:"sessionId"`
+	UserID    string       `json:"userId"`
+	StartedAt string       `json:"startedAt"`
+	EndedAt   *string      `json:"endedAt"`
+	Groups    []MediaGroup `json:"groups"`
+}
+
+// MediaGroup is a session's videos of one trick or combo, oldest log first
+type MediaGroup struct {
+	Type     VideoType   `json:"type"`
+	ParentID string      `json:"parentId"` // trickId or comboId depending on type
+	Name     string      `json:"name,omitempty"`
+	Items    []MediaItem `json:"items"`
+}
+
+// Rendition is a transcoded copy of a video, stored in metadata.renditions
+type Rendition struct {
+	Quality int    `json:"quality"` // short edge, e.g. 720 for 720p
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	URL     string `json:"url"`
+	Bitrate int64  `json:"bitrate"` // bits per second
+	Size    int64  `json:"size"`    // bytes
+}
+
+// ImageDerivative is a resized JPEG copy of an uploaded image, stored in metadata.derivatives
+type ImageDerivative struct {
+	Name   string `json:"name"` // thumb, medium or large
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"` // bytes
+}
+
+// HLSPlaylist is stored in metadata.hls
+type HLSPlaylist struct {
+	MasterURL string `json:"master_url"`
+}
+
+// Preview is an animated preview, stored in metadata.preview
+type Preview struct {
+	URL      string  `json:"url"`
+	Format   string  `json:"format"` // webp or gif
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Start    float64 `json:"start"` // seconds into the video
+	Duration float64 `json:"duration"`
+}
+
+// SpriteSheet is a grid of frames for scrubbing, stored in metadata.sprites. The
+// WebVTT track maps time ranges to tiles with #xywh fragments.
+type SpriteSheet struct {
+	URL        string  `json:"url"`
+	VTTURL     string  `json:"vtt_url"`
+	TileWidth  int     `json:"tile_width"`
+	TileHeight int     `json:"tile_height"`
+	Columns    int     `json:"columns"`
+	Tiles      int     `json:"tiles"`
+	Interval   float64 `json:"interval"` // seconds between tiles
+}
+
+// MediaRow is a TrickMedia/ComboMedia row as returned by PostgREST
+type MediaRow struct {
+	ID              string   `json:"id"`
+	URL             string   `json:"url"`
+	ThumbnailURL    *string  `json:"thumbnail_url"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+	FileSizeBytes   *int64   `json:"file_size_bytes"`
+	MimeType        *string  `json:"mime_type"`
+	MediaType       string   `json:"media_type"`
+	UploadStatus    string   `json:"upload_status"`
+	CreatedAt       string   `json:"created_at"`
+	Metadata        struct {
+		Renditions  []Rendition       `json:"renditions"`
+		Derivatives []ImageDerivative `json:"derivatives"`
+		HLS         *HLSPlaylist      `json:"hls"`
+		Preview     *Preview          `json:"preview"`
+		Sprites     *SpriteSheet      `json:"sprites"`
+	} `json:"metadata"`
+}
+
+// VideoMetadata converts the row to the API shape
+func (r MediaRow) VideoMetadata() VideoMetadata {
+	video := VideoMetadata{
+		ID:           r.ID,
+		URL:          r.URL,
+		ThumbnailURL: r.ThumbnailURL,
+		Status:       r.UploadStatus,
+		MediaType:    r.MediaType,
+		Renditions:   r.Metadata.Renditions,
+		Derivatives:  r.Metadata.Derivatives,
+	}
+	if r.DurationSeconds != nil {
+		duration := int(*r.DurationSeconds)
+		video.Duration = &duration
+	}
+	if r.FileSizeBytes != nil {
+		video.FileSize = *r.FileSizeBytes
+	}
+	if r.MimeType != nil {
+		video.MimeType = *r.MimeType
+	}
+	if createdAt, err := time.Parse(time.RFC3339Nano, r.CreatedAt); err == nil {
+		video.UploadedAt = createdAt
+	}
+	if r.Metadata.HLS != nil {
+		video.HLSURL = r.Metadata.HLS.MasterURL
+	}
+	if r.Metadata.Preview != nil {
+		video.PreviewURL = r.Metadata.Preview.URL
+	}
+	if r.Metadata.Sprites != nil {
+		video.ScrubTrackURL = r.Metadata.Sprites.VTTURL
+		video.SpriteSheetURL = r.Metadata.Sprites.URL
+	}
+	return video
 }
 
 // VideoUploadCompleteRequest for confirming upload
@@ -48,3 +230,63 @@ type VideoUploadCompleteRequest struct {
 	Type    VideoType `json:"type" binding:"required"`
 	VideoID string    `json:"videoId" binding:"required"`
 }
+
+// TrimRequest sets the range of a video to keep. A nil point leaves that end uncut, and
+// both nil removes the trim.
+type TrimRequest struct {
+	TrimStartMs *int64 `json:"trimStartMs"`
+	TrimEndMs   *int64 `json:"trimEndMs"`
+}
+
+// LinkLogRequest links a video to a log of its parent trick or combo. A null logId
+// unlinks it.
+type LinkLogRequest struct {
+	LogID *string `json:"logId"`
+}
+
+// MultipartUploadResponse is returned when a multipart upload is created
+type MultipartUploadResponse struct {
+	VideoID   string `json:"videoId"`
+	UploadID  string `json:"uploadId"`
+	PartSize  int64  `json:"partSize"`  // bytes per part, except the last
+	PartCount int    `json:"partCount"` // number of parts for the declared file size
+	ExpiresAt string `json:"expiresAt"` // abandoned uploads are aborted after this
+}
+
+// MultipartPartsRequest asks for presigned URLs for specific parts
+type MultipartPartsRequest struct {
+	Type        VideoType `json:"type" binding:"required"`
+	VideoID     string    `json:"videoId" binding:"required"`
+	PartNumbers []int32   `json:"partNumbers" binding:"required,min=1,max=100"`
+}
+
+// MultipartPartURL is a presigned URL for one part
+type MultipartPartURL struct {
+	PartNumber int32  `json:"partNumber"`
+	UploadURL  string `json:"uploadUrl"`
+}
+
+// MultipartPartsResponse returns presigned part URLs
+type MultipartPartsResponse struct {
+	Parts     []MultipartPartURL `json:"parts"`
+	ExpiresAt string             `json:"expiresAt"`
+}
+
+// MultipartCompletedPart is a part the client uploaded, with the ETag returned by storage
+type MultipartCompletedPart struct {
+	PartNumber int32  `json:"partNumber" binding:"required"`
+	ETag       string `json:"etag" binding:"required"`
+}
+
+// MultipartCompleteRequest completes a multipart upload
+type MultipartCompleteRequest struct {
+	Type    VideoType                `json:"type" binding:"required"`
+	VideoID string                   `json:"videoId" binding:"required"`
+	Parts   []MultipartCompletedPart `json:"parts" binding:"required,min=1,dive"`
+}
+
+// MultipartAbortRequest aborts a multipart upload
+type MultipartAbortRequest struct {
+	Type    VideoType `json:"type" binding:"required"`
+	VideoID string    `json:"videoId" binding:"required"`
+}
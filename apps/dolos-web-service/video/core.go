@@ -3,150 +3,152 @@ package video
 import (
 	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
 	"github.com/hyperbolic/dolos-web-service/types"
 )
 
-// RequestUploadCore is the shared implementation for video upload requests
-func RequestUploadCore(c *gin.Context, cfg types.MediaConfig, parentID string, userID string, fileSize int64, mimeType string, duration *float64) {
-	// Validate file size (100MB max)
-	if fileSize > 100*1024*1024 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File size exceeds 100MB limit"})
+// uploadURLExpiry is how long a presigned upload URL stays valid
+const uploadURLExpiry = 15 * time.Minute
+
+// Upload size limits, photos are much smaller than clips
+const (
+	maxVideoSize = 100 * 1024 * 1024
+	maxImageSize = 25 * 1024 * 1024
+)
+
+// mediaTypes maps the accepted upload types to their media_type
+var mediaTypes = map[string]string{
+	"video/mp4":       "video",
+	"video/quicktime": "video",
+	"image/jpeg":      "image",
+	"image/png":       "image",
+	"image/heic":      "image",
+	"image/heif":      "image",
+	"image/webp":      "image",
+}
+
+// Processor is notified when an upload completes. It's nil unless the worker runs in-process.
+var Processor interface {
+	Enqueue(cfg types.MediaConfig, videoId string)
+}
+
+// RequestUploadCore is the shared implementation for video and image upload requests
+func RequestUploadCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, parentID string, userID string, fileSize int64, mimeType string, duration *float64, thumbnailTimeMs *int64, logID string) {
+	if err := clients.Policy.AuthorizeRequest(c.Request.Context(), caller, cfg, parentID, userID); err != nil {
+		policy.Respond(c, err)
 		return
 	}
 
-	// Validate mime type
-	if mimeType != "video/mp4" && mimeType != "video/quicktime" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid video format. Only MP4 and MOV are supported"})
+	if !validateUpload(c, fileSize, mimeType) {
+		return
+	}
+	if !checkUploadLog(c, cfg, logID, parentID, userID) {
 		return
 	}
 
 	// Generate unique video ID
 	videoId := uuid.New().String()
-	key := fmt.Sprintf("%s/%s/videos/%s/%s", cfg.PathPrefix, parentID, userID, videoId)
+	key := cfg.VideoKey(parentID, userID, videoId)
 
 	// Create presigned URL for upload
-	presignClient := s3.NewPresignClient(clients.S3)
-	request, err := presignClient.PresignPutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      aws.String(os.Getenv("CLOUDFLARE_R2_BUCKET_NAME")),
-		Key:         aws.String(key),
-		ContentType: aws.String(mimeType),
-	}, func(opts *s3.PresignOptions) {
-		opts.Expires = time.Duration(15 * time.Minute)
-	})
-
+	uploadURL, err := clients.Storage.PresignPut(c.Request.Context(), key, mimeType, uploadURLExpiry)
 	if err != nil {
 		log.Printf("Failed to generate presigned URL: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate upload URL", "details": err.Error()})
 		return
 	}
 
-	// Get or create parent record
-	var parentRecordID string
-	parentQuery := fmt.Sprintf("?%s=eq.%s&%s=eq.%s&select=id", cfg.UserIDCol, userID, cfg.ParentIDCol, parentID)
-
-	// For combos, parentID IS the record ID, so query differently
-	if cfg.Table == "ComboMedia" {
-		parentQuery = fmt.Sprintf("?id=eq.%s&%s=eq.%s&select=id", parentID, cfg.UserIDCol, userID)
-	}
-
-	parentResp, err := clients.Supabase.Select(cfg.ParentTable, parentQuery)
-	if err != nil {
-		log.Printf("Failed to query %s: %v", cfg.ParentTable, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lookup parent record", "details": err.Error()})
+	if !createPendingRecord(c, cfg, parentID, userID, videoId, key, fileSize, mimeType, duration, logID, withThumbnailTime(nil, thumbnailTimeMs)) {
 		return
 	}
 
-	var parentRecords []map[string]interface{}
-	if err := json.Unmarshal(parentResp, &parentRecords); err != nil {
-		log.Printf("Failed to parse parent records: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse parent data"})
-		return
-	}
+	c.JSON(http.StatusOK, types.VideoUploadResponse{
+		UploadURL: uploadURL,
+		VideoID:   videoId,
+		ExpiresAt: time.Now().Add(uploadURLExpiry).Format(time.RFC3339),
+	})
+}
 
-	if len(parentRecords) > 0 {
-		parentRecordID = parentRecords[0]["id"].(string)
-	} else if cfg.AutoCreateUserLink {
-		// Create new parent record (only for tricks)
-		newParent := map[string]interface{}{
-			cfg.UserIDCol:   userID,
-			cfg.ParentIDCol: parentID,
-			"landed":        false,
+// validateUpload checks the declared file size and type of a new upload
+func validateUpload(c *gin.Context, fileSize int64, mimeType string) bool {
+	switch mediaTypes[mimeType] {
+	case "video":
+		if fileSize > maxVideoSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File size exceeds 100MB limit"})
+			return false
 		}
-		createResp, err := clients.Supabase.Insert(cfg.ParentTable, newParent)
-		if err != nil {
-			log.Printf("Failed to create %s record: %v", cfg.ParentTable, err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create parent record", "details": err.Error()})
-			return
+	case "image":
+		if fileSize > maxImageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Image size exceeds 25MB limit"})
+			return false
 		}
-
-		var createdRecords []map[string]interface{}
-		if err := json.Unmarshal(createResp, &createdRecords); err != nil || len(createdRecords) == 0 {
-			log.Printf("Failed to parse created parent record: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create parent record"})
-			return
-		}
-		parentRecordID = createdRecords[0]["id"].(string)
-	} else {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Parent record not found"})
-		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format. Only MP4 and MOV videos and JPEG, PNG, HEIC and WebP images are supported"})
+		return false
 	}
 
-	// Save pending upload record to database
-	pendingVideo := map[string]interface{}{
-		"id":              videoId,
-		cfg.ForeignKey:    parentRecordID,
-		"url":             fmt.Sprintf("%s/%s", os.Getenv("CLOUDFLARE_R2_PUBLIC_URL"), key),
-		"file_size_bytes": fileSize,
-		"mime_type":       mimeType,
-		"media_type":      "video",
-		"upload_status":   "pending",
+	return true
+}
+
+// withThumbnailTime records the client's preferred poster frame for the thumbnail step
+func withThumbnailTime(metadata map[string]interface{}, thumbnailTimeMs *int64) map[string]interface{} {
+	if thumbnailTimeMs == nil || *thumbnailTimeMs < 0 {
+		return metadata
 	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["thumbnail_time_ms"] = *thumbnailTimeMs
+	return metadata
+}
 
-	if duration != nil {
-		pendingVideo["duration_seconds"] = int(*duration / 1000)
+// createPendingRecord resolves the parent record and inserts the pending media row
+func createPendingRecord(c *gin.Context, cfg types.MediaConfig, parentID string, userID string, videoId string, key string, fileSize int64, mimeType string, duration *float64, logID string, metadata map[string]interface{}) bool {
+	pending := repository.PendingMedia{
+		ID:        videoId,
+		ParentID:  parentID,
+		UserID:    userID,
+		URL:       clients.Storage.PublicURL(key),
+		FileSize:  fileSize,
+		MimeType:  mimeType,
+		MediaType: mediaTypes[mimeType],
+		LogID:     logID,
+		Metadata:  metadata,
+	}
+	if duration != nil && pending.MediaType == "video" {
+		seconds := int(*duration / 1000)
+		pending.DurationSeconds = &seconds
 	}
 
-	_, err = clients.Supabase.Insert(cfg.Table, pendingVideo)
-	if err != nil {
-		log.Printf("Failed to insert video record: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload record", "details": err.Error()})
-		return
+	if _, err := clients.Repository.CreatePendingMedia(c.Request.Context(), cfg, pending); err != nil {
+		if errors.Is(err, repository.ErrParentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Parent record not found"})
+			return false
+		}
+		log.Printf("Failed to create upload record: %v", err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to create upload record", "details": err.Error()})
+		return false
 	}
 
-	c.JSON(http.StatusOK, types.VideoUploadResponse{
-		UploadURL: request.URL,
-		VideoID:   videoId,
-		ExpiresAt: time.Now().Add(15 * time.Minute).Format(time.RFC3339),
-	})
+	return true
 }
 
 // UploadThumbnailCore handles thumbnail upload for both tricks and combos
-func UploadThumbnailCore(c *gin.Context, cfg types.MediaConfig, videoId string, userId string) {
-	// Get video to verify ownership
-	foreignKeyExpand := cfg.ForeignKey + "(*)"
-	respData, err := clients.Supabase.Select(cfg.Table, fmt.Sprintf("?id=eq.%s&select=*,%s", videoId, foreignKeyExpand))
+func UploadThumbnailCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string) {
+	// Get video and verify ownership
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionThumbnail, videoId)
 	if err != nil {
-		log.Printf("Failed to fetch video: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video"})
-		return
-	}
-
-	var videos []map[string]interface{}
-	if err := json.Unmarshal(respData, &videos); err != nil || len(videos) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		policy.Respond(c, err)
 		return
 	}
 
@@ -181,47 +183,34 @@ func UploadThumbnailCore(c *gin.Context, cfg types.MediaConfig, videoId string,
 		return
 	}
 
-	// Get parent ID from video metadata
-	videoData := videos[0]
-	parentRecord := videoData[cfg.ForeignKey].(map[string]interface{})
-	var parentID string
-	if cfg.Table == "TrickMedia" {
-		parentID = parentRecord["trickID"].(string)
-	} else {
-		parentID = parentRecord["id"].(string)
-	}
-
 	// Construct thumbnail key
 	extension := "jpg"
 	if contentType == "image/png" {
 		extension = "png"
 	}
-	thumbnailKey := fmt.Sprintf("%s/%s/videos/%s/%s/thumbnail.%s", cfg.PathPrefix, parentID, userId, videoId, extension)
+	thumbnailKey := cfg.VideoKey(media.ParentID, media.OwnerID, videoId) + "/thumbnail." + extension
 
-	// Upload to R2
-	_, err = clients.S3.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      aws.String(os.Getenv("CLOUDFLARE_R2_BUCKET_NAME")),
-		Key:         aws.String(thumbnailKey),
-		Body:        bytes.NewReader(fileContent),
-		ContentType: aws.String(contentType),
-	})
+	// Upload to storage
+	err = clients.Storage.Put(c.Request.Context(), thumbnailKey, bytes.NewReader(fileContent), int64(len(fileContent)), contentType)
 	if err != nil {
-		log.Printf("Failed to upload thumbnail to R2: %v", err)
+		log.Printf("Failed to upload thumbnail to storage: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload thumbnail"})
 		return
 	}
 
-	// Update media record with thumbnail URL
-	thumbnailURL := fmt.Sprintf("%s/%s", os.Getenv("CLOUDFLARE_R2_PUBLIC_URL"), thumbnailKey)
+	// Update media record with thumbnail URL. metadata.thumbnail describes a generated
+	// thumbnail, which this one replaces at the same key for JPEGs.
+	thumbnailURL := clients.Storage.PublicURL(thumbnailKey)
 	updateData := map[string]interface{}{
 		"thumbnail_url": thumbnailURL,
+		"metadata":      types.MergeMetadata(media.Row, nil, "thumbnail"),
 		"updated_at":    time.Now().Format(time.RFC3339),
 	}
 
-	_, err = clients.Supabase.Update(cfg.Table, fmt.Sprintf("?id=eq.%s", videoId), updateData)
+	_, err = clients.Media.UpdateMedia(c.Request.Context(), cfg, videoId, "", updateData)
 	if err != nil {
 		log.Printf("Failed to update thumbnail URL: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save thumbnail"})
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to save thumbnail"})
 		return
 	}
 
@@ -231,22 +220,48 @@ func UploadThumbnailCore(c *gin.Context, cfg types.MediaConfig, videoId string,
 	})
 }
 
-// CompleteUploadCore confirms upload completion
-func CompleteUploadCore(c *gin.Context, cfg types.MediaConfig, videoId string) {
-	// Update upload status in database
-	updateData := map[string]interface{}{
-		"upload_status": "completed",
-		"updated_at":    time.Now().Format(time.RFC3339),
+// CompleteUploadCore confirms upload completion after verifying the object in storage
+func CompleteUploadCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string) {
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionComplete, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	finishUpload(c, cfg, media)
+}
+
+// finishUpload verifies the stored object for a media row and moves it to completed or failed
+func finishUpload(c *gin.Context, cfg types.MediaConfig, media *policy.Media) {
+	videoId := media.ID
+
+	switch media.Row["upload_status"] {
+	case "completed", "processing":
+		c.JSON(http.StatusOK, gin.H{"success": true, "videoId": videoId})
+		return
+	case "failed":
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload has already failed", "retryable": false})
+		return
 	}
 
-	_, err := clients.Supabase.Update(cfg.Table, fmt.Sprintf("?id=eq.%s", videoId), updateData)
+	outcome, reason, err := completeUpload(c.Request.Context(), cfg, media)
 	if err != nil {
 		log.Printf("Failed to update %s: %v", cfg.Table, err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete upload", "details": err.Error()})
 		return
 	}
 
-	// TODO: Trigger video processing (thumbnail generation, transcoding)
+	switch outcome {
+	case verifyPending:
+		// Leave the row pending so the client can finish the PUT and try again
+		log.Printf("Upload %s not ready: %s", videoId, reason)
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload not found in storage yet", "details": reason, "retryable": true})
+		return
+	case verifyFailed:
+		log.Printf("Upload %s failed verification: %s", videoId, reason)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Upload verification failed", "details": reason, "retryable": false})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -254,56 +269,91 @@ func CompleteUploadCore(c *gin.Context, cfg types.MediaConfig, videoId string) {
 	})
 }
 
-// DeleteCore removes a video from storage and database
-func DeleteCore(c *gin.Context, cfg types.MediaConfig, videoId string, userId string) {
-	// Get video metadata and verify ownership
-	foreignKeyExpand := cfg.ForeignKey + "(*)"
-	respData, err := clients.Supabase.Select(cfg.Table, fmt.Sprintf("?id=eq.%s&select=*,%s", videoId, foreignKeyExpand))
+// completeUpload verifies the stored object for a pending media row and marks the
+// row completed, or failed if the object can never be valid
+func completeUpload(ctx context.Context, cfg types.MediaConfig, media *policy.Media) (verifyOutcome, string, error) {
+	key := cfg.VideoKey(media.ParentID, media.OwnerID, media.ID)
+	outcome, reason := verifyUploadedObject(ctx, key, media.Row)
+
+	switch outcome {
+	case verifyPending:
+		return outcome, reason, nil
+	case verifyFailed:
+		markFailed(ctx, cfg, media.ID, media.Row, reason)
+		return outcome, reason, nil
+	}
+
+	// Update upload status in database. Only a row that's still pending moves, the
+	// client and the janitor can both get here and the worker may already have claimed
+	// the row from the other one.
+	updateData := map[string]interface{}{
+		"upload_status": "completed",
+		"updated_at":    time.Now().Format(time.RFC3339),
+	}
+
+	updated, err := clients.Media.UpdateMedia(ctx, cfg, media.ID, "pending", updateData)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch video"})
-		return
+		return outcome, "", err
+	}
+	if !updated {
+		// Someone else completed or failed it in the meantime
+		return verifyOK, "", nil
 	}
 
-	var videos []map[string]interface{}
-	if err := json.Unmarshal(respData, &videos); err != nil || len(videos) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
-		return
+	// Start processing right away when the worker runs in-process, otherwise it polls
+	if Processor != nil {
+		Processor.Enqueue(cfg, media.ID)
 	}
 
-	videoData := videos[0]
+	return verifyOK, "", nil
+}
 
-	// Verify ownership through parent record
-	parentRecord := videoData[cfg.ForeignKey].(map[string]interface{})
-	videoUserId := parentRecord[cfg.UserIDCol].(string)
-	if videoUserId != userId {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not authorized to delete this video"})
-		return
+// markFailed moves a pending media row to failed and records the reason in its metadata.
+// It reports whether the row was still pending.
+func markFailed(ctx context.Context, cfg types.MediaConfig, videoId string, row map[string]interface{}, reason string) bool {
+	failData := map[string]interface{}{
+		"upload_status": "failed",
+		"metadata": types.MergeMetadata(row, map[string]interface{}{
+			"failure_reason": reason,
+			"failed_at":      time.Now().Format(time.RFC3339),
+		}),
+		"updated_at": time.Now().Format(time.RFC3339),
+	}
+	updated, err := clients.Media.UpdateMedia(ctx, cfg, videoId, "pending", failData)
+	if err != nil {
+		log.Printf("Failed to mark %s %s as failed: %v", cfg.Table, videoId, err)
 	}
+	return updated
+}
 
-	// Get parent ID for constructing S3 key
-	var parentID string
-	if cfg.Table == "TrickMedia" {
-		parentID = parentRecord["trickID"].(string)
-	} else {
-		parentID = parentRecord["id"].(string)
+// DeleteCore removes a video from storage and database
+func DeleteCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string) {
+	// Get video metadata and verify ownership through parent record
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionDelete, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
 	}
 
-	// Construct S3 key
-	key := fmt.Sprintf("%s/%s/videos/%s/%s", cfg.PathPrefix, parentID, userId, videoId)
+	// Construct storage key
+	key := cfg.VideoKey(media.ParentID, media.OwnerID, videoId)
 
-	// Delete from R2
-	_, err = clients.S3.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(os.Getenv("CLOUDFLARE_R2_BUCKET_NAME")),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		log.Printf("Failed to delete from R2: %v", err)
+	// Delete the video and everything stored under it (thumbnails). The row is kept if
+	// storage fails, so the client can retry instead of leaving orphaned objects.
+	if _, err := clients.Storage.DeletePrefix(c.Request.Context(), key+"/"); err != nil {
+		log.Printf("Failed to delete derived objects from storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video from storage", "retryable": true})
+		return
+	}
+	if err := clients.Storage.Delete(c.Request.Context(), key); err != nil {
+		log.Printf("Failed to delete from storage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video from storage", "retryable": true})
+		return
 	}
 
 	// Delete from database
-	_, err = clients.Supabase.Delete(cfg.Table, fmt.Sprintf("?id=eq.%s", videoId))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete video"})
+	if _, err := clients.Media.DeleteMedia(c.Request.Context(), cfg, videoId, ""); err != nil {
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to delete video"})
 		return
 	}
 
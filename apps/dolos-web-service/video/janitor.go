@@ -0,0 +1,208 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// janitorBatchSize caps the rows handled per table and run, the rest wait for the next run
+const janitorBatchSize = 200
+
+// JanitorOptions configures the pending upload janitor
+type JanitorOptions struct {
+	Interval  time.Duration // time between runs
+	Grace     time.Duration // added to the upload URL expiry before a pending row is stale
+	Retention time.Duration // abandoned rows are deleted this long after they failed
+}
+
+// JanitorOptionsFromEnv reads JANITOR_INTERVAL, JANITOR_GRACE and JANITOR_RETENTION
+func JanitorOptionsFromEnv() (JanitorOptions, error) {
+	opts := JanitorOptions{
+		Interval:  time.Hour,
+		Grace:     time.Hour,
+		Retention: 7 * 24 * time.Hour,
+	}
+	for name, target := range map[string]*time.Duration{
+		"JANITOR_INTERVAL":  &opts.Interval,
+		"JANITOR_GRACE":     &opts.Grace,
+		"JANITOR_RETENTION": &opts.Retention,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = d
+	}
+	return opts, nil
+}
+
+// JanitorStats counts what one janitor run did
+type JanitorStats struct {
+	Scanned  int // stale pending rows looked at
+	Promoted int // pending rows whose object was in storage, now completed
+	Failed   int // pending rows without a valid object, now failed
+	Deleted  int // abandoned rows past retention, deleted with their objects
+	Errors   int
+}
+
+// Janitor resolves pending media rows whose client never called complete, and deletes
+// abandoned rows once the retention window has passed
+type Janitor struct {
+	opts JanitorOptions
+}
+
+func NewJanitor(opts JanitorOptions) *Janitor {
+	return &Janitor{opts: opts}
+}
+
+// Run runs the janitor every Interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.opts.Interval)
+	defer ticker.Stop()
+	for {
+		j.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce does a single pass over every media table and logs what it did
+func (j *Janitor) RunOnce(ctx context.Context) JanitorStats {
+	started := time.Now()
+	var total JanitorStats
+	for _, cfg := range types.MediaConfigs {
+		var stats JanitorStats
+		j.sweepPending(ctx, cfg, &stats)
+		j.purgeAbandoned(ctx, cfg, &stats)
+		if stats != (JanitorStats{}) {
+			log.Printf("janitor: %s scanned=%d promoted=%d failed=%d deleted=%d errors=%d",
+				cfg.Table, stats.Scanned, stats.Promoted, stats.Failed, stats.Deleted, stats.Errors)
+		}
+		total.Scanned += stats.Scanned
+		total.Promoted += stats.Promoted
+		total.Failed += stats.Failed
+		total.Deleted += stats.Deleted
+		total.Errors += stats.Errors
+	}
+	log.Printf("janitor: run finished in %s: scanned=%d promoted=%d failed=%d deleted=%d errors=%d",
+		time.Since(started).Round(time.Millisecond), total.Scanned, total.Promoted, total.Failed, total.Deleted, total.Errors)
+	return total
+}
+
+// sweepPending promotes or fails pending rows older than their upload expiry plus grace
+func (j *Janitor) sweepPending(ctx context.Context, cfg types.MediaConfig, stats *JanitorStats) {
+	now := time.Now()
+
+	// Resumable uploads stay open far longer than a single PUT. The query leaves out the
+	// ones still inside their window, so they can't fill the batch ahead of expired rows.
+	stale, err := clients.Media.ListStalePending(ctx, cfg,
+		now.Add(-uploadURLExpiry-j.opts.Grace), now.Add(-multipartUploadExpiry-j.opts.Grace), janitorBatchSize)
+	if err != nil {
+		log.Printf("janitor: failed to list pending %s: %v", cfg.Table, err)
+		stats.Errors++
+		return
+	}
+
+	for i := range stale {
+		stats.Scanned++
+		videoId := stale[i].ID
+
+		// The rows come with their parent, nil if it's gone and there's no key to check
+		media, err := repository.PolicyMedia(cfg, &stale[i])
+		if err != nil {
+			if markFailed(ctx, cfg, videoId, stale[i].Row(cfg), "upload abandoned and parent record no longer exists") {
+				log.Printf("janitor: failed %s %s, parent record missing", cfg.Table, videoId)
+				stats.Failed++
+			}
+			continue
+		}
+
+		outcome, reason, err := completeUpload(ctx, cfg, media)
+		switch {
+		case err != nil:
+			log.Printf("janitor: failed to promote %s %s: %v", cfg.Table, videoId, err)
+			stats.Errors++
+		case outcome == verifyOK:
+			log.Printf("janitor: promoted %s %s, object was uploaded but never completed", cfg.Table, videoId)
+			stats.Promoted++
+		case outcome == verifyFailed:
+			log.Printf("janitor: failed %s %s: %s", cfg.Table, videoId, reason)
+			j.deleteObjects(ctx, cfg, media)
+			stats.Failed++
+		default:
+			// Storage couldn't be checked, try again next run
+			log.Printf("janitor: skipped %s %s: %s", cfg.Table, videoId, reason)
+			stats.Errors++
+		}
+	}
+}
+
+// purgeAbandoned deletes rows that failed before their upload completed, once they've
+// been failed for the retention window. Rows failed by the worker are kept since their
+// video exists and can still be trimmed or retried.
+func (j *Janitor) purgeAbandoned(ctx context.Context, cfg types.MediaConfig, stats *JanitorStats) {
+	before := time.Now().Add(-j.opts.Retention)
+	abandoned, err := clients.Media.ListMediaByStatus(ctx, cfg,
+		repository.StatusFilter{UploadStatus: "failed", UpdatedBefore: before, NoFailedStep: true}, janitorBatchSize)
+	if err != nil {
+		log.Printf("janitor: failed to list abandoned %s: %v", cfg.Table, err)
+		stats.Errors++
+		return
+	}
+
+	for i := range abandoned {
+		videoId := abandoned[i].ID
+		// Without a parent record there's no key, so only the row is left to delete
+		if media, err := repository.PolicyMedia(cfg, &abandoned[i]); err == nil {
+			j.deleteObjects(ctx, cfg, media)
+		}
+
+		deleted, err := clients.Media.DeleteMedia(ctx, cfg, videoId, "failed")
+		if err != nil {
+			log.Printf("janitor: failed to delete %s %s: %v", cfg.Table, videoId, err)
+			stats.Errors++
+			continue
+		}
+		if deleted {
+			log.Printf("janitor: deleted abandoned %s %s", cfg.Table, videoId)
+			stats.Deleted++
+		}
+	}
+}
+
+// deleteObjects removes whatever part of an upload made it into storage
+func (j *Janitor) deleteObjects(ctx context.Context, cfg types.MediaConfig, media *policy.Media) {
+	key := cfg.VideoKey(media.ParentID, media.OwnerID, media.ID)
+	if uploadID := multipartUploadID(media.Row); uploadID != "" {
+		if store, ok := clients.Storage.(storage.MultipartStore); ok {
+			if err := store.AbortMultipartUpload(ctx, key, uploadID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("janitor: failed to abort multipart upload %s: %v", uploadID, err)
+			}
+		}
+	}
+	for _, prefix := range []string{key + "/", tusChunkPrefix + "/" + media.ID + "/"} {
+		if _, err := clients.Storage.DeletePrefix(ctx, prefix); err != nil {
+			log.Printf("janitor: failed to delete %s: %v", prefix, err)
+		}
+	}
+	if err := clients.Storage.Delete(ctx, key); err != nil {
+		log.Printf("janitor: failed to delete %s: %v", key, err)
+	}
+}
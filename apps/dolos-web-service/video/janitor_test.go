@@ -0,0 +1,89 @@
+package video
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestSweepPending(t *testing.T) {
+	records := repository.NewMemory()
+	store := storage.NewMemoryStore()
+	previousMedia, previousStorage := clients.Media, clients.Storage
+	clients.Media, clients.Storage = records, store
+	defer func() { clients.Media, clients.Storage = previousMedia, previousStorage }()
+
+	ctx := context.Background()
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	now := time.Now()
+	records.AddParent(cfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	add := func(id string, age time.Duration, metadata map[string]interface{}) {
+		size, mimeType := int64(5), "video/mp4"
+		if err := records.AddMedia(cfg, repository.Media{ID: id, ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "pending",
+			FileSizeBytes: &size, MimeType: &mimeType, Metadata: metadata, CreatedAt: now.Add(-age).UTC().Format(time.RFC3339)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tus := map[string]interface{}{"tus": map[string]interface{}{}}
+	multipart := map[string]interface{}{"multipart": map[string]interface{}{"uploadId": "u1"}}
+
+	// A full batch of resumable uploads still inside their window comes first
+	for i := 0; i < janitorBatchSize; i++ {
+		add(fmt.Sprintf("resumable-%03d", i), 3*time.Hour+time.Duration(i)*time.Second, tus)
+	}
+	add("fresh", 30*time.Minute, nil)
+	add("multipart-open", 24*time.Hour, multipart)
+	add("never-uploaded", 90*time.Minute, nil)
+	add("uploaded", 2*time.Hour, nil)
+	add("tus-expired", 26*time.Hour, tus)
+	if err := store.Put(ctx, cfg.VideoKey("trick-1", "alice", "uploaded"), strings.NewReader("video"), 5, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	janitor := &Janitor{opts: JanitorOptions{Grace: time.Hour}}
+	var stats JanitorStats
+	janitor.sweepPending(ctx, cfg, &stats)
+	if stats != (JanitorStats{Scanned: 3, Promoted: 1, Failed: 2}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	for id, want := range map[string]string{
+		"resumable-000":  "pending",
+		"fresh":          "pending",
+		"multipart-open": "pending",
+		"never-uploaded": "failed",
+		"uploaded":       "completed",
+		"tus-expired":    "failed",
+	} {
+		media, err := records.GetMedia(ctx, cfg, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if media.UploadStatus != want {
+			t.Errorf("%s: expected %s, got %s", id, want, media.UploadStatus)
+		}
+	}
+}
+
+func TestJanitorOptionsFromEnv(t *testing.T) {
+	t.Setenv("JANITOR_GRACE", "30m")
+	opts, err := JanitorOptionsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Grace != 30*time.Minute || opts.Interval != time.Hour || opts.Retention != 7*24*time.Hour {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+
+	t.Setenv("JANITOR_RETENTION", "forever")
+	if _, err := JanitorOptionsFromEnv(); err == nil {
+		t.Fatal("expected an invalid duration to fail")
+	}
+}
@@ -0,0 +1,96 @@
+package video
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// LinkLogCore links a video to a log of its parent trick or combo, or unlinks it when
+// logID is nil
+func LinkLogCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string, logID *string) {
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionLink, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	var value interface{}
+	if logID != nil {
+		if !checkLog(c, cfg, *logID, []string{media.ParentRecordID}) {
+			return
+		}
+		value = *logID
+	}
+
+	updateData := map[string]interface{}{
+		cfg.LogKey:   value,
+		"updated_at": time.Now().Format(time.RFC3339),
+	}
+	updated, err := clients.Media.UpdateMedia(c.Request.Context(), cfg, videoId, "", updateData)
+	if err != nil {
+		log.Printf("Failed to link %s %s to a log: %v", cfg.Table, videoId, err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to link log", "details": err.Error()})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Video not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"videoId": videoId,
+		"logId":   logID,
+	})
+}
+
+// checkUploadLog checks that the log an upload links to belongs to the uploader's parent
+// record. An empty logID is always fine.
+func checkUploadLog(c *gin.Context, cfg types.MediaConfig, logID string, parentID string, userID string) bool {
+	if logID == "" {
+		return true
+	}
+	parents, err := clients.Parents.FindParents(c.Request.Context(), cfg, parentID, userID)
+	if err != nil {
+		log.Printf("Failed to fetch %s for log check: %v", cfg.ParentTable, err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to verify log", "details": err.Error()})
+		return false
+	}
+	// A trick the user has no link to yet can't have logs of theirs
+	parentRecordIDs := make([]string, len(parents))
+	for i, parent := range parents {
+		parentRecordIDs[i] = parent.ID
+	}
+	return checkLog(c, cfg, logID, parentRecordIDs)
+}
+
+// checkLog checks that a log exists and belongs to one of the parent records. Parent
+// records are per user, so this ties the log to both the user and the trick or combo.
+func checkLog(c *gin.Context, cfg types.MediaConfig, logID string, parentRecordIDs []string) bool {
+	found, err := clients.Logs.GetLog(c.Request.Context(), cfg, logID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Log not found"})
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to fetch %s %s: %v", cfg.LogTable, logID, err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to verify log", "details": err.Error()})
+		return false
+	}
+
+	for _, id := range parentRecordIDs {
+		if found.ParentRecordID == id {
+			return true
+		}
+	}
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Log belongs to a different user, trick or combo"})
+	return false
+}
@@ -0,0 +1,307 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+const (
+	// multipartPartSize is the part size clients should use, S3 requires at least 5MB
+	multipartPartSize = 8 * 1024 * 1024
+	// multipartPartURLExpiry is how long a presigned part URL stays valid
+	multipartPartURLExpiry = time.Hour
+	// multipartUploadExpiry is how long a multipart upload may stay open before it is aborted
+	multipartUploadExpiry = 24 * time.Hour
+)
+
+// multipartStore returns the configured store if it supports multipart uploads
+func multipartStore(c *gin.Context) (storage.MultipartStore, bool) {
+	store, ok := clients.Storage.(storage.MultipartStore)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": storage.ErrMultipartUnsupported.Error()})
+	}
+	return store, ok
+}
+
+// multipartUploadID returns the multipart upload ID recorded in a media row's metadata
+func multipartUploadID(row map[string]interface{}) string {
+	metadata, _ := row["metadata"].(map[string]interface{})
+	multipart, _ := metadata["multipart"].(map[string]interface{})
+	uploadID, _ := multipart["uploadId"].(string)
+	return uploadID
+}
+
+// RequestMultipartUploadCore creates a pending media row backed by a multipart upload
+func RequestMultipartUploadCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, parentID string, userID string, fileSize int64, mimeType string, duration *float64, thumbnailTimeMs *int64, logID string) {
+	if err := clients.Policy.AuthorizeRequest(c.Request.Context(), caller, cfg, parentID, userID); err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	if !validateUpload(c, fileSize, mimeType) {
+		return
+	}
+	if !checkUploadLog(c, cfg, logID, parentID, userID) {
+		return
+	}
+
+	store, ok := multipartStore(c)
+	if !ok {
+		return
+	}
+
+	videoId := uuid.New().String()
+	key := cfg.VideoKey(parentID, userID, videoId)
+
+	uploadID, err := store.CreateMultipartUpload(c.Request.Context(), key, mimeType)
+	if err != nil {
+		log.Printf("Failed to create multipart upload: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create multipart upload", "details": err.Error()})
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"multipart": map[string]interface{}{
+			"uploadId":  uploadID,
+			"partSize":  multipartPartSize,
+			"createdAt": time.Now().Format(time.RFC3339),
+		},
+	}
+	if !createPendingRecord(c, cfg, parentID, userID, videoId, key, fileSize, mimeType, duration, logID, withThumbnailTime(metadata, thumbnailTimeMs)) {
+		// Don't leave an orphaned multipart upload behind
+		if err := store.AbortMultipartUpload(context.Background(), key, uploadID); err != nil {
+			log.Printf("Failed to abort multipart upload %s: %v", uploadID, err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, types.MultipartUploadResponse{
+		VideoID:   videoId,
+		UploadID:  uploadID,
+		PartSize:  multipartPartSize,
+		PartCount: int((fileSize + multipartPartSize - 1) / multipartPartSize),
+		ExpiresAt: time.Now().Add(multipartUploadExpiry).Format(time.RFC3339),
+	})
+}
+
+// PresignMultipartPartsCore returns presigned URLs for the requested parts
+func PresignMultipartPartsCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string, partNumbers []int32) {
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionComplete, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	uploadID, key, ok := pendingMultipart(c, cfg, media)
+	if !ok {
+		return
+	}
+
+	store, ok := multipartStore(c)
+	if !ok {
+		return
+	}
+
+	parts := make([]types.MultipartPartURL, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		if partNumber < 1 || partNumber > 10000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid part number %d", partNumber)})
+			return
+		}
+		url, err := store.PresignUploadPart(c.Request.Context(), key, uploadID, partNumber, multipartPartURLExpiry)
+		if err != nil {
+			log.Printf("Failed to presign part %d of %s: %v", partNumber, uploadID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate part upload URL", "details": err.Error()})
+			return
+		}
+		parts = append(parts, types.MultipartPartURL{PartNumber: partNumber, UploadURL: url})
+	}
+
+	c.JSON(http.StatusOK, types.MultipartPartsResponse{
+		Parts:     parts,
+		ExpiresAt: time.Now().Add(multipartPartURLExpiry).Format(time.RFC3339),
+	})
+}
+
+// CompleteMultipartUploadCore assembles the uploaded parts and then completes the upload
+// the same way CompleteUploadCore does
+func CompleteMultipartUploadCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string, parts []types.MultipartCompletedPart) {
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionComplete, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	// Retrying a complete after the parts were assembled should just finish the upload
+	if media.Row["upload_status"] != "pending" {
+		finishUpload(c, cfg, media)
+		return
+	}
+
+	uploadID, key, ok := pendingMultipart(c, cfg, media)
+	if !ok {
+		return
+	}
+
+	store, ok := multipartStore(c)
+	if !ok {
+		return
+	}
+
+	completed := make([]storage.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, storage.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i].PartNumber < completed[j].PartNumber })
+
+	if err := store.CompleteMultipartUpload(c.Request.Context(), key, uploadID, completed); err != nil {
+		// Already assembled by an earlier attempt whose response was lost
+		if _, headErr := clients.Storage.Head(c.Request.Context(), key); headErr != nil {
+			log.Printf("Failed to complete multipart upload %s: %v", uploadID, err)
+			c.JSON(http.StatusConflict, gin.H{"error": "Failed to assemble uploaded parts", "details": err.Error(), "retryable": true})
+			return
+		}
+	}
+
+	finishUpload(c, cfg, media)
+}
+
+// AbortMultipartUploadCore aborts a multipart upload and marks its media row failed
+func AbortMultipartUploadCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string) {
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionDelete, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	uploadID, key, ok := pendingMultipart(c, cfg, media)
+	if !ok {
+		return
+	}
+
+	store, ok := multipartStore(c)
+	if !ok {
+		return
+	}
+
+	if err := store.AbortMultipartUpload(c.Request.Context(), key, uploadID); err != nil {
+		log.Printf("Failed to abort multipart upload %s: %v", uploadID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to abort upload", "details": err.Error()})
+		return
+	}
+
+	markFailed(c.Request.Context(), cfg, videoId, media.Row, "multipart upload aborted by client")
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "videoId": videoId})
+}
+
+// pendingMultipart returns the upload ID and key for a pending multipart media row
+func pendingMultipart(c *gin.Context, cfg types.MediaConfig, media *policy.Media) (string, string, bool) {
+	uploadID := multipartUploadID(media.Row)
+	if uploadID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Video was not created as a multipart upload"})
+		return "", "", false
+	}
+	if media.Row["upload_status"] != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is no longer pending", "retryable": false})
+		return "", "", false
+	}
+	key := cfg.VideoKey(media.ParentID, media.OwnerID, media.ID)
+	return uploadID, key, true
+}
+
+// CleanupAbandonedMultipartUploads aborts multipart uploads older than maxAge and marks
+// their media rows failed. It returns the number of uploads aborted.
+func CleanupAbandonedMultipartUploads(ctx context.Context, maxAge time.Duration) (int, error) {
+	store, ok := clients.Storage.(storage.MultipartStore)
+	if !ok {
+		return 0, nil
+	}
+
+	aborted := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, cfg := range types.MediaConfigs {
+		uploads, err := store.ListMultipartUploads(ctx, cfg.PathPrefix+"/")
+		if err != nil {
+			return aborted, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+
+		for _, upload := range uploads {
+			if upload.Initiated.After(cutoff) {
+				continue
+			}
+			if err := store.AbortMultipartUpload(ctx, upload.Key, upload.UploadID); err != nil {
+				log.Printf("Failed to abort abandoned multipart upload %s: %v", upload.UploadID, err)
+				continue
+			}
+			aborted++
+
+			// The video ID is the last segment of the key
+			uploadID := upload.UploadID
+			failAbandoned(ctx, path.Base(upload.Key), func(row map[string]interface{}) bool {
+				return multipartUploadID(row) == uploadID
+			}, "multipart upload abandoned")
+		}
+	}
+	return aborted, nil
+}
+
+// failAbandoned marks a still-pending media row failed after its upload was cleaned up.
+// The row is looked up in every media table since storage keys don't always carry the type.
+func failAbandoned(ctx context.Context, videoId string, match func(row map[string]interface{}) bool, reason string) {
+	for _, cfg := range types.MediaConfigs {
+		media, err := clients.Media.GetMedia(ctx, cfg, videoId)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to fetch %s %s: %v", cfg.Table, videoId, err)
+			continue
+		}
+		if row := media.Row(cfg); media.UploadStatus == "pending" && match(row) {
+			markFailed(ctx, cfg, videoId, row, reason)
+		}
+		return
+	}
+}
+
+// RunUploadCleanup periodically removes abandoned multipart and tus uploads until ctx is done
+func RunUploadCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		aborted, err := CleanupAbandonedMultipartUploads(ctx, multipartUploadExpiry)
+		if err != nil {
+			log.Printf("Multipart cleanup failed: %v", err)
+		} else if aborted > 0 {
+			log.Printf("Multipart cleanup aborted %d abandoned uploads", aborted)
+		}
+
+		removed, err := CleanupAbandonedTusUploads(ctx, multipartUploadExpiry)
+		if err != nil {
+			log.Printf("tus cleanup failed: %v", err)
+		} else if removed > 0 {
+			log.Printf("tus cleanup removed %d abandoned uploads", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
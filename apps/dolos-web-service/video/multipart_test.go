@@ -0,0 +1,51 @@
+package video
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestCleanupAbandonedMultipartUploads(t *testing.T) {
+	records := repository.NewMemory()
+	store := storage.NewMemoryStore()
+	previousMedia, previousStorage := clients.Media, clients.Storage
+	clients.Media, clients.Storage = records, store
+	defer func() { clients.Media, clients.Storage = previousMedia, previousStorage }()
+
+	ctx := context.Background()
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	records.AddParent(cfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	start := func(id, status string) string {
+		uploadID, err := store.CreateMultipartUpload(ctx, cfg.VideoKey("trick-1", "alice", id), "video/mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := map[string]interface{}{"multipart": map[string]interface{}{"uploadId": uploadID}}
+		if err := records.AddMedia(cfg, repository.Media{ID: id, ParentRecordID: "ut-1", MediaType: "video", UploadStatus: status, Metadata: metadata}); err != nil {
+			t.Fatal(err)
+		}
+		return uploadID
+	}
+	start("abandoned", "pending")
+	// Completed by the client while its upload was still listed
+	start("completed", "completed")
+	// A row whose upload was replaced keeps its own
+	start("replaced", "pending")
+	records.UpdateMedia(ctx, cfg, "replaced", "", map[string]interface{}{"metadata": map[string]interface{}{"multipart": map[string]interface{}{"uploadId": "newer"}}})
+
+	aborted, err := CleanupAbandonedMultipartUploads(ctx, 0)
+	if err != nil || aborted != 3 {
+		t.Fatalf("expected 3 aborted uploads, got %d, %v", aborted, err)
+	}
+	if uploads, _ := store.ListMultipartUploads(ctx, ""); len(uploads) != 0 {
+		t.Fatalf("expected every upload to be aborted, got %+v", uploads)
+	}
+	for id, want := range map[string]string{"abandoned": "failed", "completed": "completed", "replaced": "pending"} {
+		assertStatus(t, records, cfg, id, want)
+	}
+}
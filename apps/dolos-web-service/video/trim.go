@@ -0,0 +1,124 @@
+package video
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// minTrimLength is the shortest clip a trim can leave
+const minTrimLength = 500 // ms
+
+// processingFields are the metadata keys written by the worker when it finishes a job
+var processingFields = []string{"processed_at", "failure_reason", "failed_step", "failed_at", "trim_failure"}
+
+// TrimCore sets the trim points of a video and sends it back through the worker, which
+// cuts the stored video to that range
+func TrimCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string, startMs, endMs *int64) {
+	if reason := validateTrim(startMs, endMs); reason != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+		return
+	}
+
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, policy.ActionTrim, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	if media.Row["media_type"] == "image" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only videos can be trimmed"})
+		return
+	}
+
+	// Only videos that reached the worker have a stored object to cut. Failed rows
+	// qualify too, so a video rejected as too long can be trimmed to fit.
+	status, _ := media.Row["upload_status"].(string)
+	metadata, _ := media.Row["metadata"].(map[string]interface{})
+	switch {
+	case status == "completed":
+	case status == "failed" && metadata["failed_step"] != nil:
+	case status == "failed":
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload never completed"})
+		return
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Video is still uploading or processing"})
+		return
+	}
+
+	// Once a trim is applied duration_seconds is the cut's length, not the upload's, so
+	// a re-trim's start is left to the worker
+	if duration, ok := media.Row["duration_seconds"].(float64); ok && metadata["trim"] == nil && startMs != nil && float64(*startMs) >= duration*1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "trimStartMs is past the end of the video"})
+		return
+	}
+
+	// Clearing processed_at queues the row for the worker again
+	cleared := map[string]interface{}{}
+	for k, v := range metadata {
+		cleared[k] = v
+	}
+	for _, field := range processingFields {
+		delete(cleared, field)
+	}
+	// A video that's up keeps its current cut if the new one fails, see Worker.finish
+	delete(cleared, "previous_trim")
+	if status == "completed" {
+		cleared["previous_trim"] = map[string]interface{}{
+			"start_ms": media.Row["trim_start_ms"],
+			"end_ms":   media.Row["trim_end_ms"],
+		}
+	}
+
+	updateData := map[string]interface{}{
+		"trim_start_ms": startMs,
+		"trim_end_ms":   endMs,
+		"upload_status": "completed",
+		"metadata":      cleared,
+		"updated_at":    time.Now().Format(time.RFC3339),
+	}
+
+	// Conditional on the status we checked, so a worker that claimed the row meanwhile wins
+	updated, err := clients.Media.UpdateMedia(c.Request.Context(), cfg, videoId, status, updateData)
+	if err != nil {
+		log.Printf("Failed to set trim on %s %s: %v", cfg.Table, videoId, err)
+		c.JSON(repository.HTTPStatus(err), gin.H{"error": "Failed to save trim", "details": err.Error()})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusConflict, gin.H{"error": "Video is being processed, try again later"})
+		return
+	}
+
+	if Processor != nil {
+		Processor.Enqueue(cfg, videoId)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":     true,
+		"videoId":     videoId,
+		"trimStartMs": startMs,
+		"trimEndMs":   endMs,
+	})
+}
+
+// validateTrim returns why the trim points are invalid, or "" if they're fine
+func validateTrim(startMs, endMs *int64) string {
+	if startMs != nil && *startMs < 0 {
+		return "trimStartMs must not be negative"
+	}
+	if endMs != nil && *endMs <= 0 {
+		return "trimEndMs must be positive"
+	}
+	if startMs != nil && endMs != nil && *endMs-*startMs < minTrimLength {
+		return fmt.Sprintf("Trimmed video must be at least %dms long", minTrimLength)
+	}
+	return ""
+}
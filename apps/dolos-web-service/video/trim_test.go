@@ -0,0 +1,25 @@
+package video
+
+import "testing"
+
+func TestValidateTrim(t *testing.T) {
+	ms := func(v int64) *int64 { return &v }
+	cases := []struct {
+		start, end *int64
+		valid      bool
+	}{
+		{nil, nil, true}, // removes the trim
+		{ms(0), ms(5000), true},
+		{ms(1000), nil, true},
+		{nil, ms(3000), true},
+		{ms(-1), nil, false},
+		{nil, ms(0), false},
+		{ms(2000), ms(2200), false}, // too short
+		{ms(3000), ms(1000), false},
+	}
+	for _, tc := range cases {
+		if reason := validateTrim(tc.start, tc.end); (reason == "") != tc.valid {
+			t.Errorf("validateTrim(%v, %v) = %q, expected valid=%v", tc.start, tc.end, reason, tc.valid)
+		}
+	}
+}
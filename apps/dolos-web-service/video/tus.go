@@ -0,0 +1,478 @@
+package video
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+const (
+	// TusVersion is the only tus protocol version the service speaks
+	TusVersion = "1.0.0"
+	// TusExtensions lists the supported tus extensions
+	TusExtensions = "creation,termination,checksum"
+	// TusChecksumAlgorithms lists the algorithms accepted in Upload-Checksum
+	TusChecksumAlgorithms = "md5,sha1,sha256"
+	// TusMaxSize matches the limit enforced for presigned uploads
+	TusMaxSize = 100 * 1024 * 1024
+
+	// tusChunkPrefix holds uploaded chunks until the upload is assembled, outside the media prefixes
+	tusChunkPrefix = "uploads/tus"
+
+	// statusChecksumMismatch is the tus checksum extension's status code
+	statusChecksumMismatch = 460
+)
+
+var tusChecksums = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// tusLocks serializes PATCH requests per upload so offsets can't interleave
+var tusLocks sync.Map
+
+// ParseTusMetadata decodes an Upload-Metadata header into key/value pairs
+func ParseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", fields[0])
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata, nil
+}
+
+// isTusUpload reports whether a media row was created through the tus endpoint
+func isTusUpload(row map[string]interface{}) bool {
+	metadata, _ := row["metadata"].(map[string]interface{})
+	_, ok := metadata["tus"]
+	return ok
+}
+
+// TusLocation is the upload URL path returned to tus clients
+func TusLocation(basePath string, videoType types.VideoType, videoId string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(basePath, "/"), videoType, videoId)
+}
+
+// TusCreateCore creates a pending media row for a tus upload (creation extension)
+func TusCreateCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, location func(videoId string) string, parentID string, userID string, length int64, mimeType string, duration *float64, thumbnailTimeMs *int64, logID string) {
+	if err := clients.Policy.AuthorizeRequest(c.Request.Context(), caller, cfg, parentID, userID); err != nil {
+		policy.Respond(c, err)
+		return
+	}
+
+	if length > TusMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File size exceeds 100MB limit"})
+		return
+	}
+	if !validateUpload(c, length, mimeType) {
+		return
+	}
+	if !checkUploadLog(c, cfg, logID, parentID, userID) {
+		return
+	}
+
+	videoId := uuid.New().String()
+	key := cfg.VideoKey(parentID, userID, videoId)
+
+	metadata := map[string]interface{}{
+		"tus": map[string]interface{}{
+			"createdAt": time.Now().Format(time.RFC3339),
+		},
+	}
+	if !createPendingRecord(c, cfg, parentID, userID, videoId, key, length, mimeType, duration, logID, withThumbnailTime(metadata, thumbnailTimeMs)) {
+		return
+	}
+
+	c.Header("Location", location(videoId))
+	c.Status(http.StatusCreated)
+}
+
+// TusOffsetCore reports how much of a tus upload the server has (HEAD)
+func TusOffsetCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string) {
+	media, ok := authorizeTus(c, cfg, caller, policy.ActionComplete, videoId)
+	if !ok {
+		return
+	}
+
+	length := rowSize(media.Row)
+	var offset int64
+	switch media.Row["upload_status"] {
+	case "pending":
+		var err error
+		if offset, _, err = tusOffset(c.Request.Context(), videoId); err != nil {
+			log.Printf("Failed to read tus offset for %s: %v", videoId, err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	case "failed":
+		c.Status(http.StatusGone)
+		return
+	default:
+		offset = length
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(length, 10))
+	c.Status(http.StatusOK)
+}
+
+// TusPatchCore appends a chunk to a tus upload. Once the last byte arrives the chunks are
+// assembled into the media key and the row is completed like CompleteUploadCore does.
+func TusPatchCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string, offset int64, checksum string) {
+	media, ok := authorizeTus(c, cfg, caller, policy.ActionComplete, videoId)
+	if !ok {
+		return
+	}
+
+	length := rowSize(media.Row)
+	switch media.Row["upload_status"] {
+	case "pending":
+	case "failed":
+		c.JSON(http.StatusGone, gin.H{"error": "Upload has already failed"})
+		return
+	default:
+		// A retried final PATCH after the upload was completed
+		if offset == length {
+			c.Header("Upload-Offset", strconv.FormatInt(length, 10))
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is already complete"})
+		return
+	}
+
+	newHash, expected, err := parseTusChecksum(checksum)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lock, _ := tusLocks.LoadOrStore(videoId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	ctx := c.Request.Context()
+	current, _, err := tusOffset(ctx, videoId)
+	if err != nil {
+		log.Printf("Failed to read tus offset for %s: %v", videoId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read upload offset"})
+		return
+	}
+	if offset != current {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Upload-Offset %d does not match current offset %d", offset, current)})
+		return
+	}
+
+	// Spool the chunk to disk so a large PATCH isn't held in memory
+	tmp, err := os.CreateTemp("", "tus-chunk-*")
+	if err != nil {
+		log.Printf("Failed to create temp file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk"})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var sum hash.Hash
+	writer := io.Writer(tmp)
+	if newHash != nil {
+		sum = newHash()
+		writer = io.MultiWriter(tmp, sum)
+	}
+
+	remaining := length - offset
+	written, readErr := io.Copy(writer, io.LimitReader(c.Request.Body, remaining+1))
+	if written > remaining {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Chunk exceeds Upload-Length"})
+		return
+	}
+	if readErr != nil {
+		// Keep what arrived of an interrupted PATCH, unless it can't be checksummed
+		log.Printf("tus PATCH for %s interrupted after %d bytes: %v", videoId, written, readErr)
+		if sum != nil || written == 0 {
+			return
+		}
+	}
+	if sum != nil && string(sum.Sum(nil)) != string(expected) {
+		c.JSON(statusChecksumMismatch, gin.H{"error": "Checksum Mismatch"})
+		return
+	}
+
+	if written > 0 {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk"})
+			return
+		}
+		if err := clients.Storage.Put(ctx, tusChunkKey(videoId, offset), tmp, written, "application/octet-stream"); err != nil {
+			log.Printf("Failed to store tus chunk for %s: %v", videoId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store chunk", "details": err.Error()})
+			return
+		}
+	}
+	if readErr != nil {
+		return
+	}
+
+	newOffset := offset + written
+	if newOffset == length {
+		if !finishTusUpload(c, cfg, media) {
+			return
+		}
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// TusTerminateCore discards a tus upload and marks its row failed (termination extension)
+func TusTerminateCore(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, videoId string) {
+	media, ok := authorizeTus(c, cfg, caller, policy.ActionDelete, videoId)
+	if !ok {
+		return
+	}
+
+	switch media.Row["upload_status"] {
+	case "completed", "processing":
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload is already complete, delete the video instead"})
+		return
+	}
+
+	if _, err := clients.Storage.DeletePrefix(c.Request.Context(), tusChunkPrefix+"/"+videoId+"/"); err != nil {
+		log.Printf("Failed to delete tus chunks for %s: %v", videoId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate upload"})
+		return
+	}
+	if media.Row["upload_status"] == "pending" {
+		markFailed(c.Request.Context(), cfg, videoId, media.Row, "tus upload terminated by client")
+	}
+	tusLocks.Delete(videoId)
+
+	c.Status(http.StatusNoContent)
+}
+
+// authorizeTus loads a media row for a tus request and checks it was created through tus
+func authorizeTus(c *gin.Context, cfg types.MediaConfig, caller policy.Caller, action policy.Action, videoId string) (*policy.Media, bool) {
+	media, err := clients.Policy.AuthorizeMedia(c.Request.Context(), caller, cfg, action, videoId)
+	if err != nil {
+		policy.Respond(c, err)
+		return nil, false
+	}
+	if !isTusUpload(media.Row) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	return media, true
+}
+
+// finishTusUpload assembles the chunks into the media key and completes the row
+func finishTusUpload(c *gin.Context, cfg types.MediaConfig, media *policy.Media) bool {
+	ctx := c.Request.Context()
+	key := cfg.VideoKey(media.ParentID, media.OwnerID, media.ID)
+	mimeType, _ := media.Row["mime_type"].(string)
+
+	chunks, err := assembleTusChunks(ctx, media.ID, key, rowSize(media.Row), mimeType)
+	if err != nil {
+		log.Printf("Failed to assemble tus upload %s: %v", media.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assemble upload", "details": err.Error()})
+		return false
+	}
+
+	outcome, reason, err := completeUpload(ctx, cfg, media)
+	if err != nil || outcome == verifyPending {
+		// Keep the chunks so the resent final chunk assembles the upload again
+		dropLastChunk(ctx, chunks)
+		log.Printf("Failed to complete tus upload %s: %v %s", media.ID, err, reason)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete upload"})
+		return false
+	}
+
+	if _, err := clients.Storage.DeletePrefix(ctx, tusChunkPrefix+"/"+media.ID+"/"); err != nil {
+		log.Printf("Failed to delete tus chunks for %s: %v", media.ID, err)
+	}
+	tusLocks.Delete(media.ID)
+
+	if outcome == verifyFailed {
+		log.Printf("Upload %s failed verification: %s", media.ID, reason)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Upload verification failed", "details": reason})
+		return false
+	}
+	return true
+}
+
+// assembleTusChunks concatenates the stored chunks into key and returns the chunk keys.
+// If assembly fails the last chunk is dropped so the client resends it and assembly is retried.
+func assembleTusChunks(ctx context.Context, videoId, key string, length int64, mimeType string) ([]string, error) {
+	offset, chunks, err := tusOffset(ctx, videoId)
+	if err != nil {
+		return nil, err
+	}
+	if offset != length {
+		return nil, fmt.Errorf("have %d of %d bytes", offset, length)
+	}
+
+	// S3 signs the payload hash and rewinds the body on retries, so the chunks are
+	// spooled to a seekable temp file rather than streamed through a MultiReader
+	spool, err := os.CreateTemp("", "tus-"+videoId+"-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	for _, chunk := range chunks {
+		if err := copyChunk(ctx, spool, chunk); err != nil {
+			dropLastChunk(ctx, chunks)
+			return nil, err
+		}
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err := clients.Storage.Put(ctx, key, spool, length, mimeType); err != nil {
+		dropLastChunk(ctx, chunks)
+		return nil, err
+	}
+	return chunks, nil
+}
+
+func copyChunk(ctx context.Context, dst io.Writer, chunk string) error {
+	body, _, err := clients.Storage.Get(ctx, chunk)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(dst, body)
+	return err
+}
+
+func dropLastChunk(ctx context.Context, chunks []string) {
+	if len(chunks) == 0 {
+		return
+	}
+	if err := clients.Storage.Delete(ctx, chunks[len(chunks)-1]); err != nil {
+		log.Printf("Failed to drop tus chunk %s: %v", chunks[len(chunks)-1], err)
+	}
+}
+
+// tusOffset returns the number of contiguous bytes stored for an upload and the chunk keys in order
+func tusOffset(ctx context.Context, videoId string) (int64, []string, error) {
+	objects, err := clients.Storage.List(ctx, tusChunkPrefix+"/"+videoId+"/")
+	if err != nil {
+		return 0, nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	var offset int64
+	var chunks []string
+	for _, obj := range objects {
+		start, err := strconv.ParseInt(path.Base(obj.Key), 10, 64)
+		if err != nil || start != offset {
+			break
+		}
+		offset += obj.Size
+		chunks = append(chunks, obj.Key)
+	}
+	return offset, chunks, nil
+}
+
+// tusChunkKey names a chunk by its zero-padded start offset so keys sort in upload order
+func tusChunkKey(videoId string, offset int64) string {
+	return fmt.Sprintf("%s/%s/%020d", tusChunkPrefix, videoId, offset)
+}
+
+// parseTusChecksum parses an Upload-Checksum header ("<algorithm> <base64 digest>")
+func parseTusChecksum(header string) (func() hash.Hash, []byte, error) {
+	if header == "" {
+		return nil, nil, nil
+	}
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return nil, nil, errors.New("invalid Upload-Checksum header")
+	}
+	newHash, ok := tusChecksums[strings.ToLower(fields[0])]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", fields[0])
+	}
+	digest, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, nil, errors.New("invalid Upload-Checksum digest")
+	}
+	return newHash, digest, nil
+}
+
+func rowSize(row map[string]interface{}) int64 {
+	size, _ := row["file_size_bytes"].(float64)
+	return int64(size)
+}
+
+// CleanupAbandonedTusUploads deletes chunks of tus uploads that haven't received data for
+// maxAge and marks their rows failed. It returns the number of uploads removed.
+func CleanupAbandonedTusUploads(ctx context.Context, maxAge time.Duration) (int, error) {
+	objects, err := clients.Storage.List(ctx, tusChunkPrefix+"/")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tus chunks: %w", err)
+	}
+
+	latest := map[string]time.Time{}
+	for _, obj := range objects {
+		videoId := path.Base(path.Dir(obj.Key))
+		if obj.LastModified.After(latest[videoId]) {
+			latest[videoId] = obj.LastModified
+		}
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for videoId, modified := range latest {
+		if modified.After(cutoff) {
+			continue
+		}
+		if _, err := clients.Storage.DeletePrefix(ctx, tusChunkPrefix+"/"+videoId+"/"); err != nil {
+			log.Printf("Failed to delete abandoned tus upload %s: %v", videoId, err)
+			continue
+		}
+		removed++
+		tusLocks.Delete(videoId)
+		failAbandoned(ctx, videoId, isTusUpload, "tus upload abandoned")
+	}
+	return removed, nil
+}
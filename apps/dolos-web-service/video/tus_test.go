@@ -0,0 +1,214 @@
+package video
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	header := "type " + encode("trick") + ",parentId " + encode("t1") + ", filetype " + encode("video/mp4") + ",empty"
+
+	metadata, err := ParseTusMetadata(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata["type"] != "trick" || metadata["parentId"] != "t1" || metadata["filetype"] != "video/mp4" {
+		t.Fatalf("unexpected metadata %v", metadata)
+	}
+	if value, ok := metadata["empty"]; !ok || value != "" {
+		t.Fatalf("expected key without value, got %q (%v)", value, ok)
+	}
+
+	if _, err := ParseTusMetadata("type not-base64!"); err == nil {
+		t.Fatal("expected invalid base64 to be rejected")
+	}
+}
+
+func TestParseTusChecksum(t *testing.T) {
+	sum := sha1.Sum([]byte("chunk"))
+	newHash, digest, err := parseTusChecksum("sha1 " + base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil || newHash == nil || string(digest) != string(sum[:]) {
+		t.Fatalf("unexpected result %v %x", err, digest)
+	}
+
+	if newHash, _, err := parseTusChecksum(""); newHash != nil || err != nil {
+		t.Fatal("expected no checksum for empty header")
+	}
+	if _, _, err := parseTusChecksum("crc32 AAAA"); err == nil {
+		t.Fatal("expected unsupported algorithm to be rejected")
+	}
+}
+
+func TestTusChunks(t *testing.T) {
+	previous := clients.Storage
+	clients.Storage = storage.NewMemoryStore()
+	defer func() { clients.Storage = previous }()
+
+	ctx := context.Background()
+	put := func(offset int64, data string) {
+		if err := clients.Storage.Put(ctx, tusChunkKey("v1", offset), strings.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put(0, "hello ")
+	put(6, "tus ")
+	// A chunk past a gap doesn't count towards the offset
+	put(20, "stray")
+
+	offset, chunks, err := tusOffset(ctx, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != 10 || len(chunks) != 2 {
+		t.Fatalf("expected offset 10 over 2 chunks, got %d %v", offset, chunks)
+	}
+
+	if _, err := assembleTusChunks(ctx, "v1", "tricks/t1/videos/u1/v1", 15, "video/mp4"); err == nil {
+		t.Fatal("expected assembly of an incomplete upload to fail")
+	}
+
+	put(10, "world")
+	if _, err := assembleTusChunks(ctx, "v1", "tricks/t1/videos/u1/v1", 15, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	body, info, err := clients.Storage.Get(ctx, "tricks/t1/videos/u1/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "hello tus world" || info.ContentType != "video/mp4" {
+		t.Fatalf("unexpected assembled object %q %+v", data, info)
+	}
+}
+
+// fakeS3 serves just enough of the S3 API over plain HTTP, path style, for tus assembly.
+// Like S3 it checks the signed payload hash against the body it receives.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/videos/")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		type content struct {
+			Key  string
+			Size int
+		}
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []content
+		}
+		prefix := r.URL.Query().Get("prefix")
+		for k, data := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, content{k, len(data)})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(data)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func TestAssembleTusChunksS3(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := storage.NewS3StoreFromConfig(context.Background(), storage.S3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "videos",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		UsePathStyle:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := clients.Storage
+	clients.Storage = store
+	defer func() { clients.Storage = previous }()
+
+	ctx := context.Background()
+	for offset, data := range map[int64]string{0: "hello ", 6: "tus ", 10: "world"} {
+		if err := clients.Storage.Put(ctx, tusChunkKey("v1", offset), strings.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := assembleTusChunks(ctx, "v1", "tricks/t1/videos/u1/v1", 15, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.objects["tricks/t1/videos/u1/v1"]); got != "hello tus world" {
+		t.Fatalf("unexpected assembled object %q", got)
+	}
+}
+
+func TestCleanupAbandonedTusUploads(t *testing.T) {
+	records := repository.NewMemory()
+	previousMedia, previousStorage := clients.Media, clients.Storage
+	clients.Media, clients.Storage = records, storage.NewMemoryStore()
+	defer func() { clients.Media, clients.Storage = previousMedia, previousStorage }()
+
+	ctx := context.Background()
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	records.AddParent(cfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	if err := records.AddMedia(cfg, repository.Media{ID: "v1", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "pending",
+		Metadata: map[string]interface{}{"tus": map[string]interface{}{}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := clients.Storage.Put(ctx, tusChunkKey("v1", 0), strings.NewReader("hello"), 5, "application/octet-stream"); err != nil {
+		t.Fatal(err)
+	}
+	tusLocks.Store("v1", &sync.Mutex{})
+
+	removed, err := CleanupAbandonedTusUploads(ctx, 0)
+	if err != nil || removed != 1 {
+		t.Fatalf("expected one removed upload, got %d, %v", removed, err)
+	}
+	if _, held := tusLocks.Load("v1"); held {
+		t.Fatal("expected the upload's lock to be released")
+	}
+	assertStatus(t, records, cfg, "v1", "failed")
+}
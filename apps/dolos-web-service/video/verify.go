@@ -0,0 +1,75 @@
+package video
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// verifyOutcome is the result of checking an uploaded object against its media row
+type verifyOutcome int
+
+const (
+	// verifyOK means the object exists and matches the row
+	verifyOK verifyOutcome = iota
+	// verifyPending means the object isn't there yet, the client may retry
+	verifyPending
+	// verifyFailed means the upload can never succeed and the row should be marked failed
+	verifyFailed
+)
+
+// verifyUploadedObject checks the stored object with a Head call. The
+// returned reason explains any non-OK outcome.
+func verifyUploadedObject(ctx context.Context, key string, row map[string]interface{}) (verifyOutcome, string) {
+	head, err := clients.Storage.Head(ctx, key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return verifyPending, fmt.Sprintf("could not check uploaded object: %v", err)
+		}
+		// Once the upload URL has expired the object can never arrive
+		if createdAt, ok := types.RowTime(row, "created_at"); ok && time.Since(createdAt) > uploadExpiry(row) {
+			return verifyFailed, "object was never uploaded before the upload URL expired"
+		}
+		return verifyPending, "object has not been uploaded yet"
+	}
+
+	if expected, ok := row["file_size_bytes"].(float64); ok {
+		actual := head.Size
+		if actual != int64(expected) {
+			return verifyFailed, fmt.Sprintf("uploaded size %d does not match expected size %d", actual, int64(expected))
+		}
+	}
+
+	if expected, ok := row["mime_type"].(string); ok && expected != "" {
+		actual := head.ContentType
+		if normalizeMimeType(actual) != normalizeMimeType(expected) {
+			return verifyFailed, fmt.Sprintf("uploaded content type %q does not match expected %q", actual, expected)
+		}
+	}
+
+	return verifyOK, ""
+}
+
+// uploadExpiry returns how long the client has to get the object into storage.
+// Resumable uploads stay open much longer than a single presigned PUT.
+func uploadExpiry(row map[string]interface{}) time.Duration {
+	if multipartUploadID(row) != "" || isTusUpload(row) {
+		return multipartUploadExpiry
+	}
+	return uploadURLExpiry
+}
+
+func normalizeMimeType(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+	return mediaType
+}
@@ -0,0 +1,109 @@
+package video
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func TestVerifyUploadedObject(t *testing.T) {
+	store := storage.NewMemoryStore()
+	previous := clients.Storage
+	clients.Storage = store
+	defer func() { clients.Storage = previous }()
+
+	ctx := context.Background()
+	put := func(key, data, contentType string) {
+		if err := store.Put(ctx, key, strings.NewReader(data), int64(len(data)), contentType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("ok", "video", "video/mp4")
+	put("short", "vid", "video/mp4")
+	put("with-params", "video", "video/mp4; codecs=\"avc1\"")
+	put("wrong-type", "video", "video/quicktime; charset=binary")
+
+	row := func(age time.Duration, metadata map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"file_size_bytes": float64(5),
+			"mime_type":       "video/mp4",
+			"created_at":      time.Now().Add(-age).UTC().Format(time.RFC3339),
+			"metadata":        metadata,
+		}
+	}
+	tus := map[string]interface{}{"tus": map[string]interface{}{}}
+
+	cases := []struct {
+		name, key string
+		row       map[string]interface{}
+		want      verifyOutcome
+		reason    string
+	}{
+		{"uploaded", "ok", row(time.Minute, nil), verifyOK, ""},
+		{"content type parameters are ignored", "with-params", row(time.Minute, nil), verifyOK, ""},
+		{"missing before expiry", "missing", row(time.Minute, nil), verifyPending, "not been uploaded"},
+		{"missing after expiry", "missing", row(uploadURLExpiry+time.Minute, nil), verifyFailed, "expired"},
+		{"resumable upload still open", "missing", row(uploadURLExpiry+time.Minute, tus), verifyPending, "not been uploaded"},
+		{"size mismatch", "short", row(time.Minute, nil), verifyFailed, "size 3"},
+		{"content type mismatch", "wrong-type", row(time.Minute, nil), verifyFailed, "content type"},
+	}
+	for _, tc := range cases {
+		outcome, reason := verifyUploadedObject(ctx, tc.key, tc.row)
+		if outcome != tc.want || !strings.Contains(reason, tc.reason) {
+			t.Errorf("%s: got %v %q, expected %v containing %q", tc.name, outcome, reason, tc.want, tc.reason)
+		}
+	}
+}
+
+func TestCompleteUploadOnlyMovesPendingRows(t *testing.T) {
+	records := repository.NewMemory()
+	store := storage.NewMemoryStore()
+	previousMedia, previousStorage := clients.Media, clients.Storage
+	clients.Media, clients.Storage = records, store
+	defer func() { clients.Media, clients.Storage = previousMedia, previousStorage }()
+
+	ctx := context.Background()
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	records.AddParent(cfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	size, mimeType := int64(5), "video/mp4"
+	if err := records.AddMedia(cfg, repository.Media{ID: "v1", ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "pending",
+		FileSizeBytes: &size, MimeType: &mimeType}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, cfg.VideoKey("trick-1", "alice", "v1"), strings.NewReader("video"), 5, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := repository.NewPolicyLoader(records, records).Media(ctx, cfg, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker claims the row between the caller loading it and completing it
+	if _, err := records.UpdateMedia(ctx, cfg, "v1", "", map[string]interface{}{"upload_status": "processing"}); err != nil {
+		t.Fatal(err)
+	}
+	if outcome, _, err := completeUpload(ctx, cfg, loaded); err != nil || outcome != verifyOK {
+		t.Fatalf("expected the completed upload to be treated as handled, got %v %v", outcome, err)
+	}
+	if markFailed(ctx, cfg, "v1", loaded.Row, "late janitor") {
+		t.Fatal("expected a claimed row not to be failed")
+	}
+	assertStatus(t, records, cfg, "v1", "processing")
+}
+
+func assertStatus(t *testing.T, records *repository.Memory, cfg types.MediaConfig, id, want string) {
+	t.Helper()
+	media, err := records.GetMedia(context.Background(), cfg, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if media.UploadStatus != want {
+		t.Errorf("%s: expected %s, got %s", id, want, media.UploadStatus)
+	}
+}
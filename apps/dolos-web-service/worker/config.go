@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OptionsFromEnv reads the WORKER_* variables
+func OptionsFromEnv() (Options, error) {
+	opts := Options{
+		Concurrency:  2,
+		StepTimeout:  5 * time.Minute,
+		Retries:      2,
+		RetryBackoff: 10 * time.Second,
+		PollInterval: 30 * time.Second,
+		StaleAfter:   time.Hour,
+	}
+
+	var err error
+	if opts.Concurrency, err = envInt("WORKER_CONCURRENCY", opts.Concurrency); err != nil {
+		return opts, err
+	}
+	if opts.Retries, err = envInt("WORKER_STEP_RETRIES", opts.Retries); err != nil {
+		return opts, err
+	}
+	if opts.StepTimeout, err = envDuration("WORKER_STEP_TIMEOUT", opts.StepTimeout); err != nil {
+		return opts, err
+	}
+	if opts.RetryBackoff, err = envDuration("WORKER_RETRY_BACKOFF", opts.RetryBackoff); err != nil {
+		return opts, err
+	}
+	if opts.PollInterval, err = envDuration("WORKER_POLL_INTERVAL", opts.PollInterval); err != nil {
+		return opts, err
+	}
+	if opts.StaleAfter, err = envDuration("WORKER_STALE_AFTER", opts.StaleAfter); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// ToolsFromEnv reads FFMPEG_PATH, FFPROBE_PATH and VIPS_PATH, defaulting to the binaries on PATH
+func ToolsFromEnv() *FFmpeg {
+	tools := &FFmpeg{FFmpegPath: os.Getenv("FFMPEG_PATH"), FFprobePath: os.Getenv("FFPROBE_PATH"), VipsPath: os.Getenv("VIPS_PATH")}
+	if tools.FFmpegPath == "" {
+		tools.FFmpegPath = "ffmpeg"
+	}
+	if tools.FFprobePath == "" {
+		tools.FFprobePath = "ffprobe"
+	}
+	if tools.VipsPath == "" {
+		tools.VipsPath = "vips"
+	}
+	return tools
+}
+
+// StepsFromEnv reads the comma-separated WORKER_STEPS list, defaulting to DefaultSteps
+func StepsFromEnv() []string {
+	return stepList("WORKER_STEPS", DefaultSteps)
+}
+
+// ImageStepsFromEnv reads the comma-separated WORKER_IMAGE_STEPS list, defaulting to DefaultImageSteps
+func ImageStepsFromEnv() []string {
+	return stepList("WORKER_IMAGE_STEPS", DefaultImageSteps)
+}
+
+func stepList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	return strings.Split(value, ",")
+}
+
+// FromEnv builds a worker and checks ffmpeg is available if either pipeline has steps,
+// and vips if the image pipeline has steps
+func FromEnv() (*Worker, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	tools := ToolsFromEnv()
+	steps, err := BuildPipeline(StepsFromEnv(), tools)
+	if err != nil {
+		return nil, err
+	}
+	imageSteps, err := BuildPipeline(ImageStepsFromEnv(), tools)
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) > 0 || len(imageSteps) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tools.Check(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(imageSteps) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tools.CheckVips(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return New(opts, steps, imageSteps), nil
+}
+
+func envInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return n, nil
+}
+
+// envList reads a comma-separated list, skipping empty entries
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func envDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return d, nil
+}
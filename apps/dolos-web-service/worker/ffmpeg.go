@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// FFmpeg runs the local ffmpeg and ffprobe binaries, and libvips's vips for images
+type FFmpeg struct {
+	FFmpegPath  string
+	FFprobePath string
+	VipsPath    string
+}
+
+// Check makes sure both binaries can be executed
+func (f *FFmpeg) Check(ctx context.Context) error {
+	if _, err := f.Output(ctx, f.FFmpegPath, "-version"); err != nil {
+		return fmt.Errorf("ffmpeg not usable at %q: %w", f.FFmpegPath, err)
+	}
+	if _, err := f.Output(ctx, f.FFprobePath, "-version"); err != nil {
+		return fmt.Errorf("ffprobe not usable at %q: %w", f.FFprobePath, err)
+	}
+	return nil
+}
+
+// CheckVips makes sure vips can be executed
+func (f *FFmpeg) CheckVips(ctx context.Context) error {
+	if _, err := f.Output(ctx, f.VipsPath, "--version"); err != nil {
+		return fmt.Errorf("vips not usable at %q: %w", f.VipsPath, err)
+	}
+	return nil
+}
+
+// Vips runs a vips operation, e.g. Vips(ctx, "autorot", in, out)
+func (f *FFmpeg) Vips(ctx context.Context, operation string, args ...string) error {
+	_, err := f.Output(ctx, f.VipsPath, append([]string{operation}, args...)...)
+	return err
+}
+
+// Run runs ffmpeg with the given arguments
+func (f *FFmpeg) Run(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}, args...)
+	_, err := f.Output(ctx, f.FFmpegPath, args...)
+	return err
+}
+
+// Probe runs ffprobe with the given arguments and returns its stdout
+func (f *FFmpeg) Probe(ctx context.Context, args ...string) ([]byte, error) {
+	args = append([]string{"-hide_banner", "-loglevel", "error"}, args...)
+	return f.Output(ctx, f.FFprobePath, args...)
+}
+
+// Output runs a binary and returns its stdout, including stderr in the error
+func (f *FFmpeg) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
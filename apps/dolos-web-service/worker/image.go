@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func init() {
+	Register("image", func(tools *FFmpeg) (Step, error) {
+		rules, err := ImageRulesFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return &ImageStep{tools: tools, rules: rules}, nil
+	})
+}
+
+// derivativeSize is a resized copy made of every image, by long edge
+type derivativeSize struct {
+	Name     string
+	LongEdge int
+}
+
+// imageDerivatives are the sizes produced for an image, smallest first. The thumb is
+// always made, larger sizes only if they don't upscale.
+var imageDerivatives = []derivativeSize{
+	{"thumb", 320},
+	{"medium", 1080},
+	{"large", 2048},
+}
+
+// ImageRules is the server-side policy uploaded images must meet
+type ImageRules struct {
+	MaxLongEdge  int // pixels, either orientation
+	MinShortEdge int
+}
+
+// ImageRulesFromEnv reads IMAGE_MAX_LONG_EDGE and IMAGE_MIN_SHORT_EDGE
+func ImageRulesFromEnv() (ImageRules, error) {
+	rules := ImageRules{
+		MaxLongEdge:  12000,
+		MinShortEdge: 200,
+	}
+
+	var err error
+	if rules.MaxLongEdge, err = envInt("IMAGE_MAX_LONG_EDGE", rules.MaxLongEdge); err != nil {
+		return rules, err
+	}
+	if rules.MinShortEdge, err = envInt("IMAGE_MIN_SHORT_EDGE", rules.MinShortEdge); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// Check returns a RejectError if an image of this size breaks the rules
+func (r ImageRules) Check(width, height int) error {
+	long, short := width, height
+	if short > long {
+		long, short = short, long
+	}
+	if long > r.MaxLongEdge {
+		return Reject("resolution %dx%d exceeds the %dpx limit", width, height, r.MaxLongEdge)
+	}
+	if short < r.MinShortEdge {
+		return Reject("resolution %dx%d is below the %dpx minimum", width, height, r.MinShortEdge)
+	}
+	return nil
+}
+
+// ImageInfo is what the image step learns about an image. It's stored in metadata.image.
+type ImageInfo struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ImageStep decodes an uploaded photo with vips and stores JPEG derivatives, scaled by
+// ffmpeg, next to it. vips reads HEIC through libheif, which assembles the tile grid
+// iPhones store photos as. The thumb becomes the row's thumbnail unless the user
+// uploaded one.
+type ImageStep struct {
+	tools *FFmpeg
+	rules ImageRules
+}
+
+func (s *ImageStep) Name() string { return "image" }
+
+func (s *ImageStep) Run(ctx context.Context, job *Job) error {
+	// Decode once to a lossless full-size copy, every derivative is scaled from it.
+	// autorot applies the EXIF orientation first, so portrait photos are measured and
+	// scaled upright. A decode failure is retried rather than rejected: it's as likely
+	// to be a decoder missing a format as a broken file.
+	decoded := filepath.Join(job.WorkDir, "decoded.png")
+	if err := s.tools.Vips(ctx, "autorot", job.Source, decoded); err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+	width, height, err := imageSize(decoded)
+	if err != nil {
+		return err
+	}
+	if err := s.rules.Check(width, height); err != nil {
+		return err
+	}
+
+	var derivatives []types.ImageDerivative
+	for _, size := range derivativeSizes(imageDerivatives, width, height) {
+		derivative, err := s.derivative(ctx, job, decoded, size, width, height)
+		if err != nil {
+			return err
+		}
+		derivatives = append(derivatives, *derivative)
+	}
+
+	// Only fill thumbnail_url if the user didn't upload one in the meantime
+	if url, _ := job.Row["thumbnail_url"].(string); url == "" && len(derivatives) > 0 {
+		if _, err := clients.Media.UpdateMediaIf(ctx, job.Config, job.MediaID, repository.MediaCondition{NoThumbnail: true}, map[string]interface{}{
+			"thumbnail_url": derivatives[0].URL,
+			"updated_at":    time.Now().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+
+	job.Metadata["image"] = ImageInfo{Width: width, Height: height}
+	job.Metadata["derivatives"] = derivatives
+	return nil
+}
+
+// derivative scales the decoded image to one size and uploads it
+func (s *ImageStep) derivative(ctx context.Context, job *Job, decoded string, size derivativeSize, width, height int) (*types.ImageDerivative, error) {
+	outWidth, outHeight := fitLongEdge(size.LongEdge, width, height)
+	out := filepath.Join(job.WorkDir, size.Name+".jpg")
+	if err := s.tools.Run(ctx,
+		"-i", decoded,
+		"-vf", fmt.Sprintf("scale=%d:%d", outWidth, outHeight),
+		"-q:v", "3",
+		out,
+	); err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(out)
+	if err != nil {
+		return nil, err
+	}
+	key := job.DerivedKey("images/" + size.Name + ".jpg")
+	if err := putFile(ctx, key, out, "image/jpeg"); err != nil {
+		return nil, err
+	}
+	return &types.ImageDerivative{
+		Name:   size.Name,
+		Width:  outWidth,
+		Height: outHeight,
+		URL:    clients.Storage.PublicURL(key),
+		Size:   stat.Size(),
+	}, nil
+}
+
+// derivativeSizes picks the sizes that don't upscale. The smallest size is always
+// made, at the image's own size if it's smaller still.
+func derivativeSizes(sizes []derivativeSize, width, height int) []derivativeSize {
+	long := width
+	if height > long {
+		long = height
+	}
+	var picked []derivativeSize
+	for i, size := range sizes {
+		switch {
+		case size.LongEdge < long:
+			picked = append(picked, size)
+		case i == 0:
+			picked = append(picked, derivativeSize{Name: size.Name, LongEdge: long})
+		}
+	}
+	return picked
+}
+
+// fitLongEdge returns the output size for a long-edge target, keeping the aspect ratio
+func fitLongEdge(target, width, height int) (int, int) {
+	if width >= height {
+		h := height * target / width
+		if h < 1 {
+			h = 1
+		}
+		return target, h
+	}
+	w := width * target / height
+	if w < 1 {
+		w = 1
+	}
+	return w, target
+}
+
+func imageSize(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("decoded image is unreadable: %w", err)
+	}
+	return config.Width, config.Height, nil
+}
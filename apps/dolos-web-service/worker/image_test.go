@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDerivativeSizes(t *testing.T) {
+	cases := []struct {
+		width, height int
+		want          []string
+	}{
+		{4032, 3024, []string{"thumb:320", "medium:1080", "large:2048"}},
+		{3024, 4032, []string{"thumb:320", "medium:1080", "large:2048"}}, // portrait uses the long edge
+		{1600, 1200, []string{"thumb:320", "medium:1080"}},
+		{2048, 1536, []string{"thumb:320", "medium:1080"}}, // same size as large, nothing gained
+		{300, 200, []string{"thumb:300"}},                  // smaller than the thumb, kept at source size
+	}
+	for _, tc := range cases {
+		var got []string
+		for _, size := range derivativeSizes(imageDerivatives, tc.width, tc.height) {
+			got = append(got, size.Name+":"+strconv.Itoa(size.LongEdge))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%dx%d: expected %v, got %v", tc.width, tc.height, tc.want, got)
+		}
+	}
+}
+
+func TestFitLongEdge(t *testing.T) {
+	if w, h := fitLongEdge(1080, 4032, 3024); w != 1080 || h != 810 {
+		t.Fatalf("expected 1080x810, got %dx%d", w, h)
+	}
+	if w, h := fitLongEdge(320, 3024, 4032); w != 240 || h != 320 {
+		t.Fatalf("expected 240x320, got %dx%d", w, h)
+	}
+}
+
+func TestImageRules(t *testing.T) {
+	rules := ImageRules{MaxLongEdge: 12000, MinShortEdge: 200}
+	if err := rules.Check(4032, 3024); err != nil {
+		t.Fatalf("expected a phone photo to pass, got %v", err)
+	}
+	if err := rules.Check(16000, 9000); !isRejected(err) {
+		t.Fatalf("expected an oversized image to be rejected, got %v", err)
+	}
+	if err := rules.Check(150, 400); !isRejected(err) {
+		t.Fatalf("expected a tiny image to be rejected, got %v", err)
+	}
+}
+
+func TestImageDecodeFailureIsRetried(t *testing.T) {
+	step := &ImageStep{tools: &FFmpeg{VipsPath: "false"}, rules: ImageRules{MaxLongEdge: 12000, MinShortEdge: 200}}
+	job := &Job{Source: "source.heic", WorkDir: t.TempDir(), Metadata: map[string]interface{}{}}
+	err := step.Run(context.Background(), job)
+	if err == nil || isRejected(err) {
+		t.Fatalf("expected a retryable error, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func init() {
+	Register("preview", func(tools *FFmpeg) (Step, error) {
+		return &PreviewStep{tools: tools}, nil
+	})
+}
+
+const (
+	// previewLength is how much of the video the animated preview loops
+	previewLength = 3 * time.Second
+	previewFPS    = 12
+	previewWidth  = 320
+
+	spriteTileWidth   = 160
+	spriteColumns     = 10
+	spriteMaxTiles    = 100
+	spriteMinInterval = 0.5 // seconds, clips are short so a tile per frame isn't useful
+)
+
+// PreviewStep generates a looping animated preview for gallery tiles and a sprite sheet
+// with a WebVTT thumbnail track for scrubbing, next to the video
+type PreviewStep struct {
+	tools *FFmpeg
+}
+
+func (s *PreviewStep) Name() string { return "preview" }
+
+func (s *PreviewStep) Run(ctx context.Context, job *Job) error {
+	if job.Probe == nil {
+		return errors.New("preview needs the probe step to run first")
+	}
+	width, height := job.Probe.DisplaySize()
+	if width == 0 || height == 0 {
+		return Reject("video has no dimensions")
+	}
+
+	preview, err := s.preview(ctx, job, width, height)
+	if err != nil {
+		return err
+	}
+	sprites, err := s.sprites(ctx, job, width, height)
+	if err != nil {
+		return err
+	}
+
+	job.Metadata["preview"] = preview
+	job.Metadata["sprites"] = sprites
+	return nil
+}
+
+// preview encodes the middle of the video, where the trick usually is, as an animated
+// WebP. ffmpeg builds without libwebp get a GIF instead.
+func (s *PreviewStep) preview(ctx context.Context, job *Job, width, height int) (*types.Preview, error) {
+	length := math.Min(previewLength.Seconds(), job.Probe.Duration)
+	start := math.Max(0, (job.Probe.Duration-length)/2)
+	outWidth := previewWidth
+	if width < outWidth {
+		outWidth = width - width%2
+	}
+	outHeight := evenHeight(outWidth, width, height)
+
+	input := []string{"-ss", formatSeconds(start), "-t", formatSeconds(length), "-i", job.Source, "-an"}
+	scale := fmt.Sprintf("fps=%d,scale=%d:%d:flags=lanczos", previewFPS, outWidth, outHeight)
+
+	format, contentType := "webp", "image/webp"
+	out := filepath.Join(job.WorkDir, "preview.webp")
+	err := s.tools.Run(ctx, append(input,
+		"-vf", scale,
+		"-c:v", "libwebp", "-lossless", "0", "-quality", "70", "-loop", "0",
+		out,
+	)...)
+	if err != nil {
+		log.Printf("WebP preview for %s failed, falling back to GIF: %v", job.MediaID, err)
+		format, contentType = "gif", "image/gif"
+		out = filepath.Join(job.WorkDir, "preview.gif")
+		err = s.tools.Run(ctx, append(input,
+			"-filter_complex", scale+",split[a][b];[a]palettegen=max_colors=128[p];[b][p]paletteuse",
+			"-loop", "0",
+			out,
+		)...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key := job.DerivedKey("preview." + format)
+	if err := putFile(ctx, key, out, contentType); err != nil {
+		return nil, err
+	}
+	return &types.Preview{
+		URL:      clients.Storage.PublicURL(key),
+		Format:   format,
+		Width:    outWidth,
+		Height:   outHeight,
+		Start:    start,
+		Duration: length,
+	}, nil
+}
+
+// sprites tiles evenly spaced frames into one JPEG and writes the matching WebVTT track
+func (s *PreviewStep) sprites(ctx context.Context, job *Job, width, height int) (*types.SpriteSheet, error) {
+	layout := newSpriteLayout(job.Probe.Duration, width, height)
+
+	out := filepath.Join(job.WorkDir, "sprites.jpg")
+	err := s.tools.Run(ctx,
+		"-i", job.Source,
+		"-an",
+		"-vf", fmt.Sprintf("fps=1/%s,scale=%d:%d,tile=%dx%d", formatSeconds(layout.Interval), layout.TileWidth, layout.TileHeight, layout.Columns, layout.rows()),
+		"-frames:v", "1",
+		"-q:v", "5",
+		out,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sheetKey := job.DerivedKey("sprites.jpg")
+	if err := putFile(ctx, sheetKey, out, "image/jpeg"); err != nil {
+		return nil, err
+	}
+
+	vtt := layout.vtt("sprites.jpg", job.Probe.Duration)
+	vttKey := job.DerivedKey("sprites.vtt")
+	if err := clients.Storage.Put(ctx, vttKey, strings.NewReader(vtt), int64(len(vtt)), "text/vtt"); err != nil {
+		return nil, err
+	}
+
+	sheet := types.SpriteSheet(layout)
+	sheet.URL = clients.Storage.PublicURL(sheetKey)
+	sheet.VTTURL = clients.Storage.PublicURL(vttKey)
+	return &sheet, nil
+}
+
+type spriteLayout types.SpriteSheet
+
+// newSpriteLayout spaces at most spriteMaxTiles tiles over the video
+func newSpriteLayout(duration float64, width, height int) spriteLayout {
+	interval := math.Max(spriteMinInterval, duration/spriteMaxTiles)
+	tiles := int(math.Ceil(duration / interval))
+	if tiles < 1 {
+		tiles = 1
+	}
+	columns := spriteColumns
+	if tiles < columns {
+		columns = tiles
+	}
+	return spriteLayout{
+		TileWidth:  spriteTileWidth,
+		TileHeight: evenHeight(spriteTileWidth, width, height),
+		Columns:    columns,
+		Tiles:      tiles,
+		Interval:   interval,
+	}
+}
+
+func (l spriteLayout) rows() int {
+	return (l.Tiles + l.Columns - 1) / l.Columns
+}
+
+// vtt returns the WebVTT thumbnail track. Cues point at the sheet relative to the
+// track's own URL.
+func (l spriteLayout) vtt(sheet string, duration float64) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < l.Tiles; i++ {
+		start := float64(i) * l.Interval
+		end := math.Min(start+l.Interval, duration)
+		x := (i % l.Columns) * l.TileWidth
+		y := (i / l.Columns) * l.TileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n", vttTime(start), vttTime(end), sheet, x, y, l.TileWidth, l.TileHeight)
+	}
+	return b.String()
+}
+
+// vttTime formats seconds as a WebVTT timestamp, e.g. 00:00:01.500
+func vttTime(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// evenHeight scales height to a new width, keeping the aspect ratio and an even result
+func evenHeight(newWidth, width, height int) int {
+	h := int(math.Round(float64(newWidth) * float64(height) / float64(width)))
+	if h%2 == 1 {
+		h++
+	}
+	return h
+}
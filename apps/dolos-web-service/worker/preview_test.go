@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpriteLayout(t *testing.T) {
+	layout := newSpriteLayout(9.8, 1920, 1080)
+	if layout.Interval != 0.5 || layout.Tiles != 20 || layout.Columns != 10 || layout.rows() != 2 {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+	if layout.TileWidth != 160 || layout.TileHeight != 90 {
+		t.Fatalf("expected 160x90 tiles, got %dx%d", layout.TileWidth, layout.TileHeight)
+	}
+
+	// Long videos are capped at spriteMaxTiles
+	if long := newSpriteLayout(300, 1080, 1920); long.Tiles != 100 || long.Interval != 3 || long.TileHeight != 284 {
+		t.Fatalf("unexpected layout %+v", long)
+	}
+}
+
+func TestSpriteVTT(t *testing.T) {
+	layout := newSpriteLayout(1.2, 1280, 720)
+	got := layout.vtt("sprites.jpg", 1.2)
+	want := strings.Join([]string{
+		"WEBVTT",
+		"",
+		"00:00:00.000 --> 00:00:00.500",
+		"sprites.jpg#xywh=0,0,160,90",
+		"",
+		"00:00:00.500 --> 00:00:01.000",
+		"sprites.jpg#xywh=160,0,160,90",
+		"",
+		"00:00:01.000 --> 00:00:01.200",
+		"sprites.jpg#xywh=320,0,160,90",
+	}, "\n") + "\n"
+	if got != want {
+		t.Fatalf("unexpected track:\n%s", got)
+	}
+}
+
+func TestVTTTime(t *testing.T) {
+	if got := vttTime(3725.5); got != "01:02:05.500" {
+		t.Fatalf("expected 01:02:05.500, got %s", got)
+	}
+}
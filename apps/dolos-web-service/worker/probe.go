@@ -0,0 +1,262 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("probe", func(tools *FFmpeg) (Step, error) {
+		rules, err := VideoRulesFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return &ProbeStep{tools: tools, rules: rules}, nil
+	})
+}
+
+// durationTolerance allows for containers that report a few frames over the limit
+const durationTolerance = 250 * time.Millisecond
+
+// VideoRules is the server-side policy uploaded videos must meet
+type VideoRules struct {
+	MaxDuration  time.Duration
+	Codecs       []string // allowed video codecs, as named by ffprobe
+	MaxLongEdge  int      // pixels, either orientation
+	MinShortEdge int
+	Containers   []string // accepted ffprobe format_name entries
+}
+
+// VideoRulesFromEnv reads VIDEO_MAX_DURATION, VIDEO_CODECS, VIDEO_MAX_LONG_EDGE and VIDEO_MIN_SHORT_EDGE
+func VideoRulesFromEnv() (VideoRules, error) {
+	rules := VideoRules{
+		MaxDuration:  10 * time.Second,
+		Codecs:       []string{"h264", "hevc"},
+		MaxLongEdge:  4096,
+		MinShortEdge: 240,
+		Containers:   []string{"mov", "mp4"},
+	}
+
+	var err error
+	if rules.MaxDuration, err = envDuration("VIDEO_MAX_DURATION", rules.MaxDuration); err != nil {
+		return rules, err
+	}
+	if rules.MaxLongEdge, err = envInt("VIDEO_MAX_LONG_EDGE", rules.MaxLongEdge); err != nil {
+		return rules, err
+	}
+	if rules.MinShortEdge, err = envInt("VIDEO_MIN_SHORT_EDGE", rules.MinShortEdge); err != nil {
+		return rules, err
+	}
+	if codecs := envList("VIDEO_CODECS"); len(codecs) > 0 {
+		rules.Codecs = codecs
+	}
+	return rules, nil
+}
+
+// VideoInfo is what the probe step learns about a video. It's stored in metadata.probe.
+type VideoInfo struct {
+	Duration   float64 `json:"duration"` // seconds
+	Width      int     `json:"width"`    // coded size, before rotation
+	Height     int     `json:"height"`
+	Rotation   int     `json:"rotation"` // degrees, as shown by players
+	FPS        float64 `json:"fps"`
+	Codec      string  `json:"codec"`
+	Bitrate    int64   `json:"bitrate"` // bits per second
+	AudioCodec string  `json:"audio_codec,omitempty"`
+	Format     string  `json:"format"`
+}
+
+// DisplaySize returns the size the video is shown at, after rotation
+func (v *VideoInfo) DisplaySize() (int, int) {
+	if v.Rotation == 90 || v.Rotation == 270 {
+		return v.Height, v.Width
+	}
+	return v.Width, v.Height
+}
+
+// ProbeStep runs ffprobe on the upload, rejects videos that break the rules and records
+// the real duration and stream details
+type ProbeStep struct {
+	tools *FFmpeg
+	rules VideoRules
+}
+
+func (s *ProbeStep) Name() string { return "probe" }
+
+func (s *ProbeStep) Run(ctx context.Context, job *Job) error {
+	out, err := s.tools.Probe(ctx, "-print_format", "json", "-show_format", "-show_streams", job.Source)
+	if err != nil {
+		// ffprobe exits non-zero for files it can't parse, which retrying won't fix
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return Reject("not a readable video file: %v", err)
+		}
+		return err
+	}
+
+	info, err := parseProbe(out)
+	if err != nil {
+		return Reject("%v", err)
+	}
+	// A trimmed video only has to fit the rules once it's cut
+	checked := *info
+	if startMs, endMs := trimPoints(job.Row); startMs != nil || endMs != nil {
+		start, end := trimWindow(info.Duration, startMs, endMs)
+		checked.Duration = end - start
+	}
+	if err := s.rules.Check(&checked); err != nil {
+		return err
+	}
+
+	job.Probe = info
+	job.Metadata["probe"] = info
+	job.Updates["duration_seconds"] = int(math.Round(info.Duration))
+	return nil
+}
+
+// Check returns a RejectError if the video breaks the rules
+func (r VideoRules) Check(info *VideoInfo) error {
+	if !containsAny(info.Format, r.Containers) {
+		return Reject("unsupported container %q, only MP4 and MOV are allowed", info.Format)
+	}
+	if !contains(r.Codecs, info.Codec) {
+		return Reject("unsupported video codec %q (allowed: %s)", info.Codec, strings.Join(r.Codecs, ", "))
+	}
+
+	duration := time.Duration(info.Duration * float64(time.Second))
+	if duration > r.MaxDuration+durationTolerance {
+		return Reject("video is %.1fs long, the limit is %s", info.Duration, r.MaxDuration)
+	}
+
+	long, short := info.Width, info.Height
+	if short > long {
+		long, short = short, long
+	}
+	if long > r.MaxLongEdge {
+		return Reject("resolution %dx%d exceeds the %dpx limit", info.Width, info.Height, r.MaxLongEdge)
+	}
+	if short < r.MinShortEdge {
+		return Reject("resolution %dx%d is below the %dpx minimum", info.Width, info.Height, r.MinShortEdge)
+	}
+	return nil
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		BitRate      string            `json:"bit_rate"`
+		Duration     string            `json:"duration"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// parseProbe turns ffprobe's JSON output into a VideoInfo
+func parseProbe(out []byte) (*VideoInfo, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+
+	info := &VideoInfo{Format: probe.Format.FormatName}
+	found := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if found {
+				continue
+			}
+			found = true
+			info.Codec = stream.CodecName
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.FPS = parseRate(stream.AvgFrameRate)
+			if info.FPS == 0 {
+				info.FPS = parseRate(stream.RFrameRate)
+			}
+			info.Bitrate, _ = strconv.ParseInt(stream.BitRate, 10, 64)
+			info.Duration, _ = strconv.ParseFloat(stream.Duration, 64)
+
+			// Newer ffprobe reports a display matrix, older versions a rotate tag
+			rotation := 0.0
+			for _, side := range stream.SideDataList {
+				if side.Rotation != 0 {
+					rotation = -side.Rotation
+				}
+			}
+			if tag, ok := stream.Tags["rotate"]; ok && rotation == 0 {
+				rotation, _ = strconv.ParseFloat(tag, 64)
+			}
+			info.Rotation = ((int(math.Round(rotation)) % 360) + 360) % 360
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no video stream found")
+	}
+
+	if duration, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil && duration > 0 {
+		info.Duration = duration
+	}
+	if info.Bitrate == 0 {
+		info.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	}
+	info.FPS = math.Round(info.FPS*100) / 100
+	return info, nil
+}
+
+// parseRate parses an ffprobe rate such as "30000/1001"
+func parseRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny reports whether a comma-separated ffprobe format list contains any of values
+func containsAny(list string, values []string) bool {
+	for _, entry := range strings.Split(list, ",") {
+		if contains(values, entry) {
+			return true
+		}
+	}
+	return false
+}
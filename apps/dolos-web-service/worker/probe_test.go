@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+const iphoneProbe = `{
+  "streams": [
+    {"codec_type": "video", "codec_name": "hevc", "width": 1920, "height": 1080,
+     "avg_frame_rate": "30000/1001", "r_frame_rate": "30/1", "bit_rate": "8000000", "duration": "8.008",
+     "side_data_list": [{"side_data_type": "Display Matrix", "rotation": -90}]},
+    {"codec_type": "audio", "codec_name": "aac"}
+  ],
+  "format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "8.041", "bit_rate": "8200000"}
+}`
+
+func TestParseProbe(t *testing.T) {
+	info, err := parseProbe([]byte(iphoneProbe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := VideoInfo{
+		Duration: 8.041, Width: 1920, Height: 1080, Rotation: 90, FPS: 29.97,
+		Codec: "hevc", Bitrate: 8000000, AudioCodec: "aac", Format: "mov,mp4,m4a,3gp,3g2,mj2",
+	}
+	if *info != want {
+		t.Fatalf("got %+v, want %+v", *info, want)
+	}
+	if w, h := info.DisplaySize(); w != 1080 || h != 1920 {
+		t.Fatalf("expected portrait display size, got %dx%d", w, h)
+	}
+
+	if _, err := parseProbe([]byte(`{"streams": [{"codec_type": "audio"}], "format": {}}`)); err == nil {
+		t.Fatal("expected a file without video to be rejected")
+	}
+}
+
+func TestVideoRules(t *testing.T) {
+	rules := VideoRules{
+		MaxDuration:  10 * time.Second,
+		Codecs:       []string{"h264", "hevc"},
+		MaxLongEdge:  4096,
+		MinShortEdge: 240,
+		Containers:   []string{"mov", "mp4"},
+	}
+	valid := VideoInfo{Duration: 10.1, Width: 1080, Height: 1920, Codec: "h264", Format: "mov,mp4,m4a,3gp,3g2,mj2"}
+
+	tests := []struct {
+		name   string
+		modify func(v *VideoInfo)
+		reject bool
+	}{
+		{"valid", func(v *VideoInfo) {}, false},
+		{"too long", func(v *VideoInfo) { v.Duration = 12 }, true},
+		{"codec", func(v *VideoInfo) { v.Codec = "vp9" }, true},
+		{"container", func(v *VideoInfo) { v.Format = "matroska,webm" }, true},
+		{"too large", func(v *VideoInfo) { v.Width, v.Height = 8192, 4320 }, true},
+		{"too small", func(v *VideoInfo) { v.Width, v.Height = 320, 180 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := valid
+			tt.modify(&info)
+			err := rules.Check(&info)
+			if tt.reject != (err != nil) || (err != nil && !isRejected(err)) {
+				t.Fatalf("reject=%v, got %v", tt.reject, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// Job is a completed upload moving through the processing pipeline
+type Job struct {
+	Config   types.MediaConfig
+	MediaID  string
+	ParentID string
+	OwnerID  string
+	Row      map[string]interface{} // media row as claimed
+	Key      string                 // storage key of the uploaded video or image
+	Source   string                 // local copy of the upload
+	WorkDir  string                 // scratch directory, removed after the job
+
+	Probe *VideoInfo // set by the probe step
+
+	Metadata map[string]interface{} // merged into the row's metadata when the job finishes
+	Updates  map[string]interface{} // other columns to set when the job finishes
+}
+
+// DerivedKey returns the storage key for a file derived from the video, e.g. thumbnail.jpg
+func (j *Job) DerivedKey(name string) string {
+	return j.Key + "/" + name
+}
+
+// Step is one stage of the processing pipeline
+type Step interface {
+	Name() string
+	Run(ctx context.Context, job *Job) error
+}
+
+// StepFactory builds a step with the configured ffmpeg tools
+type StepFactory func(tools *FFmpeg) (Step, error)
+
+// timeoutStep is implemented by steps that need a different timeout than the default
+type timeoutStep interface {
+	Timeout() time.Duration
+}
+
+var registry = map[string]StepFactory{}
+
+// Register makes a step available to WORKER_STEPS. Steps register themselves in init.
+func Register(name string, factory StepFactory) {
+	registry[name] = factory
+}
+
+// DefaultSteps is the pipeline used when WORKER_STEPS is unset
+var DefaultSteps = []string{"probe", "trim", "thumbnail", "preview", "transcode"}
+
+// DefaultImageSteps is the pipeline for images when WORKER_IMAGE_STEPS is unset
+var DefaultImageSteps = []string{"image"}
+
+// BuildPipeline returns the steps with the given names, in order
+func BuildPipeline(names []string, tools *FFmpeg) ([]Step, error) {
+	steps := make([]Step, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown worker step %q (available: %s)", name, strings.Join(StepNames(), ", "))
+		}
+		step, err := factory(tools)
+		if err != nil {
+			return nil, fmt.Errorf("worker step %q: %w", name, err)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// StepNames returns the names of all registered steps
+func StepNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RejectError means the video breaks a policy. The job fails without retrying.
+type RejectError struct {
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return e.Reason
+}
+
+// Reject returns a RejectError with a formatted reason
+func Reject(format string, args ...interface{}) error {
+	return &RejectError{Reason: fmt.Sprintf(format, args...)}
+}
+
+func isRejected(err error) bool {
+	var reject *RejectError
+	return errors.As(err, &reject)
+}
@@ -0,0 +1,240 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+)
+
+func init() {
+	Register("thumbnail", func(tools *FFmpeg) (Step, error) {
+		return &ThumbnailStep{tools: tools}, nil
+	})
+}
+
+const (
+	// thumbnailWidth caps the generated thumbnail, gallery tiles are much smaller
+	thumbnailWidth = 720
+	// candidateFPS is how many frames of the first second are compared for sharpness
+	candidateFPS = 10
+	// generatedThumbnail is where the step stores its thumbnail, the key a JPEG
+	// uploaded through /:videoId/thumbnail also uses
+	generatedThumbnail = "thumbnail.jpg"
+)
+
+// ThumbnailStep generates a thumbnail for videos without a user-uploaded thumbnail.
+// The frame is the client's thumbnail_time_ms if given, otherwise the sharpest frame
+// of the first second.
+type ThumbnailStep struct {
+	tools *FFmpeg
+}
+
+func (s *ThumbnailStep) Name() string { return "thumbnail" }
+
+// hasGeneratedThumbnail reports whether a row's thumbnail_url is one this step made.
+// Uploading a thumbnail drops metadata.thumbnail, so only generated ones carry it.
+func hasGeneratedThumbnail(row map[string]interface{}) bool {
+	url, _ := row["thumbnail_url"].(string)
+	metadata, _ := row["metadata"].(map[string]interface{})
+	thumbnail, _ := metadata["thumbnail"].(map[string]interface{})
+	return strings.HasSuffix(url, "/"+generatedThumbnail) && thumbnail["source"] == "generated"
+}
+
+func (s *ThumbnailStep) Run(ctx context.Context, job *Job) error {
+	if url, _ := job.Row["thumbnail_url"].(string); url != "" {
+		return nil
+	}
+	// A thumbnail uploaded while the job was queued wins. The row is checked rather
+	// than storage since a retry finds this step's own thumbnail.jpg there.
+	current, err := clients.Media.GetMedia(ctx, job.Config, job.MediaID)
+	if err != nil {
+		return err
+	}
+	if current.ThumbnailURL != nil && *current.ThumbnailURL != "" {
+		return nil
+	}
+
+	var frame string
+	var timeMs int64
+	metadata, _ := job.Row["metadata"].(map[string]interface{})
+	if requested, ok := metadata["thumbnail_time_ms"].(float64); ok {
+		// The time is into the upload, job.Source may be a cut starting later
+		timeMs = s.clamp(int64(requested)-trimStartMs(metadata), job)
+		frame, err = s.frameAt(ctx, job, timeMs)
+	} else {
+		frame, timeMs, err = s.sharpestFrame(ctx, job)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(frame)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := job.DerivedKey(generatedThumbnail)
+	if err := clients.Storage.Put(ctx, key, f, stat.Size(), "image/jpeg"); err != nil {
+		return err
+	}
+
+	// Only fill thumbnail_url if the user didn't upload one in the meantime
+	thumbnailURL := clients.Storage.PublicURL(key)
+	updated, err := clients.Media.UpdateMediaIf(ctx, job.Config, job.MediaID, repository.MediaCondition{NoThumbnail: true}, map[string]interface{}{
+		"thumbnail_url": thumbnailURL,
+		"updated_at":    time.Now().Format(time.RFC3339),
+	})
+	if err != nil || !updated {
+		return err
+	}
+
+	job.Metadata["thumbnail"] = map[string]interface{}{
+		"source":  "generated",
+		"time_ms": timeMs,
+	}
+	return nil
+}
+
+// trimStartMs returns where the applied trim starts in the upload, 0 if there's none.
+// The trim step stores it as an int64, a row read back from the database has a float64.
+func trimStartMs(metadata map[string]interface{}) int64 {
+	trim, _ := metadata["trim"].(map[string]interface{})
+	switch start := trim["start_ms"].(type) {
+	case int64:
+		return start
+	case float64:
+		return int64(start)
+	}
+	return 0
+}
+
+// clamp keeps a requested timestamp inside the video
+func (s *ThumbnailStep) clamp(timeMs int64, job *Job) int64 {
+	if timeMs < 0 {
+		return 0
+	}
+	if job.Probe != nil {
+		last := int64(job.Probe.Duration*1000) - 100
+		if last > 0 && timeMs > last {
+			return last
+		}
+	}
+	return timeMs
+}
+
+func (s *ThumbnailStep) frameAt(ctx context.Context, job *Job, timeMs int64) (string, error) {
+	out := filepath.Join(job.WorkDir, "thumbnail.jpg")
+	err := s.tools.Run(ctx,
+		"-ss", strconv.FormatFloat(float64(timeMs)/1000, 'f', 3, 64),
+		"-i", job.Source,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", thumbnailWidth),
+		"-q:v", "3",
+		out,
+	)
+	return out, err
+}
+
+// sharpestFrame extracts the frames of the first second and returns the sharpest one
+func (s *ThumbnailStep) sharpestFrame(ctx context.Context, job *Job) (string, int64, error) {
+	dir := filepath.Join(job.WorkDir, "thumbnail-candidates")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", 0, err
+	}
+	err := s.tools.Run(ctx,
+		"-i", job.Source,
+		"-t", "1",
+		"-vf", fmt.Sprintf("fps=%d,scale='min(%d,iw)':-2", candidateFPS, thumbnailWidth),
+		"-q:v", "3",
+		filepath.Join(dir, "frame-%03d.jpg"),
+	)
+	if err != nil {
+		return "", 0, err
+	}
+
+	frames, err := filepath.Glob(filepath.Join(dir, "frame-*.jpg"))
+	if err != nil {
+		return "", 0, err
+	}
+	if len(frames) == 0 {
+		return "", 0, Reject("no frames could be decoded")
+	}
+	sort.Strings(frames)
+
+	best, bestScore := -1, -1.0
+	for i, frame := range frames {
+		score, err := frameSharpness(frame)
+		if err != nil {
+			log.Printf("Skipping thumbnail candidate %s: %v", frame, err)
+			continue
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return "", 0, errors.New("no thumbnail candidate could be decoded")
+	}
+	return frames[best], int64(best) * 1000 / candidateFPS, nil
+}
+
+func frameSharpness(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+	return sharpness(img), nil
+}
+
+// sharpness is the variance of the Laplacian of the image's luma. Blurry frames, common
+// at the start of a handheld clip, have few edges and score low.
+func sharpness(img image.Image) float64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w < 3 || h < 3 {
+		return 0
+	}
+
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			luma[y*w+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 256
+		}
+	}
+
+	var sum, sumSq float64
+	n := float64((w - 2) * (h - 2))
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			v := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			sum += v
+			sumSq += v * v
+		}
+	}
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
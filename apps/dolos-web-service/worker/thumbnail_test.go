@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSharpnessPrefersEdges(t *testing.T) {
+	flat := image.NewGray(image.Rect(0, 0, 32, 32))
+	checker := image.NewGray(image.Rect(0, 0, 32, 32))
+	soft := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			flat.SetGray(x, y, color.Gray{Y: 128})
+			if (x/4+y/4)%2 == 0 {
+				checker.SetGray(x, y, color.Gray{Y: 255})
+			}
+			// A gradient has no second derivative, like a badly blurred frame
+			soft.SetGray(x, y, color.Gray{Y: uint8(x * 8)})
+		}
+	}
+
+	if s := sharpness(flat); s != 0 {
+		t.Fatalf("expected a flat frame to score 0, got %f", s)
+	}
+	if sharpness(checker) <= sharpness(soft) {
+		t.Fatalf("expected hard edges to beat a gradient: %f <= %f", sharpness(checker), sharpness(soft))
+	}
+}
+
+func TestThumbnailClamp(t *testing.T) {
+	step := &ThumbnailStep{}
+	job := &Job{Probe: &VideoInfo{Duration: 5}}
+
+	if got := step.clamp(-10, job); got != 0 {
+		t.Fatalf("expected 0, got %d", got)
+	}
+	if got := step.clamp(2500, job); got != 2500 {
+		t.Fatalf("expected 2500, got %d", got)
+	}
+	if got := step.clamp(9000, job); got != 4900 {
+		t.Fatalf("expected the last frame, got %d", got)
+	}
+}
+
+func TestTrimStartMs(t *testing.T) {
+	// As the trim step leaves it in job.Row, and as read back from the database
+	for _, trim := range []map[string]interface{}{{"start_ms": int64(1500)}, {"start_ms": float64(1500)}} {
+		if got := trimStartMs(map[string]interface{}{"trim": trim}); got != 1500 {
+			t.Fatalf("expected 1500, got %d", got)
+		}
+	}
+	if got := trimStartMs(map[string]interface{}{"thumbnail_time_ms": float64(800)}); got != 0 {
+		t.Fatalf("expected 0 without a trim, got %d", got)
+	}
+}
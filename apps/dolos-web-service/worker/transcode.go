@@ -0,0 +1,246 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func init() {
+	Register("transcode", func(tools *FFmpeg) (Step, error) {
+		timeout, err := envDuration("TRANSCODE_TIMEOUT", 15*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		heights := defaultRenditionHeights
+		if values := envList("TRANSCODE_RENDITIONS"); len(values) > 0 {
+			heights = nil
+			for _, value := range values {
+				height, err := strconv.Atoi(value)
+				if err != nil || height <= 0 {
+					return nil, fmt.Errorf("invalid TRANSCODE_RENDITIONS entry %q", value)
+				}
+				heights = append(heights, height)
+			}
+			sort.Ints(heights)
+		}
+		return &TranscodeStep{tools: tools, heights: heights, timeout: timeout}, nil
+	})
+}
+
+// defaultRenditionHeights are the short-edge sizes transcoded by default
+var defaultRenditionHeights = []int{480, 720, 1080}
+
+const (
+	// hlsSegmentSeconds is short since clips are only ~10 seconds long
+	hlsSegmentSeconds = 2
+	hlsPlaylistType   = "application/vnd.apple.mpegurl"
+)
+
+// rateControl caps the bitrate per rendition so phones on mobile data get small files
+var rateControl = map[int]string{
+	480:  "1200k",
+	720:  "2800k",
+	1080: "5000k",
+}
+
+// TranscodeStep produces H.264/AAC MP4 renditions and an HLS master playlist next to the
+// original video. Renditions larger than the source aren't produced.
+type TranscodeStep struct {
+	tools   *FFmpeg
+	heights []int
+	timeout time.Duration
+}
+
+func (s *TranscodeStep) Name() string { return "transcode" }
+
+func (s *TranscodeStep) Timeout() time.Duration { return s.timeout }
+
+func (s *TranscodeStep) Run(ctx context.Context, job *Job) error {
+	if job.Probe == nil {
+		return errors.New("transcode needs the probe step to run first")
+	}
+	width, height := job.Probe.DisplaySize()
+
+	var renditions []types.Rendition
+	var variants []hlsVariant
+	for _, target := range renditionHeights(s.heights, width, height) {
+		rendition, variant, err := s.rendition(ctx, job, target, width, height)
+		if err != nil {
+			return err
+		}
+		renditions = append(renditions, *rendition)
+		variants = append(variants, *variant)
+	}
+
+	masterKey := job.DerivedKey("hls/master.m3u8")
+	master := masterPlaylist(variants)
+	if err := clients.Storage.Put(ctx, masterKey, strings.NewReader(master), int64(len(master)), hlsPlaylistType); err != nil {
+		return err
+	}
+
+	job.Metadata["renditions"] = renditions
+	job.Metadata["hls"] = types.HLSPlaylist{MasterURL: clients.Storage.PublicURL(masterKey)}
+	return nil
+}
+
+// renditionHeights picks the targets that don't upscale. A source smaller than every
+// target still gets one rendition at its own size.
+func renditionHeights(heights []int, width, height int) []int {
+	short := width
+	if height < short {
+		short = height
+	}
+	var targets []int
+	for _, h := range heights {
+		if h <= short {
+			targets = append(targets, h)
+		}
+	}
+	if len(targets) == 0 {
+		targets = []int{short - short%2}
+	}
+	return targets
+}
+
+// scaledSize returns the output size for a short-edge target, keeping even dimensions
+func scaledSize(target, width, height int) (int, int) {
+	if width >= height {
+		w := width * target / height
+		return w - w%2, target
+	}
+	h := height * target / width
+	return target, h - h%2
+}
+
+type hlsVariant struct {
+	Playlist  string // relative to the master playlist
+	Bandwidth int64
+	Width     int
+	Height    int
+}
+
+// rendition transcodes one size, uploads the MP4 and its HLS segments
+func (s *TranscodeStep) rendition(ctx context.Context, job *Job, target, width, height int) (*types.Rendition, *hlsVariant, error) {
+	outWidth, outHeight := scaledSize(target, width, height)
+	name := strconv.Itoa(target)
+	mp4 := filepath.Join(job.WorkDir, name+".mp4")
+
+	maxrate := rateControl[target]
+	if maxrate == "" {
+		maxrate = "5000k"
+	}
+	err := s.tools.Run(ctx,
+		"-i", job.Source,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("scale=%d:%d", outWidth, outHeight),
+		"-c:v", "libx264", "-profile:v", "high", "-preset", "veryfast", "-crf", "23",
+		"-maxrate", maxrate, "-bufsize", maxrate,
+		"-pix_fmt", "yuv420p",
+		// A keyframe every segment so HLS can be cut without re-encoding
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds),
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		"-movflags", "+faststart",
+		mp4,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("transcoding %sp: %w", name, err)
+	}
+
+	stat, err := os.Stat(mp4)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := job.DerivedKey("renditions/" + name + ".mp4")
+	if err := putFile(ctx, key, mp4, "video/mp4"); err != nil {
+		return nil, nil, err
+	}
+
+	bitrate := int64(0)
+	if job.Probe.Duration > 0 {
+		bitrate = int64(float64(stat.Size()*8) / job.Probe.Duration)
+	}
+	rendition := &types.Rendition{
+		Quality: target,
+		Width:   outWidth,
+		Height:  outHeight,
+		URL:     clients.Storage.PublicURL(key),
+		Bitrate: bitrate,
+		Size:    stat.Size(),
+	}
+
+	// Package the rendition as HLS without re-encoding
+	hlsDir := filepath.Join(job.WorkDir, "hls", name)
+	if err := os.MkdirAll(hlsDir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	err = s.tools.Run(ctx,
+		"-i", mp4,
+		"-c", "copy",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(hlsDir, "segment-%03d.ts"),
+		filepath.Join(hlsDir, "index.m3u8"),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("packaging %sp as HLS: %w", name, err)
+	}
+
+	files, err := os.ReadDir(hlsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		contentType := "video/mp2t"
+		if strings.HasSuffix(file.Name(), ".m3u8") {
+			contentType = hlsPlaylistType
+		}
+		key := job.DerivedKey("hls/" + name + "/" + file.Name())
+		if err := putFile(ctx, key, filepath.Join(hlsDir, file.Name()), contentType); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	variant := &hlsVariant{
+		Playlist: name + "/index.m3u8",
+		// Peak bandwidth is higher than the average, leave some headroom
+		Bandwidth: bitrate * 6 / 5,
+		Width:     outWidth,
+		Height:    outHeight,
+	}
+	return rendition, variant, nil
+}
+
+// masterPlaylist lists the variants, lowest bandwidth first
+func masterPlaylist(variants []hlsVariant) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s\n", v.Bandwidth, v.Width, v.Height, v.Playlist)
+	}
+	return b.String()
+}
+
+// putFile uploads a local file to storage
+func putFile(ctx context.Context, key, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return clients.Storage.Put(ctx, key, f, stat.Size(), contentType)
+}
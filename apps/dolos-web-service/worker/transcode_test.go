@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenditionHeights(t *testing.T) {
+	heights := []int{480, 720, 1080}
+	cases := []struct {
+		width, height int
+		want          []int
+	}{
+		{1920, 1080, []int{480, 720, 1080}},
+		{1080, 1920, []int{480, 720, 1080}}, // portrait uses the short edge too
+		{1280, 720, []int{480, 720}},
+		{3840, 2160, []int{480, 720, 1080}},
+		{427, 241, []int{240}}, // smaller than every target, kept at source size
+	}
+	for _, tc := range cases {
+		if got := renditionHeights(heights, tc.width, tc.height); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%dx%d: expected %v, got %v", tc.width, tc.height, tc.want, got)
+		}
+	}
+}
+
+func TestScaledSize(t *testing.T) {
+	if w, h := scaledSize(720, 1920, 1080); w != 1280 || h != 720 {
+		t.Fatalf("expected 1280x720, got %dx%d", w, h)
+	}
+	if w, h := scaledSize(480, 1080, 1920); w != 480 || h != 852 {
+		t.Fatalf("expected 480x852, got %dx%d", w, h)
+	}
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	got := masterPlaylist([]hlsVariant{
+		{Playlist: "480/index.m3u8", Bandwidth: 1200000, Width: 854, Height: 480},
+		{Playlist: "720/index.m3u8", Bandwidth: 2800000, Width: 1280, Height: 720},
+	})
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		"#EXT-X-STREAM-INF:BANDWIDTH=1200000,RESOLUTION=854x480",
+		"480/index.m3u8",
+		"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720",
+		"720/index.m3u8",
+	}, "\n") + "\n"
+	if got != want {
+		t.Fatalf("unexpected playlist:\n%s", got)
+	}
+}
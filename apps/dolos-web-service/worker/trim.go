@@ -0,0 +1,349 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/storage"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+func init() {
+	Register("trim", func(tools *FFmpeg) (Step, error) {
+		return &TrimStep{tools: tools}, nil
+	})
+}
+
+// trimTolerance is how far the cut's duration may be from the requested range
+const trimTolerance = 300 * time.Millisecond
+
+// The files and metadata the later steps derive from the video. Once a trim is applied
+// they show the old cut, so they're dropped and the later steps make them again.
+var (
+	staleFiles    = []string{"preview.webp", "preview.gif", "sprites.jpg", "sprites.vtt"}
+	stalePrefixes = []string{"hls/", "renditions/"}
+	staleMetadata = []string{"thumbnail", "preview", "sprites", "renditions", "hls"}
+)
+
+// TrimStep cuts the video to the row's trim_start_ms/trim_end_ms. The cut is lossless
+// when the start lands on a keyframe, otherwise the range is re-encoded. The original
+// upload is never modified: the cut is stored under trimmed/ and the row's url, duration
+// and file size are switched to it in one update once it has been verified.
+type TrimStep struct {
+	tools *FFmpeg
+}
+
+func (s *TrimStep) Name() string { return "trim" }
+
+func (s *TrimStep) Run(ctx context.Context, job *Job) error {
+	if job.Probe == nil {
+		return errors.New("trim needs the probe step to run first")
+	}
+	metadata, _ := job.Row["metadata"].(map[string]interface{})
+	previous, _ := metadata["trim"].(map[string]interface{})
+	previousKey, _ := previous["key"].(string)
+
+	startMs, endMs := trimPoints(job.Row)
+	if startMs == nil && endMs == nil {
+		if previous == nil {
+			return nil
+		}
+		return s.untrim(ctx, job, previousKey)
+	}
+
+	start, end := trimWindow(job.Probe.Duration, startMs, endMs)
+	if end-start < 0.1 {
+		return Reject("trim range starts at the end of the %.1fs video", job.Probe.Duration)
+	}
+
+	keyframes, err := s.keyframes(ctx, job.Source)
+	if err != nil {
+		return err
+	}
+
+	out := filepath.Join(job.WorkDir, "trimmed.mp4")
+	mode := "copy"
+	var info *VideoInfo
+	if keyframeAt(keyframes, start, job.Probe.FPS) {
+		info, err = s.cut(ctx, job, out, start, end, true)
+		if err != nil {
+			log.Printf("Lossless trim of %s failed, re-encoding: %v", job.MediaID, err)
+		}
+	}
+	if info == nil {
+		mode = "reencode"
+		if info, err = s.cut(ctx, job, out, start, end, false); err != nil {
+			return err
+		}
+	}
+
+	stat, err := os.Stat(out)
+	if err != nil {
+		return err
+	}
+	key := job.DerivedKey(fmt.Sprintf("trimmed/%d-%d.mp4", int64(start*1000), int64(end*1000)))
+	if err := putFile(ctx, key, out, "video/mp4"); err != nil {
+		return err
+	}
+	if stored, err := clients.Storage.Head(ctx, key); err != nil {
+		return err
+	} else if stored.Size != stat.Size() {
+		return fmt.Errorf("trimmed video stored as %d bytes, expected %d", stored.Size, stat.Size())
+	}
+
+	trim := map[string]interface{}{
+		"start_ms":   int64(start * 1000),
+		"end_ms":     int64(end * 1000),
+		"mode":       mode,
+		"key":        key,
+		"applied_at": time.Now().Format(time.RFC3339),
+	}
+	if err := s.commit(ctx, job, key, info, stat.Size(), trim, startMs, endMs); err != nil {
+		if delErr := clients.Storage.Delete(ctx, key); delErr != nil {
+			log.Printf("Failed to delete unused trim %s: %v", key, delErr)
+		}
+		return err
+	}
+	if previousKey != "" && previousKey != key {
+		if err := clients.Storage.Delete(ctx, previousKey); err != nil {
+			log.Printf("Failed to delete previous trim %s: %v", previousKey, err)
+		}
+	}
+
+	// Later steps work on the trimmed video
+	job.Source = out
+	job.Probe = info
+	job.Metadata["probe"] = info
+	return nil
+}
+
+// cut writes the range to out and verifies the result
+func (s *TrimStep) cut(ctx context.Context, job *Job, out string, start, end float64, lossless bool) (*VideoInfo, error) {
+	args := []string{
+		"-ss", formatSeconds(start),
+		"-i", job.Source,
+		"-t", formatSeconds(end - start),
+		"-map", "0:v:0", "-map", "0:a:0?",
+	}
+	if lossless {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+		if job.Probe.Codec == "hevc" {
+			// QuickTime only plays HEVC in MP4 with the hvc1 tag
+			args = append(args, "-tag:v", "hvc1")
+		}
+	} else {
+		args = append(args,
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "18", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "128k",
+		)
+	}
+	args = append(args, "-movflags", "+faststart", out)
+	if err := s.tools.Run(ctx, args...); err != nil {
+		return nil, err
+	}
+
+	probe, err := s.tools.Probe(ctx, "-print_format", "json", "-show_format", "-show_streams", out)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseProbe(probe)
+	if err != nil {
+		return nil, err
+	}
+	want := end - start
+	if math.Abs(info.Duration-want) > trimTolerance.Seconds() {
+		return nil, fmt.Errorf("trimmed video is %.2fs long, expected %.2fs", info.Duration, want)
+	}
+	return info, nil
+}
+
+// commit switches the row to the trimmed video in a single update. It's conditional on
+// the trim points the job cut, so a newer trim request isn't overwritten.
+func (s *TrimStep) commit(ctx context.Context, job *Job, key string, info *VideoInfo, size int64, trim map[string]interface{}, startMs, endMs *int64) error {
+	url := clients.Storage.PublicURL(key)
+	duration := int(math.Round(info.Duration))
+	metadata := types.MergeMetadata(job.Row, map[string]interface{}{"trim": trim})
+	updates := map[string]interface{}{
+		"url":              url,
+		"duration_seconds": duration,
+		"file_size_bytes":  size,
+		"metadata":         metadata,
+		"updated_at":       time.Now().Format(time.RFC3339),
+	}
+	dropDerived(job, metadata, updates)
+
+	cond := repository.MediaCondition{UploadStatus: "processing", MatchTrim: true, TrimStartMs: startMs, TrimEndMs: endMs}
+	updated, err := clients.Media.UpdateMediaIf(ctx, job.Config, job.MediaID, cond, updates)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errors.New("trim points changed while the video was being cut")
+	}
+	deleteDerived(ctx, job)
+
+	// Keep the rest of the pipeline from writing back the untrimmed values
+	job.Row["url"] = url
+	job.Row["metadata"] = metadata
+	if _, cleared := updates["thumbnail_url"]; cleared {
+		job.Row["thumbnail_url"] = nil
+	}
+	job.Updates["duration_seconds"] = duration
+	job.Updates["file_size_bytes"] = size
+	return nil
+}
+
+// untrim points the row back at the original upload after its trim was removed
+func (s *TrimStep) untrim(ctx context.Context, job *Job, previousKey string) error {
+	original, err := clients.Storage.Head(ctx, job.Key)
+	if err != nil {
+		return err
+	}
+	metadata := types.MergeMetadata(job.Row, nil, "trim")
+
+	url := clients.Storage.PublicURL(job.Key)
+	duration := int(math.Round(job.Probe.Duration))
+	updates := map[string]interface{}{
+		"url":              url,
+		"duration_seconds": duration,
+		"file_size_bytes":  original.Size,
+		"metadata":         metadata,
+		"updated_at":       time.Now().Format(time.RFC3339),
+	}
+	dropDerived(job, metadata, updates)
+	if _, err := clients.Media.UpdateMediaIf(ctx, job.Config, job.MediaID, repository.MediaCondition{UploadStatus: "processing", MatchTrim: true}, updates); err != nil {
+		return err
+	}
+	deleteDerived(ctx, job)
+	if previousKey != "" {
+		if err := clients.Storage.Delete(ctx, previousKey); err != nil {
+			log.Printf("Failed to delete previous trim %s: %v", previousKey, err)
+		}
+	}
+
+	job.Row["url"] = url
+	job.Row["metadata"] = metadata
+	if _, cleared := updates["thumbnail_url"]; cleared {
+		job.Row["thumbnail_url"] = nil
+	}
+	job.Updates["duration_seconds"] = duration
+	job.Updates["file_size_bytes"] = original.Size
+	return nil
+}
+
+// dropDerived removes the old cut's derived files from a row update: their metadata, and
+// thumbnail_url if it points at a generated thumbnail rather than one the user uploaded
+func dropDerived(job *Job, metadata, updates map[string]interface{}) {
+	for _, k := range staleMetadata {
+		delete(metadata, k)
+	}
+	if hasGeneratedThumbnail(job.Row) {
+		updates["thumbnail_url"] = nil
+	}
+}
+
+// deleteDerived deletes the old cut's derived files once the row no longer points at
+// them, job.Row still being the row before the trim. The thumbnail is only deleted if
+// it was generated. Failures are only logged, the later steps overwrite most of them anyway.
+func deleteDerived(ctx context.Context, job *Job) {
+	names := staleFiles
+	if hasGeneratedThumbnail(job.Row) {
+		names = append([]string{generatedThumbnail}, names...)
+	}
+	for _, name := range names {
+		if err := clients.Storage.Delete(ctx, job.DerivedKey(name)); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to delete stale %s of %s: %v", name, job.MediaID, err)
+		}
+	}
+	for _, prefix := range stalePrefixes {
+		if _, err := clients.Storage.DeletePrefix(ctx, job.DerivedKey(prefix)); err != nil {
+			log.Printf("Failed to delete stale %s of %s: %v", prefix, job.MediaID, err)
+		}
+	}
+}
+
+// keyframes returns the timestamps of the video's keyframes, in seconds
+func (s *TrimStep) keyframes(ctx context.Context, source string) ([]float64, error) {
+	out, err := s.tools.Probe(ctx,
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0",
+		source,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeyframes(string(out)), nil
+}
+
+func parseKeyframes(out string) []float64 {
+	var keyframes []float64
+	for _, line := range strings.Split(out, "\n") {
+		value := strings.TrimSuffix(strings.TrimSpace(line), ",")
+		if t, err := strconv.ParseFloat(value, 64); err == nil {
+			keyframes = append(keyframes, t)
+		}
+	}
+	return keyframes
+}
+
+// keyframeAt reports whether a cut at t starts on a keyframe, within one frame
+func keyframeAt(keyframes []float64, t, fps float64) bool {
+	if t == 0 {
+		return true
+	}
+	tolerance := 0.02
+	if fps > 0 {
+		tolerance = 1 / fps
+	}
+	for _, k := range keyframes {
+		if math.Abs(k-t) < tolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// trimPoints reads trim_start_ms and trim_end_ms from a media row
+func trimPoints(row map[string]interface{}) (*int64, *int64) {
+	var start, end *int64
+	if v, ok := row["trim_start_ms"].(float64); ok {
+		ms := int64(v)
+		start = &ms
+	}
+	if v, ok := row["trim_end_ms"].(float64); ok {
+		ms := int64(v)
+		end = &ms
+	}
+	return start, end
+}
+
+// trimWindow returns the range to keep in seconds, clamped to the video's duration
+func trimWindow(duration float64, startMs, endMs *int64) (float64, float64) {
+	start, end := 0.0, duration
+	if startMs != nil {
+		start = math.Min(float64(*startMs)/1000, duration)
+	}
+	if endMs != nil && float64(*endMs)/1000 < duration {
+		end = float64(*endMs) / 1000
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
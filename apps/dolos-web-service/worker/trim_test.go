@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/storage"
+)
+
+func TestParseKeyframes(t *testing.T) {
+	got := parseKeyframes("0.000000\n2.002000,\n\n4.004000\nN/A\n")
+	if want := []float64{0, 2.002, 4.004}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestKeyframeAt(t *testing.T) {
+	keyframes := []float64{0, 2.002, 4.004}
+	if !keyframeAt(keyframes, 2, 30) {
+		t.Fatal("expected a cut within a frame of a keyframe to be lossless")
+	}
+	if keyframeAt(keyframes, 3, 30) {
+		t.Fatal("expected a cut between keyframes to need re-encoding")
+	}
+	if !keyframeAt(nil, 0, 30) {
+		t.Fatal("expected a cut at the start to be lossless")
+	}
+}
+
+func TestTrimWindow(t *testing.T) {
+	ms := func(v int64) *int64 { return &v }
+	cases := []struct {
+		start, end *int64
+		want       [2]float64
+	}{
+		{ms(1500), ms(4000), [2]float64{1.5, 4}},
+		{ms(1500), nil, [2]float64{1.5, 12}},
+		{nil, ms(4000), [2]float64{0, 4}},
+		{ms(2000), ms(30000), [2]float64{2, 12}}, // end past the video is clamped
+		{ms(20000), nil, [2]float64{12, 12}},
+	}
+	for _, tc := range cases {
+		start, end := trimWindow(12, tc.start, tc.end)
+		if start != tc.want[0] || end != tc.want[1] {
+			t.Errorf("expected %v, got [%v %v]", tc.want, start, end)
+		}
+	}
+}
+
+func TestTrimPoints(t *testing.T) {
+	start, end := trimPoints(map[string]interface{}{"trim_start_ms": float64(250), "trim_end_ms": nil})
+	if start == nil || *start != 250 || end != nil {
+		t.Fatalf("unexpected trim points %v %v", start, end)
+	}
+}
+
+func TestDropDerived(t *testing.T) {
+	generated := map[string]interface{}{"thumbnail": map[string]interface{}{"source": "generated"}}
+	job := &Job{Row: map[string]interface{}{"thumbnail_url": "https://cdn.test/tricks/t1/videos/u1/v1/thumbnail.jpg", "metadata": generated}}
+	metadata := map[string]interface{}{"probe": "p", "hls": "h", "preview": "p", "thumbnail": "t"}
+	updates := map[string]interface{}{}
+	dropDerived(job, metadata, updates)
+	if len(metadata) != 1 || metadata["probe"] == nil {
+		t.Fatalf("expected only probe to be kept, got %v", metadata)
+	}
+	if value, ok := updates["thumbnail_url"]; !ok || value != nil {
+		t.Fatalf("expected a generated thumbnail_url to be cleared, got %v", updates)
+	}
+
+	// A thumbnail the user uploaded stays, at the same key once uploading dropped metadata.thumbnail
+	for _, url := range []string{"thumbnail.jpg", "thumbnail.png"} {
+		job.Row = map[string]interface{}{"thumbnail_url": "https://cdn.test/tricks/t1/videos/u1/v1/" + url, "metadata": map[string]interface{}{}}
+		updates = map[string]interface{}{}
+		dropDerived(job, map[string]interface{}{}, updates)
+		if _, ok := updates["thumbnail_url"]; ok {
+			t.Fatalf("expected an uploaded %s to be kept, got %v", url, updates)
+		}
+	}
+}
+
+func TestDeleteDerived(t *testing.T) {
+	previous := clients.Storage
+	clients.Storage = storage.NewMemoryStore()
+	defer func() { clients.Storage = previous }()
+
+	ctx := context.Background()
+	job := &Job{MediaID: "v1", Key: "tricks/t1/videos/u1/v1"}
+	for _, name := range []string{"", "/thumbnail.jpg", "/preview.webp", "/sprites.vtt",
+		"/hls/master.m3u8", "/hls/720p/seg-000.ts", "/renditions/720p.mp4", "/trimmed/0-2000.mp4"} {
+		if err := clients.Storage.Put(ctx, job.Key+name, strings.NewReader("x"), 1, "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleteDerived(ctx, job)
+	objects, err := clients.Storage.List(ctx, job.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, strings.TrimPrefix(obj.Key, job.Key))
+	}
+	sort.Strings(keys)
+	if got := strings.Join(keys, " "); got != " /thumbnail.jpg /trimmed/0-2000.mp4" {
+		t.Fatalf("expected the upload, its uploaded thumbnail and the cut to remain, got %q", got)
+	}
+
+	// A generated thumbnail shows the old cut
+	job.Row = map[string]interface{}{
+		"thumbnail_url": "https://cdn.test/" + job.Key + "/thumbnail.jpg",
+		"metadata":      map[string]interface{}{"thumbnail": map[string]interface{}{"source": "generated"}},
+	}
+	deleteDerived(ctx, job)
+	if _, err := clients.Storage.Head(ctx, job.Key+"/thumbnail.jpg"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected the generated thumbnail to be deleted, got %v", err)
+	}
+}
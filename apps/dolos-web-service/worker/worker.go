@@ -0,0 +1,352 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/policy"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+// Options configures the worker
+type Options struct {
+	Concurrency  int           // jobs processed at once
+	StepTimeout  time.Duration // per attempt, unless the step sets its own
+	Retries      int           // extra attempts after a step fails
+	RetryBackoff time.Duration // multiplied by the attempt number
+	PollInterval time.Duration // how often to look for completed uploads nobody notified us about
+	StaleAfter   time.Duration // processing rows untouched this long are reclaimed
+}
+
+type task struct {
+	cfg types.MediaConfig
+	id  string
+	// stale is the updated_at of a stuck processing row being reclaimed
+	stale string
+}
+
+// Worker claims completed uploads and runs them through the processing pipeline.
+// The claim is a conditional update, so several workers can share the same tables.
+type Worker struct {
+	opts       Options
+	steps      []Step // for videos
+	imageSteps []Step
+	loader     policy.Loader
+	queue      chan task
+}
+
+func New(opts Options, steps []Step, imageSteps []Step) *Worker {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	return &Worker{
+		opts:       opts,
+		steps:      steps,
+		imageSteps: imageSteps,
+		loader:     repository.NewPolicyLoader(clients.Media, clients.Parents),
+		queue:      make(chan task, 100),
+	}
+}
+
+// Enqueue asks the worker to process a media row that just completed. If the queue is
+// full the row is left for the next poll.
+func (w *Worker) Enqueue(cfg types.MediaConfig, videoId string) {
+	select {
+	case w.queue <- task{cfg: cfg, id: videoId}:
+	default:
+		log.Printf("Worker queue full, %s %s will be picked up by the next poll", cfg.Table, videoId)
+	}
+}
+
+// Run processes jobs until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	log.Printf("Worker started: %d workers, steps %v, image steps %v", w.opts.Concurrency, stepNames(w.steps), stepNames(w.imageSteps))
+
+	done := make(chan struct{})
+	for i := 0; i < w.opts.Concurrency; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-w.queue:
+					w.process(ctx, t)
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		w.poll(ctx)
+		select {
+		case <-ctx.Done():
+			for i := 0; i < w.opts.Concurrency; i++ {
+				<-done
+			}
+			log.Println("Worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll queues completed rows that haven't been processed and processing rows that went stale
+func (w *Worker) poll(ctx context.Context) {
+	staleBefore := time.Now().Add(-w.opts.StaleAfter)
+	for _, cfg := range types.MediaConfigs {
+		filters := []repository.StatusFilter{
+			{UploadStatus: "completed", Unprocessed: true},
+			{UploadStatus: "processing", UpdatedBefore: staleBefore},
+		}
+		for i, filter := range filters {
+			rows, err := clients.Media.ListMediaByStatus(ctx, cfg, filter, cap(w.queue))
+			if err != nil {
+				log.Printf("Worker poll of %s failed: %v", cfg.Table, err)
+				continue
+			}
+			for _, row := range rows {
+				t := task{cfg: cfg, id: row.ID}
+				if i == 1 {
+					t.stale = row.UpdatedAt
+					log.Printf("Reclaiming %s %s stuck in processing since %s", cfg.Table, t.id, t.stale)
+				}
+				select {
+				case w.queue <- t:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// process claims one media row and runs the pipeline on it
+func (w *Worker) process(ctx context.Context, t task) {
+	claimed, err := w.claim(ctx, t)
+	if err != nil {
+		log.Printf("Failed to claim %s %s: %v", t.cfg.Table, t.id, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	started := time.Now()
+	job, err := w.prepare(ctx, t.cfg, t.id)
+	if job != nil && job.WorkDir != "" {
+		defer os.RemoveAll(job.WorkDir)
+	}
+	if err != nil {
+		w.finish(ctx, t.cfg, t.id, job, "fetch", err)
+		return
+	}
+
+	steps := w.steps
+	if job.Row["media_type"] == "image" {
+		steps = w.imageSteps
+	}
+	for _, step := range steps {
+		if err := w.runStep(ctx, step, job); err != nil {
+			w.finish(ctx, t.cfg, t.id, job, step.Name(), err)
+			return
+		}
+		w.heartbeat(ctx, t.cfg, t.id)
+	}
+
+	w.finish(ctx, t.cfg, t.id, job, "", nil)
+	log.Printf("Processed %s %s in %s", t.cfg.Table, t.id, time.Since(started).Round(time.Millisecond))
+}
+
+// claim moves a completed (or stale processing) row to processing. It returns false if
+// another worker got there first or the row isn't eligible anymore.
+func (w *Worker) claim(ctx context.Context, t task) (bool, error) {
+	cond := repository.MediaCondition{UploadStatus: "completed", Unprocessed: true}
+	if t.stale != "" {
+		cond = repository.MediaCondition{UploadStatus: "processing", UpdatedAt: t.stale}
+	}
+	return clients.Media.UpdateMediaIf(ctx, t.cfg, t.id, cond, map[string]interface{}{
+		"upload_status": "processing",
+		"updated_at":    time.Now().Format(time.RFC3339),
+	})
+}
+
+// prepare loads the row and downloads the upload into a scratch directory
+func (w *Worker) prepare(ctx context.Context, cfg types.MediaConfig, videoId string) (*Job, error) {
+	media, err := w.loader.Media(ctx, cfg, videoId)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		Config:   cfg,
+		MediaID:  videoId,
+		ParentID: media.ParentID,
+		OwnerID:  media.OwnerID,
+		Row:      media.Row,
+		Key:      cfg.VideoKey(media.ParentID, media.OwnerID, videoId),
+		Metadata: map[string]interface{}{},
+		Updates:  map[string]interface{}{},
+	}
+
+	job.WorkDir, err = os.MkdirTemp("", "dolos-job-*")
+	if err != nil {
+		return job, err
+	}
+	job.Source = filepath.Join(job.WorkDir, "source")
+
+	err = w.retry(ctx, "fetch", w.opts.StepTimeout, func(ctx context.Context) error {
+		return download(ctx, job.Key, job.Source)
+	})
+	return job, err
+}
+
+func download(ctx context.Context, key, dest string) error {
+	body, _, err := clients.Storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runStep runs a step with its timeout and retries
+func (w *Worker) runStep(ctx context.Context, step Step, job *Job) error {
+	timeout := w.opts.StepTimeout
+	if s, ok := step.(timeoutStep); ok {
+		timeout = s.Timeout()
+	}
+	return w.retry(ctx, step.Name(), timeout, func(ctx context.Context) error {
+		return step.Run(ctx, job)
+	})
+}
+
+// retry calls fn until it succeeds, is rejected or runs out of attempts
+func (w *Worker) retry(ctx context.Context, name string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt <= w.opts.Retries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying %s (attempt %d of %d): %v", name, attempt+1, w.opts.Retries+1, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * w.opts.RetryBackoff):
+			}
+		}
+
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = fn(stepCtx)
+		cancel()
+
+		if err == nil || isRejected(err) || ctx.Err() != nil {
+			return err
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("%s timed out after %s", name, timeout)
+		}
+	}
+	return err
+}
+
+// heartbeat touches updated_at so a long pipeline isn't reclaimed as stale
+func (w *Worker) heartbeat(ctx context.Context, cfg types.MediaConfig, videoId string) {
+	if _, err := clients.Media.UpdateMedia(ctx, cfg, videoId, "processing", map[string]interface{}{
+		"updated_at": time.Now().Format(time.RFC3339),
+	}); err != nil {
+		log.Printf("Failed to update heartbeat for %s %s: %v", cfg.Table, videoId, err)
+	}
+}
+
+// finish writes the job result. A failed step marks the row failed, unless the worker is
+// shutting down, in which case the row goes back to completed to be picked up again, or
+// it's the re-trim of a processed video, which keeps its previous cut.
+func (w *Worker) finish(ctx context.Context, cfg types.MediaConfig, videoId string, job *Job, failedStep string, stepErr error) {
+	var row map[string]interface{}
+	fields := map[string]interface{}{}
+	updates := map[string]interface{}{}
+	if job != nil {
+		row = job.Row
+		for k, v := range job.Metadata {
+			fields[k] = v
+		}
+		for k, v := range job.Updates {
+			updates[k] = v
+		}
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	metadata, _ := row["metadata"].(map[string]interface{})
+	previousTrim, _ := metadata["previous_trim"].(map[string]interface{})
+	switch {
+	case stepErr == nil:
+		fields["processed_at"] = now
+		updates["upload_status"] = "completed"
+	case ctx.Err() != nil:
+		log.Printf("Worker stopping, releasing %s %s", cfg.Table, videoId)
+		fields = map[string]interface{}{}
+		updates = map[string]interface{}{"upload_status": "completed"}
+	case failedStep == "trim" && previousTrim != nil:
+		// A re-trim of a video that was up. The trim step hadn't switched the row yet,
+		// so its previous cut and derived files are intact: restore the trim points
+		// that cut was made from and record the failure next to it.
+		log.Printf("Re-trimming %s %s failed, keeping the previous cut: %v", cfg.Table, videoId, stepErr)
+		fields = map[string]interface{}{
+			"processed_at": now,
+			"trim_failure": map[string]interface{}{
+				"reason":    stepErr.Error(),
+				"start_ms":  row["trim_start_ms"],
+				"end_ms":    row["trim_end_ms"],
+				"failed_at": now,
+			},
+		}
+		updates = map[string]interface{}{
+			"trim_start_ms": previousTrim["start_ms"],
+			"trim_end_ms":   previousTrim["end_ms"],
+			"upload_status": "completed",
+		}
+	default:
+		log.Printf("Processing %s %s failed at %s: %v", cfg.Table, videoId, failedStep, stepErr)
+		fields["processed_at"] = now
+		fields["failure_reason"] = stepErr.Error()
+		fields["failed_step"] = failedStep
+		fields["failed_at"] = now
+		updates["upload_status"] = "failed"
+	}
+
+	if len(fields) > 0 {
+		updates["metadata"] = types.MergeMetadata(row, fields, "previous_trim")
+	}
+	updates["updated_at"] = now
+
+	// Written even when shutting down, that's what releases the row
+	if _, err := clients.Media.UpdateMedia(context.WithoutCancel(ctx), cfg, videoId, "processing", updates); err != nil {
+		log.Printf("Failed to save processing result for %s %s: %v", cfg.Table, videoId, err)
+	}
+}
+
+func stepNames(steps []Step) []string {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Name())
+	}
+	return names
+}
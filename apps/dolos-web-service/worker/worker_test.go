@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperbolic/dolos-web-service/clients"
+	"github.com/hyperbolic/dolos-web-service/repository"
+	"github.com/hyperbolic/dolos-web-service/types"
+)
+
+type fakeStep struct {
+	name  string
+	fails int // attempts that fail before the step succeeds
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (s *fakeStep) Name() string { return s.name }
+
+func (s *fakeStep) Run(ctx context.Context, job *Job) error {
+	s.calls++
+	if s.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.delay):
+		}
+	}
+	if s.calls <= s.fails {
+		return s.err
+	}
+	return nil
+}
+
+func newTestWorker(retries int, timeout time.Duration) *Worker {
+	return New(Options{Retries: retries, StepTimeout: timeout, RetryBackoff: time.Millisecond}, nil, nil)
+}
+
+func TestRunStepRetries(t *testing.T) {
+	w := newTestWorker(2, time.Second)
+
+	flaky := &fakeStep{name: "flaky", fails: 2, err: errors.New("transient")}
+	if err := w.runStep(context.Background(), flaky, &Job{}); err != nil || flaky.calls != 3 {
+		t.Fatalf("expected success on the third attempt, got %v after %d calls", err, flaky.calls)
+	}
+
+	broken := &fakeStep{name: "broken", fails: 10, err: errors.New("still broken")}
+	if err := w.runStep(context.Background(), broken, &Job{}); err == nil || broken.calls != 3 {
+		t.Fatalf("expected failure after 3 attempts, got %v after %d calls", err, broken.calls)
+	}
+}
+
+func TestRunStepRejectIsNotRetried(t *testing.T) {
+	w := newTestWorker(2, time.Second)
+	step := &fakeStep{name: "probe", fails: 10, err: Reject("video is %ds long", 30)}
+
+	err := w.runStep(context.Background(), step, &Job{})
+	if !isRejected(err) || step.calls != 1 {
+		t.Fatalf("expected a single rejected attempt, got %v after %d calls", err, step.calls)
+	}
+}
+
+func TestRunStepTimeout(t *testing.T) {
+	w := newTestWorker(1, 10*time.Millisecond)
+	step := &fakeStep{name: "slow", fails: 10, delay: time.Second}
+
+	err := w.runStep(context.Background(), step, &Job{})
+	if err == nil || !strings.Contains(err.Error(), "timed out") || step.calls != 2 {
+		t.Fatalf("expected timeout after 2 attempts, got %v after %d calls", err, step.calls)
+	}
+}
+
+func TestBuildPipeline(t *testing.T) {
+	Register("test-step", func(tools *FFmpeg) (Step, error) { return &fakeStep{name: "test-step"}, nil })
+	defer delete(registry, "test-step")
+
+	steps, err := BuildPipeline([]string{" test-step", ""}, &FFmpeg{})
+	if err != nil || len(steps) != 1 || steps[0].Name() != "test-step" {
+		t.Fatalf("unexpected pipeline %v (%v)", steps, err)
+	}
+
+	if _, err := BuildPipeline([]string{"missing"}, &FFmpeg{}); err == nil {
+		t.Fatal("expected unknown step to be rejected")
+	}
+}
+
+func TestPollClaimAndFinish(t *testing.T) {
+	records := repository.NewMemory()
+	previous := clients.Media
+	clients.Media = records
+	defer func() { clients.Media = previous }()
+
+	ctx := context.Background()
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	records.AddParent(cfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	add := func(id, status string, updated time.Time, metadata map[string]interface{}) {
+		if err := records.AddMedia(cfg, repository.Media{ID: id, ParentRecordID: "ut-1", MediaType: "video", UploadStatus: status,
+			Metadata: metadata, UpdatedAt: updated.UTC().Format(time.RFC3339Nano)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	add("new", "completed", now, nil)
+	add("done", "completed", now, map[string]interface{}{"processed_at": "2026-01-01T00:00:00Z"})
+	add("stuck", "processing", now.Add(-time.Hour), nil)
+	add("busy", "processing", now, nil)
+
+	w := New(Options{StaleAfter: 10 * time.Minute}, nil, nil)
+	w.poll(ctx)
+	var queued []task
+	for len(w.queue) > 0 {
+		queued = append(queued, <-w.queue)
+	}
+	if len(queued) != 2 || queued[0].id != "new" || queued[1].id != "stuck" || queued[1].stale == "" {
+		t.Fatalf("expected new and stuck to be queued, got %+v", queued)
+	}
+
+	for _, task := range queued {
+		if claimed, err := w.claim(ctx, task); err != nil || !claimed {
+			t.Fatalf("expected to claim %s, got %v %v", task.id, claimed, err)
+		}
+		// A second worker holding the same task loses
+		if claimed, _ := w.claim(ctx, task); claimed {
+			t.Fatalf("expected %s to be claimed only once", task.id)
+		}
+	}
+
+	w.finish(ctx, cfg, "new", &Job{Row: map[string]interface{}{}}, "", nil)
+	media, _ := records.GetMedia(ctx, cfg, "new")
+	if media.UploadStatus != "completed" || media.Metadata["processed_at"] == nil {
+		t.Fatalf("expected a processed row, got %+v", media)
+	}
+}
+
+func TestFailedRetrimKeepsPreviousCut(t *testing.T) {
+	records := repository.NewMemory()
+	previous := clients.Media
+	clients.Media = records
+	defer func() { clients.Media = previous }()
+
+	ctx := context.Background()
+	cfg := types.MediaConfigs[types.VideoTypeTrick]
+	records.AddParent(cfg, repository.Parent{ID: "ut-1", UserID: "alice", ParentID: "trick-1"})
+	start, end, newStart := int64(1000), int64(4000), int64(9000)
+	metadata := map[string]interface{}{
+		"trim":          map[string]interface{}{"start_ms": float64(1000), "end_ms": float64(4000)},
+		"previous_trim": map[string]interface{}{"start_ms": float64(start), "end_ms": float64(end)},
+	}
+	for _, id := range []string{"retrim", "first"} {
+		if err := records.AddMedia(cfg, repository.Media{ID: id, ParentRecordID: "ut-1", MediaType: "video", UploadStatus: "processing",
+			TrimStartMs: &newStart, Metadata: metadata}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Trimmed after the worker rejected it, there's no cut to go back to
+	records.UpdateMedia(ctx, cfg, "first", "", map[string]interface{}{"metadata": map[string]interface{}{}})
+
+	for _, id := range []string{"retrim", "first"} {
+		media, _ := records.GetMedia(ctx, cfg, id)
+		job := &Job{Row: media.Row(cfg), Metadata: map[string]interface{}{"probe": "original"}, Updates: map[string]interface{}{"duration_seconds": 12}}
+		w := newTestWorker(0, time.Second)
+		w.finish(ctx, cfg, id, job, "trim", Reject("trim range starts at the end of the 3.0s video"))
+	}
+
+	media, _ := records.GetMedia(ctx, cfg, "retrim")
+	failure, _ := media.Metadata["trim_failure"].(map[string]interface{})
+	switch {
+	case media.UploadStatus != "completed" || media.Metadata["processed_at"] == nil:
+		t.Fatalf("expected the video to stay up, got %+v", media)
+	case media.TrimStartMs == nil || *media.TrimStartMs != start || media.TrimEndMs == nil || *media.TrimEndMs != end:
+		t.Fatalf("expected the previous trim points back, got %v %v", media.TrimStartMs, media.TrimEndMs)
+	case failure["start_ms"] != float64(newStart) || !strings.Contains(failure["reason"].(string), "end of"):
+		t.Fatalf("expected the failed trim to be recorded, got %v", failure)
+	case media.Metadata["previous_trim"] != nil || media.Metadata["probe"] != nil || media.Metadata["failed_step"] != nil:
+		t.Fatalf("expected only the failure to be added to the metadata, got %v", media.Metadata)
+	case media.DurationSeconds != nil:
+		t.Fatalf("expected the cut's duration to be kept, got %v", *media.DurationSeconds)
+	}
+
+	if media, _ := records.GetMedia(ctx, cfg, "first"); media.UploadStatus != "failed" || media.Metadata["failed_step"] != "trim" {
+		t.Fatalf("expected a video without a previous cut to fail, got %+v", media)
+	}
+}